@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxReviewCommentLength is the longest review comment accepted, in characters
+const maxReviewCommentLength = 500
+
+var (
+	errNotPendingApproval = errors.New("not waiting for approval")
+	errExpensesNotInGroup = errors.New("some expenses were not found in this group")
+)
+
+type ReviewExpenseInput struct {
+	Comment string `json:"comment"`
+}
+
+type BulkApproveInput struct {
+	ExpenseIDs []uint `json:"expense_ids"`
+	Comment    string `json:"comment"`
+}
+
+// approvalStatusFor returns the approval state of an expense recorded or edited by a user.
+// In groups that require approval only the admin's own expenses skip the queue.
+func approvalStatusFor(group models.Group, userID uint) string {
+	if group.RequireApproval && group.AdminID != userID {
+		return models.ExpensePendingApproval
+	}
+	return models.ExpenseApproved
+}
+
+// resubmitForApproval sets the approval state of an edited expense. In groups that require approval an
+// edit by anyone but the admin sends the expense back to the queue, which is also how rejected expenses
+// are submitted again. It returns whether the expense just entered the queue.
+func resubmitForApproval(expense *models.Expense, group models.Group, userID uint) bool {
+	wasPending := expense.ApprovalStatus == models.ExpensePendingApproval
+	expense.ApprovalStatus = approvalStatusFor(group, userID)
+	if expense.ApprovalStatus != models.ExpensePendingApproval {
+		return false
+	}
+
+	expense.ReviewedByID = nil
+	expense.ReviewedAt = nil
+	expense.ReviewComment = ""
+	return !wasPending
+}
+
+// countsTowardsBalance reports whether an expense is part of the open balances of its group
+func countsTowardsBalance(expense models.Expense) bool {
+	return !expense.Settled && expense.ApprovalStatus == models.ExpenseApproved
+}
+
+// notifyApprovalRequested tells the group admin that an expense is waiting for approval
+func notifyApprovalRequested(db *gorm.DB, group models.Group, expense models.Expense) {
+	notifyUsers(db, []uint{group.AdminID}, fmt.Sprintf("\"%s\" in %s is waiting for your approval", expense.Description, group.Name))
+}
+
+// expensePayerIDs returns the primary payer and everyone else who contributed to an expense
+func expensePayerIDs(expense models.Expense) []uint {
+	payerIDs := []uint{expense.PaidByID}
+	for _, payer := range expense.Payers {
+		if payer.UserID != expense.PaidByID {
+			payerIDs = append(payerIDs, payer.UserID)
+		}
+	}
+	return payerIDs
+}
+
+// validateReviewComment trims a review comment and checks its length
+func validateReviewComment(comment string) (string, error) {
+	comment = strings.TrimSpace(comment)
+	if utf8.RuneCountInString(comment) > maxReviewCommentLength {
+		return "", errors.New("Comment can be at most 500 characters")
+	}
+	return comment, nil
+}
+
+// reviewExpenses approves or rejects pending expenses of a group in one transaction.
+// The comment is stored on every expense and added to its thread.
+func reviewExpenses(group models.Group, expenseIDs []uint, reviewerID uint, status string, comment string) ([]models.Expense, int, error) {
+	var expenses []models.Expense
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Payers").Preload("ExpenseShares").
+			Where("id IN ? AND group_id = ?", expenseIDs, group.ID).
+			Find(&expenses).Error; err != nil {
+			return err
+		}
+		if len(expenses) != len(expenseIDs) {
+			return errExpensesNotInGroup
+		}
+
+		now := time.Now()
+		for i := range expenses {
+			expense := &expenses[i]
+			if expense.ApprovalStatus != models.ExpensePendingApproval {
+				return fmt.Errorf("expense %d is %w", expense.ID, errNotPendingApproval)
+			}
+
+			expense.ApprovalStatus = status
+			expense.ReviewedByID = &reviewerID
+			expense.ReviewedAt = &now
+			expense.ReviewComment = comment
+			if err := tx.Model(expense).Select("approval_status", "reviewed_by_id", "reviewed_at", "review_comment").Updates(expense).Error; err != nil {
+				return err
+			}
+
+			body := status
+			if comment != "" {
+				body += ": " + comment
+			}
+			if err := addSystemComments(tx, expense.ID, reviewerID, []string{body}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errNotPendingApproval) {
+		return nil, fiber.StatusConflict, err
+	}
+	if errors.Is(err, errExpensesNotInGroup) {
+		return nil, fiber.StatusNotFound, err
+	}
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to review expenses: " + err.Error())
+	}
+
+	for _, expense := range expenses {
+		notifyUsers(database.DB, expensePayerIDs(expense), fmt.Sprintf("\"%s\" was %s by the admin of %s", expense.Description, status, group.Name))
+
+		// Approved expenses are new to the participants, just like expenses outside the queue
+		if status != models.ExpenseApproved {
+			continue
+		}
+		var recipients []uint
+		for _, share := range expense.ExpenseShares {
+			if !isExpensePayer(expense, share.UserID) {
+				recipients = append(recipients, share.UserID)
+			}
+		}
+		notifyUsers(database.DB, recipients, "New expense in group: "+group.Name)
+	}
+
+	return expenses, fiber.StatusOK, nil
+}
+
+// findAdminGroup loads a group and checks that the current user is its admin
+func findAdminGroup(ctx fiber.Ctx, groupID any) (models.Group, uint, int, error) {
+	var group models.Group
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return group, 0, fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	if err := database.DB.First(&group, groupID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return group, 0, fiber.StatusNotFound, errors.New("Group not found")
+		}
+		return group, 0, fiber.StatusInternalServerError, errors.New("Failed to fetch group: " + err.Error())
+	}
+
+	if group.AdminID != userID {
+		return group, 0, fiber.StatusForbidden, errors.New("Only the group admin can review expenses")
+	}
+
+	return group, userID, fiber.StatusOK, nil
+}
+
+// reviewExpense handles approving or rejecting a single expense
+func reviewExpense(ctx fiber.Ctx, decision string) error {
+	var input ReviewExpenseInput
+	if len(ctx.Body()) > 0 {
+		if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot parse JSON: " + err.Error(),
+			})
+		}
+	}
+
+	comment, err := validateReviewComment(input.Comment)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if decision == models.ExpenseRejected && comment == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A comment is required to reject an expense",
+		})
+	}
+
+	var expense models.Expense
+	if err := database.DB.Select("id, group_id").First(&expense, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Expense not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch expense: " + err.Error(),
+		})
+	}
+
+	group, userID, status, err := findAdminGroup(ctx, expense.GroupID)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	expenses, status, err := reviewExpenses(group, []uint{expense.ID}, userID, decision, comment)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Expense " + decision,
+		"expense": expenses[0],
+	})
+}
+
+// @Summary Get the approval queue of a group
+// @Description Get the expenses waiting for approval in a group, oldest first (admin only)
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.Expense "Pending expenses"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Group not found"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/approvals [get]
+func GetApprovalQueue(ctx fiber.Ctx) error {
+	group, _, status, err := findAdminGroup(ctx, ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var expenses []models.Expense
+	if err := database.DB.
+		Where("group_id = ? AND approval_status = ?", group.ID, models.ExpensePendingApproval).
+		Preload("PaidBy").
+		Preload("Category").
+		Preload("Payers.User").
+		Preload("ExpenseShares.User").
+		Order("updated_at ASC, id ASC").
+		Find(&expenses).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch approval queue: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(expenses)
+}
+
+// @Summary Approve an expense
+// @Description Approve a pending expense so it counts towards balances (admin only)
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param review body ReviewExpenseInput false "Optional comment"
+// @Success 200 {object} map[string]interface{} "Expense approved"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Failure 409 {object} map[string]interface{} "Expense is not waiting for approval"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/approve [post]
+func ApproveExpense(ctx fiber.Ctx) error {
+	return reviewExpense(ctx, models.ExpenseApproved)
+}
+
+// @Summary Reject an expense
+// @Description Reject a pending expense with a comment explaining why (admin only). The payers can edit it to submit it again.
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param review body ReviewExpenseInput true "Why the expense is rejected"
+// @Success 200 {object} map[string]interface{} "Expense rejected"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Failure 409 {object} map[string]interface{} "Expense is not waiting for approval"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/reject [post]
+func RejectExpense(ctx fiber.Ctx) error {
+	return reviewExpense(ctx, models.ExpenseRejected)
+}
+
+// @Summary Approve several expenses
+// @Description Approve pending expenses of a group at once (admin only). Nothing is approved if one of them can't be.
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param review body BulkApproveInput true "Expenses to approve and an optional comment"
+// @Success 200 {object} map[string]interface{} "Expenses approved"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Expense not found in this group"
+// @Failure 409 {object} map[string]interface{} "Expense is not waiting for approval"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/approvals/approve [post]
+func BulkApproveExpenses(ctx fiber.Ctx) error {
+	var input BulkApproveInput
+	if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+	if len(input.ExpenseIDs) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expense_ids is required",
+		})
+	}
+
+	comment, err := validateReviewComment(input.Comment)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	group, userID, status, err := findAdminGroup(ctx, ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Duplicates would make the found count look short
+	seen := make(map[uint]bool)
+	var expenseIDs []uint
+	for _, id := range input.ExpenseIDs {
+		if !seen[id] {
+			seen[id] = true
+			expenseIDs = append(expenseIDs, id)
+		}
+	}
+
+	expenses, status, err := reviewExpenses(group, expenseIDs, userID, models.ExpenseApproved, comment)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message":  fmt.Sprintf("%d expenses approved", len(expenses)),
+		"expenses": expenses,
+	})
+}
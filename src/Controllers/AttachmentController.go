@@ -0,0 +1,491 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/storage"
+	"github.com/tjens23/tabsplit-backend/src/thumbnail"
+	"gorm.io/gorm"
+)
+
+// MaxAttachmentSize is the largest file that can be uploaded, in bytes
+const MaxAttachmentSize = 10 << 20
+
+// maxAttachmentsPerRecord limits the number of files on one expense or settlement
+const maxAttachmentsPerRecord = 20
+
+// allowedAttachmentTypes maps the accepted content types, as detected from the file itself,
+// to the extension used in storage
+var allowedAttachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// memberGroupAccess checks that the current user is an active member of a group.
+// On failure it returns the HTTP status to respond with.
+func memberGroupAccess(ctx fiber.Ctx, groupID uint) (uint, int, error) {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return 0, fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", groupID, userID, true).First(&groupMember).Error; err != nil {
+		return 0, fiber.StatusForbidden, errors.New("You are not a member of this group")
+	}
+
+	return userID, fiber.StatusOK, nil
+}
+
+// recordGroupID finds the expense or settlement named by the :id route parameter and returns
+// its group. kind is "expense" or "settlement".
+func recordGroupID(ctx fiber.Ctx, kind string) (uint, int, error) {
+	var groupID uint
+	var err error
+	if kind == "expense" {
+		var expense models.Expense
+		err = database.DB.Select("id, group_id").First(&expense, ctx.Params("id")).Error
+		groupID = expense.GroupID
+	} else {
+		var settlement models.Settlement
+		err = database.DB.Select("id, group_id").First(&settlement, ctx.Params("id")).Error
+		groupID = settlement.GroupID
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		return 0, fiber.StatusNotFound, errors.New(strings.ToUpper(kind[:1]) + kind[1:] + " not found")
+	}
+	if err != nil {
+		return 0, fiber.StatusInternalServerError, errors.New("Failed to fetch " + kind + ": " + err.Error())
+	}
+	return groupID, fiber.StatusOK, nil
+}
+
+// attachmentGroupID returns the group an attachment belongs to through its expense or settlement
+func attachmentGroupID(attachment models.Attachment) (uint, error) {
+	if attachment.ExpenseID != nil {
+		var expense models.Expense
+		err := database.DB.Select("id, group_id").First(&expense, *attachment.ExpenseID).Error
+		return expense.GroupID, err
+	}
+
+	var settlement models.Settlement
+	err := database.DB.Select("id, group_id").First(&settlement, *attachment.SettlementID).Error
+	return settlement.GroupID, err
+}
+
+// findAttachment loads the attachment named by the :id route parameter and checks group membership
+func findAttachment(ctx fiber.Ctx) (models.Attachment, uint, uint, int, error) {
+	var attachment models.Attachment
+	if err := database.DB.First(&attachment, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return attachment, 0, 0, fiber.StatusNotFound, errors.New("Attachment not found")
+		}
+		return attachment, 0, 0, fiber.StatusInternalServerError, errors.New("Failed to fetch attachment: " + err.Error())
+	}
+
+	groupID, err := attachmentGroupID(attachment)
+	if err != nil {
+		return attachment, 0, 0, fiber.StatusInternalServerError, errors.New("Failed to fetch attachment owner: " + err.Error())
+	}
+
+	userID, status, err := memberGroupAccess(ctx, groupID)
+	return attachment, groupID, userID, status, err
+}
+
+// removeAttachmentFiles deletes the stored files of attachments whose rows are already gone.
+// Failures only leave orphaned files behind, so they are logged and otherwise ignored.
+func removeAttachmentFiles(attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := storage.Default.Delete(key); err != nil {
+				log.Printf("Failed to delete attachment file %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// deleteAttachmentRecords deletes the attachment rows matching a condition and returns them,
+// so their files can be removed once the surrounding transaction has committed
+func deleteAttachmentRecords(tx *gorm.DB, query string, args ...interface{}) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	if err := tx.Where(query, args...).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	if err := tx.Delete(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// storeAttachment validates an uploaded file, saves it and its thumbnail and fills in the
+// attachment. On failure it returns the HTTP status to respond with.
+func storeAttachment(ctx fiber.Ctx, attachment *models.Attachment) (int, error) {
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		return fiber.StatusBadRequest, errors.New("A file is required in the \"file\" form field")
+	}
+	if header.Size > MaxAttachmentSize {
+		return fiber.StatusRequestEntityTooLarge, errors.New("File is larger than 10 MB")
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return fiber.StatusBadRequest, errors.New("Failed to read file: " + err.Error())
+	}
+	defer file.Close()
+
+	// The content type is detected from the file, not trusted from the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fiber.StatusBadRequest, errors.New("Failed to read file: " + err.Error())
+	}
+	contentType := http.DetectContentType(head[:n])
+	extension, ok := allowedAttachmentTypes[contentType]
+	if !ok {
+		return fiber.StatusUnsupportedMediaType, errors.New("Only JPEG, PNG, GIF, WebP and PDF files can be attached")
+	}
+
+	key, err := storage.NewKey("attachments", extension)
+	if err != nil {
+		return fiber.StatusInternalServerError, errors.New("Failed to store file: " + err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fiber.StatusInternalServerError, errors.New("Failed to store file: " + err.Error())
+	}
+	if err := storage.Default.Save(key, file); err != nil {
+		return fiber.StatusInternalServerError, errors.New("Failed to store file: " + err.Error())
+	}
+
+	name := strings.TrimSpace(filepath.Base(header.Filename))
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "attachment" + extension
+	}
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+
+	attachment.FileName = name
+	attachment.ContentType = contentType
+	attachment.Size = header.Size
+	attachment.StorageKey = key
+
+	// A missing thumbnail is not worth failing the upload for
+	if thumbnail.Supported(contentType) {
+		if thumbnailKey, err := storeThumbnail(file); err != nil {
+			log.Printf("Failed to create thumbnail for %s: %v", key, err)
+		} else {
+			attachment.ThumbnailKey = thumbnailKey
+			attachment.HasThumbnail = true
+		}
+	}
+
+	return fiber.StatusOK, nil
+}
+
+func storeThumbnail(file io.ReadSeeker) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if !thumbnail.DecodeConfigOK(file) {
+		return "", errors.New("image dimensions are not supported")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := thumbnail.Make(&buf, file); err != nil {
+		return "", err
+	}
+
+	key, err := storage.NewKey("thumbnails", ".jpg")
+	if err != nil {
+		return "", err
+	}
+	return key, storage.Default.Save(key, &buf)
+}
+
+// uploadAttachment handles an upload for an expense or a settlement
+func uploadAttachment(ctx fiber.Ctx, kind string) error {
+	groupID, status, err := recordGroupID(ctx, kind)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	userID, status, err := memberGroupAccess(ctx, groupID)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	column := kind + "_id"
+	var count int64
+	if err := database.DB.Model(&models.Attachment{}).Where(column+" = ?", ctx.Params("id")).Count(&count).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count attachments: " + err.Error(),
+		})
+	}
+	if count >= maxAttachmentsPerRecord {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "This " + kind + " already has the maximum number of attachments",
+		})
+	}
+
+	attachment := models.Attachment{UploadedByID: userID}
+	ownerID := uint(fiber.Params[int](ctx, "id"))
+	if kind == "expense" {
+		attachment.ExpenseID = &ownerID
+	} else {
+		attachment.SettlementID = &ownerID
+	}
+
+	if status, err := storeAttachment(ctx, &attachment); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := database.DB.Create(&attachment).Error; err != nil {
+		removeAttachmentFiles([]models.Attachment{attachment})
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save attachment: " + err.Error(),
+		})
+	}
+
+	database.DB.Preload("UploadedBy").First(&attachment, attachment.ID)
+
+	return ctx.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// listAttachments returns the attachments of an expense or a settlement
+func listAttachments(ctx fiber.Ctx, kind string) error {
+	groupID, status, err := recordGroupID(ctx, kind)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var attachments []models.Attachment
+	if err := database.DB.Where(kind+"_id = ?", ctx.Params("id")).
+		Preload("UploadedBy").
+		Order("created_at ASC").
+		Find(&attachments).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch attachments: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(attachments)
+}
+
+// @Summary Attach a file to an expense
+// @Description Upload a receipt as multipart form field "file". JPEG, PNG, GIF, WebP and PDF files up to 10 MB are accepted.
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param file formData file true "Receipt"
+// @Success 201 {object} models.Attachment "Attachment created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Failure 413 {object} map[string]interface{} "File too large"
+// @Failure 415 {object} map[string]interface{} "File type not allowed"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/attachments [post]
+func UploadExpenseAttachment(ctx fiber.Ctx) error {
+	return uploadAttachment(ctx, "expense")
+}
+
+// @Summary Get the attachments of an expense
+// @Description Get the metadata of all files attached to an expense
+// @Tags attachments
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {array} models.Attachment "List of attachments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/attachments [get]
+func GetExpenseAttachments(ctx fiber.Ctx) error {
+	return listAttachments(ctx, "expense")
+}
+
+// @Summary Attach a file to a settlement
+// @Description Upload proof of payment as multipart form field "file". JPEG, PNG, GIF, WebP and PDF files up to 10 MB are accepted.
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Param file formData file true "Proof of payment"
+// @Success 201 {object} models.Attachment "Attachment created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Failure 413 {object} map[string]interface{} "File too large"
+// @Failure 415 {object} map[string]interface{} "File type not allowed"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/attachments [post]
+func UploadSettlementAttachment(ctx fiber.Ctx) error {
+	return uploadAttachment(ctx, "settlement")
+}
+
+// @Summary Get the attachments of a settlement
+// @Description Get the metadata of all files attached to a settlement
+// @Tags attachments
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Success 200 {array} models.Attachment "List of attachments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/attachments [get]
+func GetSettlementAttachments(ctx fiber.Ctx) error {
+	return listAttachments(ctx, "settlement")
+}
+
+// sendAttachmentFile streams a stored file to the client
+func sendAttachmentFile(ctx fiber.Ctx, key, contentType, fileName string) error {
+	file, err := storage.Default.Open(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Attachment file not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to open attachment: " + err.Error(),
+		})
+	}
+
+	ctx.Set("Content-Type", contentType)
+	ctx.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": fileName}))
+	ctx.Set("X-Content-Type-Options", "nosniff")
+	return ctx.SendStream(file)
+}
+
+// @Summary Download an attachment
+// @Description Download the file of an attachment
+// @Tags attachments
+// @Produce octet-stream
+// @Param id path string true "Attachment ID"
+// @Success 200 {file} file "The file"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Attachment not found"
+// @Security ApiKeyAuth
+// @Router /attachments/{id} [get]
+func DownloadAttachment(ctx fiber.Ctx) error {
+	attachment, _, _, status, err := findAttachment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return sendAttachmentFile(ctx, attachment.StorageKey, attachment.ContentType, attachment.FileName)
+}
+
+// @Summary Download an attachment thumbnail
+// @Description Download a small JPEG preview of an image attachment
+// @Tags attachments
+// @Produce jpeg
+// @Param id path string true "Attachment ID"
+// @Success 200 {file} file "The thumbnail"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Attachment or thumbnail not found"
+// @Security ApiKeyAuth
+// @Router /attachments/{id}/thumbnail [get]
+func DownloadAttachmentThumbnail(ctx fiber.Ctx) error {
+	attachment, _, _, status, err := findAttachment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if !attachment.HasThumbnail {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "This attachment has no thumbnail",
+		})
+	}
+
+	name := strings.TrimSuffix(attachment.FileName, filepath.Ext(attachment.FileName)) + "-thumbnail.jpg"
+	return sendAttachmentFile(ctx, attachment.ThumbnailKey, "image/jpeg", name)
+}
+
+// @Summary Delete an attachment
+// @Description Delete an attachment and its file (only the uploader or the group admin can delete)
+// @Tags attachments
+// @Produce json
+// @Param id path string true "Attachment ID"
+// @Success 200 {object} map[string]interface{} "Attachment deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not allowed to delete this attachment"
+// @Failure 404 {object} map[string]interface{} "Attachment not found"
+// @Security ApiKeyAuth
+// @Router /attachments/{id} [delete]
+func DeleteAttachment(ctx fiber.Ctx) error {
+	attachment, groupID, userID, status, err := findAttachment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if attachment.UploadedByID != userID {
+		var group models.Group
+		if err := database.DB.First(&group, groupID).Error; err != nil || group.AdminID != userID {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the uploader or the group admin can delete this attachment",
+			})
+		}
+	}
+
+	if err := database.DB.Delete(&attachment).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete attachment: " + err.Error(),
+		})
+	}
+	removeAttachmentFiles([]models.Attachment{attachment})
+
+	return ctx.JSON(fiber.Map{
+		"message": "Attachment deleted successfully",
+	})
+}
@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/category"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
+
+// categoryHistoryLimit is how many recent categorized expenses are used for suggestions
+const categoryHistoryLimit = 200
+
+var ErrUnknownCategory = errors.New("category does not exist in this group")
+
+// Keywords is a comma separated list of words that suggest the category
+type CreateCategoryInput struct {
+	Name     string `json:"name"`
+	Keywords string `json:"keywords"`
+}
+
+type CategorySuggestionResponse struct {
+	Category *models.Category `json:"category"`
+	Source   category.Source  `json:"source"`
+}
+
+// CategoryReportEntry sums a category. Total is what was spent minus refunds and other income
+// received in that category.
+type CategoryReportEntry struct {
+	CategoryID *uint        `json:"category_id"`
+	Name       string       `json:"name"`
+	Count      int64        `json:"count"`
+	Spent      money.Amount `json:"spent"`
+	Received   money.Amount `json:"received"`
+	Total      money.Amount `json:"total"`
+}
+
+// groupCategories returns the group's custom categories followed by the built-in ones
+func groupCategories(groupID uint) ([]models.Category, error) {
+	var categories []models.Category
+	err := database.DB.Where("group_id = ? OR group_id IS NULL", groupID).
+		Order("group_id IS NULL, name").
+		Find(&categories).Error
+	return categories, err
+}
+
+// checkCategory returns an error unless the category is built in or belongs to the group
+func checkCategory(groupID uint, categoryID uint) error {
+	var count int64
+	if err := database.DB.Model(&models.Category{}).
+		Where("id = ? AND (group_id = ? OR group_id IS NULL)", categoryID, groupID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUnknownCategory
+	}
+	return nil
+}
+
+// suggestCategory picks a category for a description from the group's past expenses and the
+// category keywords. It returns nil when nothing matches.
+func suggestCategory(groupID uint, description string) (*models.Category, category.Source, error) {
+	categories, err := groupCategories(groupID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var history []category.Past
+	if err := database.DB.Model(&models.Expense{}).
+		Select("description, category_id").
+		Where("group_id = ? AND category_id IS NOT NULL", groupID).
+		Order("created_at DESC").
+		Limit(categoryHistoryLimit).
+		Scan(&history).Error; err != nil {
+		return nil, "", err
+	}
+
+	candidates := make([]category.Candidate, len(categories))
+	for i, c := range categories {
+		candidates[i] = category.Candidate{ID: c.ID, Keywords: category.ParseKeywords(c.Keywords)}
+	}
+
+	id, source, ok := category.Suggest(description, history, candidates)
+	if !ok {
+		return nil, "", nil
+	}
+	for i := range categories {
+		if categories[i].ID == id {
+			return &categories[i], source, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// @Summary Get categories for a group
+// @Description Get the built-in categories and the custom categories of a group
+// @Tags categories
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.Category "List of categories"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/categories [get]
+func GetCategories(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	categories, err := groupCategories(groupMember.GroupID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch categories: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(categories)
+}
+
+// @Summary Add a custom category to a group
+// @Description Create a category only available in this group
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param category body CreateCategoryInput true "Category data"
+// @Success 201 {object} models.Category "Category created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 409 {object} map[string]interface{} "Category already exists"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/categories [post]
+func CreateCategory(ctx fiber.Ctx) error {
+	input := new(CreateCategoryInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	name := strings.ToLower(strings.TrimSpace(input.Name))
+	if name == "" || len(name) > 50 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Category name must be between 1 and 50 characters",
+		})
+	}
+
+	var existing int64
+	if err := database.DB.Model(&models.Category{}).
+		Where("name = ? AND (group_id = ? OR group_id IS NULL)", name, groupMember.GroupID).
+		Count(&existing).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check categories: " + err.Error(),
+		})
+	}
+	if existing > 0 {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A category with this name already exists",
+		})
+	}
+
+	groupID := groupMember.GroupID
+	newCategory := models.Category{
+		GroupID:  &groupID,
+		Name:     name,
+		Keywords: strings.Join(category.ParseKeywords(input.Keywords), ","),
+	}
+	if err := database.DB.Create(&newCategory).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create category: " + err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(newCategory)
+}
+
+// @Summary Delete a custom category
+// @Description Delete a custom category of a group; its expenses become uncategorized (only admin can delete)
+// @Tags categories
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param categoryId path string true "Category ID"
+// @Success 200 {object} map[string]interface{} "Category deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Only admin can delete categories"
+// @Failure 404 {object} map[string]interface{} "Category not found"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/categories/{categoryId} [delete]
+func DeleteCategory(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	// Built-in categories have no group and can't be found here
+	var existing models.Category
+	if err := database.DB.Preload("Group").Where("id = ? AND group_id = ?", ctx.Params("categoryId"), ctx.Params("id")).First(&existing).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Category not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch category: " + err.Error(),
+		})
+	}
+
+	if existing.Group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only group admin can delete categories",
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Expense{}).Where("category_id = ?", existing.ID).Update("category_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&existing).Error
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete category: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Category deleted successfully",
+	})
+}
+
+// @Summary Suggest a category
+// @Description Suggest a category for an expense description from the group's past expenses and category keywords
+// @Tags categories
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param description query string true "Expense description"
+// @Success 200 {object} CategorySuggestionResponse "Suggested category, or null"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/categories/suggest [get]
+func SuggestCategory(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	suggestion, source, err := suggestCategory(groupMember.GroupID, ctx.Query("description"))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to suggest category: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(CategorySuggestionResponse{
+		Category: suggestion,
+		Source:   source,
+	})
+}
+
+// @Summary Get spending per category
+// @Description Get the number of entries per category with what was spent, the income received and the net total in the group currency, optionally between two dates (YYYY-MM-DD)
+// @Tags categories
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param from query string false "First day to include"
+// @Param to query string false "Last day to include"
+// @Success 200 {object} map[string]interface{} "Totals per category"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/categories/report [get]
+func GetCategoryReport(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Preload("Group").Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	query := database.DB.Table("expenses").
+		Select("expenses.category_id, COALESCE(categories.name, '') AS name, COUNT(*) AS count, "+
+			"COALESCE(SUM(expenses.base_amount) FILTER (WHERE expenses.type <> ?), 0)::bigint AS spent, "+
+			"COALESCE(SUM(expenses.base_amount) FILTER (WHERE expenses.type = ?), 0)::bigint AS received, "+
+			"COALESCE(SUM(CASE WHEN expenses.type = ? THEN -expenses.base_amount ELSE expenses.base_amount END), 0)::bigint AS total",
+			models.EntryIncome, models.EntryIncome, models.EntryIncome).
+		Joins("LEFT JOIN categories ON categories.id = expenses.category_id").
+		Where("expenses.group_id = ? AND expenses.deleted_at IS NULL", groupMember.GroupID)
+
+	if from := ctx.Query("from"); from != "" {
+		fromDate, err := parseDate(from)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from date, expected YYYY-MM-DD",
+			})
+		}
+		query = query.Where("expenses.occurred_at >= ?", fromDate)
+	}
+	if to := ctx.Query("to"); to != "" {
+		toDate, err := parseDate(to)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to date, expected YYYY-MM-DD",
+			})
+		}
+		query = query.Where("expenses.occurred_at <= ?", toDate)
+	}
+
+	var entries []CategoryReportEntry
+	if err := query.Group("expenses.category_id, categories.name").Order("total DESC").Scan(&entries).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build category report: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"currency":   groupMember.Group.Currency,
+		"categories": entries,
+	})
+}
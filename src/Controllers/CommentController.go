@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+)
+
+// maxCommentLength is the longest comment body accepted, in characters
+const maxCommentLength = 2000
+
+// mentionPattern matches @username; usernames may contain letters, digits, '_', '.' and '-'
+var mentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+type CommentInput struct {
+	Body string `json:"body"`
+}
+
+// mentionedMembers returns the active group members mentioned in a comment body
+func mentionedMembers(groupID uint, body string) ([]models.User, error) {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		// "@anna." at the end of a sentence mentions anna
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username != "" && !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	err := database.DB.Joins("JOIN group_members ON group_members.user_id = users.id").
+		Where("group_members.group_id = ? AND group_members.is_active = ? AND LOWER(users.username) IN ?", groupID, true, usernames).
+		Find(&users).Error
+	return users, err
+}
+
+// notifyMentions tells mentioned members about a comment, except the author and anyone in skip
+func notifyMentions(groupID uint, expense models.Expense, author models.User, body string, skip map[uint]bool) {
+	mentioned, err := mentionedMembers(groupID, body)
+	if err != nil {
+		println("Failed to resolve mentions: " + err.Error())
+		return
+	}
+
+	var recipients []uint
+	for _, user := range mentioned {
+		if user.ID != author.ID && !skip[user.ID] {
+			recipients = append(recipients, user.ID)
+		}
+	}
+	notifyUsers(database.DB, recipients, author.Username+" mentioned you on \""+expense.Description+"\"")
+}
+
+// addSystemComments records server-generated comments, e.g. about edits, on an expense
+func addSystemComments(tx *gorm.DB, expenseID uint, authorID uint, bodies []string) error {
+	for _, body := range bodies {
+		if err := tx.Create(&models.Comment{
+			ExpenseID: expenseID,
+			AuthorID:  authorID,
+			Body:      body,
+			IsSystem:  true,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// categoryName returns the name of a category for system comments
+func categoryName(categoryID *uint) string {
+	if categoryID == nil {
+		return "none"
+	}
+	var found models.Category
+	if err := database.DB.Select("name").First(&found, *categoryID).Error; err != nil {
+		return "unknown"
+	}
+	return found.Name
+}
+
+// describeExpenseChanges lists the differences between two versions of an expense as comment bodies
+func describeExpenseChanges(before, after models.Expense) []string {
+	var changes []string
+
+	if before.Amount != after.Amount || before.Currency != after.Currency {
+		changes = append(changes, fmt.Sprintf("amount changed from %s %s to %s %s",
+			before.Amount.Format(before.Currency), before.Currency, after.Amount.Format(after.Currency), after.Currency))
+	}
+	if before.Description != after.Description {
+		changes = append(changes, fmt.Sprintf("description changed from %q to %q", before.Description, after.Description))
+	}
+	if !before.OccurredAt.Equal(after.OccurredAt) {
+		changes = append(changes, fmt.Sprintf("date changed from %s to %s", before.OccurredAt.Format("2006-01-02"), after.OccurredAt.Format("2006-01-02")))
+	}
+	if before.SplitMode != after.SplitMode {
+		changes = append(changes, fmt.Sprintf("split changed from %s to %s", before.SplitMode, after.SplitMode))
+	}
+	if (before.CategoryID == nil) != (after.CategoryID == nil) || (before.CategoryID != nil && *before.CategoryID != *after.CategoryID) {
+		changes = append(changes, fmt.Sprintf("category changed from %s to %s", categoryName(before.CategoryID), categoryName(after.CategoryID)))
+	}
+
+	owed := make(map[uint]int64)
+	for _, share := range before.ExpenseShares {
+		owed[share.UserID] = int64(share.AmountOwed)
+	}
+	sharesChanged := len(before.ExpenseShares) != len(after.ExpenseShares)
+	for _, share := range after.ExpenseShares {
+		if amount, ok := owed[share.UserID]; !ok || amount != int64(share.AmountOwed) {
+			sharesChanged = true
+		}
+	}
+	if sharesChanged && before.Amount == after.Amount {
+		changes = append(changes, "shares changed")
+	}
+
+	paid := make(map[uint]int64)
+	for _, payer := range before.Payers {
+		paid[payer.UserID] = int64(payer.Amount)
+	}
+	payersChanged := len(before.Payers) != len(after.Payers)
+	for _, payer := range after.Payers {
+		if amount, ok := paid[payer.UserID]; !ok || amount != int64(payer.Amount) {
+			payersChanged = true
+		}
+	}
+	if payersChanged && before.Amount == after.Amount {
+		changes = append(changes, "payers changed")
+	}
+
+	return changes
+}
+
+// countComments fills CommentCount on each expense with its number of user comments
+func countComments(expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(expenses))
+	for i, expense := range expenses {
+		ids[i] = expense.ID
+	}
+
+	var counts []struct {
+		ExpenseID uint
+		Count     int64
+	}
+	if err := database.DB.Model(&models.Comment{}).
+		Select("expense_id, COUNT(*) AS count").
+		Where("expense_id IN ? AND is_system = ?", ids, false).
+		Group("expense_id").
+		Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	byExpense := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		byExpense[count.ExpenseID] = count.Count
+	}
+	for i := range expenses {
+		expenses[i].CommentCount = byExpense[expenses[i].ID]
+	}
+	return nil
+}
+
+// validateCommentBody trims a comment body and checks its length
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("Comment cannot be empty")
+	}
+	if len([]rune(body)) > maxCommentLength {
+		return "", fmt.Errorf("Comment cannot be longer than %d characters", maxCommentLength)
+	}
+	return body, nil
+}
+
+// findOwnComment loads the comment named by the :id route parameter and checks that the current
+// user wrote it and is still in the group
+func findOwnComment(ctx fiber.Ctx) (models.Comment, int, error) {
+	var comment models.Comment
+	if err := database.DB.Preload("Expense").Preload("Author").First(&comment, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return comment, fiber.StatusNotFound, errors.New("Comment not found")
+		}
+		return comment, fiber.StatusInternalServerError, errors.New("Failed to fetch comment: " + err.Error())
+	}
+
+	userID, status, err := memberGroupAccess(ctx, comment.Expense.GroupID)
+	if err != nil {
+		return comment, status, err
+	}
+
+	if comment.IsSystem || comment.AuthorID != userID {
+		return comment, fiber.StatusForbidden, errors.New("Only the author can change this comment")
+	}
+	return comment, fiber.StatusOK, nil
+}
+
+// @Summary Get the comments on an expense
+// @Description Get the discussion thread of an expense, oldest first, including system comments about edits
+// @Tags comments
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {array} models.Comment "List of comments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/comments [get]
+func GetComments(ctx fiber.Ctx) error {
+	groupID, status, err := recordGroupID(ctx, "expense")
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var comments []models.Comment
+	if err := database.DB.Where("expense_id = ?", ctx.Params("id")).
+		Preload("Author").
+		Order("created_at ASC, id ASC").
+		Find(&comments).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch comments: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(comments)
+}
+
+// @Summary Comment on an expense
+// @Description Add a comment to an expense. Group members mentioned as @username are notified.
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param comment body CommentInput true "Comment"
+// @Success 201 {object} models.Comment "Comment created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/comments [post]
+func CreateComment(ctx fiber.Ctx) error {
+	input := new(CommentInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	var expense models.Expense
+	if err := database.DB.First(&expense, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Expense not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch expense: " + err.Error(),
+		})
+	}
+
+	userID, status, err := memberGroupAccess(ctx, expense.GroupID)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	body, err := validateCommentBody(input.Body)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	comment := models.Comment{
+		ExpenseID: expense.ID,
+		AuthorID:  userID,
+		Body:      body,
+	}
+	if err := database.DB.Create(&comment).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create comment: " + err.Error(),
+		})
+	}
+
+	database.DB.Preload("Author").First(&comment, comment.ID)
+	notifyMentions(expense.GroupID, expense, comment.Author, body, nil)
+
+	return ctx.Status(fiber.StatusCreated).JSON(comment)
+}
+
+// @Summary Edit a comment
+// @Description Change the text of a comment (only the author can edit). Only newly mentioned members are notified.
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Param id path string true "Comment ID"
+// @Param comment body CommentInput true "Comment"
+// @Success 200 {object} models.Comment "Comment updated"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the author"
+// @Failure 404 {object} map[string]interface{} "Comment not found"
+// @Security ApiKeyAuth
+// @Router /comments/{id} [patch]
+func UpdateComment(ctx fiber.Ctx) error {
+	input := new(CommentInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	comment, status, err := findOwnComment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	body, err := validateCommentBody(input.Body)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// People mentioned before the edit have already been notified
+	alreadyMentioned := make(map[uint]bool)
+	if previous, err := mentionedMembers(comment.Expense.GroupID, comment.Body); err == nil {
+		for _, user := range previous {
+			alreadyMentioned[user.ID] = true
+		}
+	}
+
+	now := time.Now()
+	comment.Body = body
+	comment.EditedAt = &now
+	if err := database.DB.Model(&comment).Updates(map[string]interface{}{"body": body, "edited_at": now}).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update comment: " + err.Error(),
+		})
+	}
+
+	notifyMentions(comment.Expense.GroupID, comment.Expense, comment.Author, body, alreadyMentioned)
+
+	return ctx.JSON(comment)
+}
+
+// @Summary Delete a comment
+// @Description Delete a comment (only the author can delete; system comments can't be deleted)
+// @Tags comments
+// @Produce json
+// @Param id path string true "Comment ID"
+// @Success 200 {object} map[string]interface{} "Comment deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the author"
+// @Failure 404 {object} map[string]interface{} "Comment not found"
+// @Security ApiKeyAuth
+// @Router /comments/{id} [delete]
+func DeleteComment(ctx fiber.Ctx) error {
+	comment, status, err := findOwnComment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := database.DB.Delete(&comment).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete comment: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Comment deleted successfully",
+	})
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
+
+// maxDisputeReasonLength is the longest dispute reason accepted, in characters
+const maxDisputeReasonLength = 500
+
+type DisputeInput struct {
+	Reason string `json:"reason"`
+}
+
+// acknowledgeShares sets the acknowledgement of freshly built shares. The payers and the member who
+// recorded the expense accept their own share. Others keep an acceptance from the previous version when
+// their amount is unchanged and otherwise have to look at it again, which also clears disputes.
+func acknowledgeShares(shares []models.ExpenseShare, previous []models.ExpenseShare, payers []models.ExpensePayer, recordedBy uint) {
+	accepted := make(map[uint]money.Amount)
+	for _, share := range previous {
+		if share.Acknowledgement == models.ShareAccepted {
+			accepted[share.UserID] = share.AmountOwed
+		}
+	}
+
+	now := time.Now()
+	for i := range shares {
+		share := &shares[i]
+		share.Acknowledgement = models.SharePending
+		share.DisputeReason = ""
+		share.AcknowledgedAt = nil
+
+		amount, wasAccepted := accepted[share.UserID]
+		if share.UserID == recordedBy || isExpensePayer(models.Expense{Payers: payers}, share.UserID) || (wasAccepted && amount == share.AmountOwed) {
+			share.Acknowledgement = models.ShareAccepted
+			share.AcknowledgedAt = &now
+		}
+	}
+}
+
+// findOwnShare loads an expense together with the current user's share of it
+func findOwnShare(ctx fiber.Ctx) (models.Expense, models.ExpenseShare, int, error) {
+	var expense models.Expense
+	var share models.ExpenseShare
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return expense, share, fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	if err := database.DB.Preload("Payers").First(&expense, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return expense, share, fiber.StatusNotFound, errors.New("Expense not found")
+		}
+		return expense, share, fiber.StatusInternalServerError, errors.New("Failed to fetch expense: " + err.Error())
+	}
+
+	if err := database.DB.Where("expense_id = ? AND user_id = ?", expense.ID, userID).First(&share).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return expense, share, fiber.StatusForbidden, errors.New("You don't have a share in this expense")
+		}
+		return expense, share, fiber.StatusInternalServerError, errors.New("Failed to fetch expense share: " + err.Error())
+	}
+
+	if expense.Settled {
+		return expense, share, fiber.StatusConflict, errExpenseSettled
+	}
+
+	return expense, share, fiber.StatusOK, nil
+}
+
+// @Summary Accept a share of an expense
+// @Description Confirm that your share of an expense is right. Accepting a disputed share withdraws the dispute.
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {object} models.ExpenseShare "Accepted share"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "No share in this expense"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Failure 409 {object} map[string]interface{} "Expense is settled"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/accept [post]
+func AcceptExpenseShare(ctx fiber.Ctx) error {
+	expense, share, status, err := findOwnShare(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	wasDisputed := share.Acknowledgement == models.ShareDisputed
+	now := time.Now()
+	share.Acknowledgement = models.ShareAccepted
+	share.DisputeReason = ""
+	share.AcknowledgedAt = &now
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&share).Error; err != nil {
+			return err
+		}
+		if wasDisputed {
+			return addSystemComments(tx, expense.ID, share.UserID, []string{"withdrew their dispute and accepted their share"})
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept share: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(share)
+}
+
+// @Summary Dispute a share of an expense
+// @Description Tell the payers that your share of an expense is wrong. They are notified and can resolve the dispute by editing or deleting the expense.
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param dispute body DisputeInput true "Why the share is wrong"
+// @Success 200 {object} models.ExpenseShare "Disputed share"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "No share in this expense"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Failure 409 {object} map[string]interface{} "Expense is settled"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/dispute [post]
+func DisputeExpenseShare(ctx fiber.Ctx) error {
+	var input DisputeInput
+	if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A reason is required to dispute a share",
+		})
+	}
+	if utf8.RuneCountInString(reason) > maxDisputeReasonLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Reason can be at most 500 characters",
+		})
+	}
+
+	expense, share, status, err := findOwnShare(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if isExpensePayer(expense, share.UserID) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Payers can edit the expense instead of disputing it",
+		})
+	}
+
+	now := time.Now()
+	share.Acknowledgement = models.ShareDisputed
+	share.DisputeReason = reason
+	share.AcknowledgedAt = &now
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&share).Error; err != nil {
+			return err
+		}
+		return addSystemComments(tx, expense.ID, share.UserID, []string{"disputed their share: " + reason})
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to dispute share: " + err.Error(),
+		})
+	}
+
+	var disputer models.User
+	database.DB.Select("username").First(&disputer, share.UserID)
+
+	payerIDs := []uint{expense.PaidByID}
+	for _, payer := range expense.Payers {
+		if payer.UserID != expense.PaidByID {
+			payerIDs = append(payerIDs, payer.UserID)
+		}
+	}
+	notifyUsers(database.DB, payerIDs, disputer.Username+" disputed their share of \""+expense.Description+"\": "+reason)
+
+	return ctx.JSON(share)
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/currency"
+	"gorm.io/gorm"
+)
+
+type CreateExchangeRateInput struct {
+	FromCurrency string  `json:"from_currency"`
+	ToCurrency   string  `json:"to_currency"`
+	Rate         float64 `json:"rate"`
+}
+
+// @Summary Get exchange rates for a group
+// @Description Get the manually entered exchange rates of a group, newest first
+// @Tags exchange-rates
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.ExchangeRate "List of exchange rates"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/exchange-rates [get]
+func GetExchangeRates(ctx fiber.Ctx) error {
+	groupID := ctx.Params("id")
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", groupID, userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	var rates []models.ExchangeRate
+	if err := database.DB.Where("group_id = ?", groupID).
+		Preload("CreatedBy").
+		Order("created_at DESC").
+		Find(&rates).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch exchange rates: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(rates)
+}
+
+// @Summary Add an exchange rate to a group
+// @Description Enter a manual exchange rate, which takes precedence over the default rate table (only admin can add)
+// @Tags exchange-rates
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param rate body CreateExchangeRateInput true "Exchange rate data"
+// @Success 201 {object} models.ExchangeRate "Exchange rate created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Only admin can add rates"
+// @Failure 404 {object} map[string]interface{} "Group not found"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/exchange-rates [post]
+func CreateExchangeRate(ctx fiber.Ctx) error {
+	groupID := ctx.Params("id")
+	input := new(CreateExchangeRateInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, groupID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Group not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch group: " + err.Error(),
+		})
+	}
+
+	if group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only group admin can add exchange rates",
+		})
+	}
+
+	fromCurrency, err := currency.Normalize(input.FromCurrency)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid currency code: " + input.FromCurrency,
+		})
+	}
+
+	toCurrency := group.Currency
+	if input.ToCurrency != "" {
+		toCurrency, err = currency.Normalize(input.ToCurrency)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid currency code: " + input.ToCurrency,
+			})
+		}
+	}
+
+	if fromCurrency == toCurrency || input.Rate <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Rate must be positive and between two different currencies",
+		})
+	}
+
+	rate := models.ExchangeRate{
+		GroupID:      group.ID,
+		FromCurrency: fromCurrency,
+		ToCurrency:   toCurrency,
+		Rate:         input.Rate,
+		CreatedByID:  userID,
+	}
+
+	if err := database.DB.Create(&rate).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create exchange rate: " + err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(rate)
+}
+
+// @Summary Delete an exchange rate
+// @Description Remove a manual exchange rate from a group (only admin can delete). Expenses keep the rate they were created with.
+// @Tags exchange-rates
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param rateId path string true "Exchange rate ID"
+// @Success 200 {object} map[string]interface{} "Exchange rate deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Only admin can delete rates"
+// @Failure 404 {object} map[string]interface{} "Exchange rate not found"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/exchange-rates/{rateId} [delete]
+func DeleteExchangeRate(ctx fiber.Ctx) error {
+	groupID := ctx.Params("id")
+	rateID := ctx.Params("rateId")
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var rate models.ExchangeRate
+	if err := database.DB.Preload("Group").Where("id = ? AND group_id = ?", rateID, groupID).First(&rate).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Exchange rate not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch exchange rate: " + err.Error(),
+		})
+	}
+
+	if rate.Group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only group admin can delete exchange rates",
+		})
+	}
+
+	if err := database.DB.Delete(&rate).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete exchange rate: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Exchange rate deleted successfully",
+	})
+}
@@ -1,29 +1,460 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	database "github.com/tjens23/tabsplit-backend/src/Database"
 	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/currency"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/split"
 	"gorm.io/gorm"
 )
 
+// Amounts are integer minor units of the expense currency (øre for DKK, cents for EUR)
 type CreateExpenseShareInput struct {
-	UserID     uint    `json:"user_id"`
-	AmountOwed float64 `json:"amount_owed"`
+	UserID     uint         `json:"user_id"`
+	AmountOwed money.Amount `json:"amount_owed"`
+}
+
+// SplitParticipantInput is one participant of a split. Value depends on the split mode:
+// ignored for equal, an amount for exact, basis points for percentage, a weight for shares
+// and a +/- amount for adjustment.
+type SplitParticipantInput struct {
+	UserID uint  `json:"user_id"`
+	Value  int64 `json:"value"`
+}
+
+// ExpenseItemInput is a line on an itemized bill, shared evenly by the listed members
+type ExpenseItemInput struct {
+	Description string       `json:"description"`
+	Amount      money.Amount `json:"amount"`
+	UserIDs     []uint       `json:"user_ids"`
+}
+
+// ExpensePayerInput is one payer's contribution; contributions must add up to the expense amount
+type ExpensePayerInput struct {
+	UserID uint         `json:"user_id"`
+	Amount money.Amount `json:"amount"`
 }
 
 type CreateExpenseInput struct {
-	Amount        float64                   `json:"amount"`
-	Description   string                    `json:"description"`
-	GroupID       uint                      `json:"group_id"`
+	Amount       money.Amount            `json:"amount"`
+	Currency     string                  `json:"currency"`
+	Description  string                  `json:"description"`
+	GroupID      uint                    `json:"group_id"`
+	SplitMode    string                  `json:"split_mode"`
+	Participants []SplitParticipantInput `json:"participants"`
+
+	// Type is "expense" (default) or "income" for money received on behalf of the group; the payers
+	// are then the members who received it and the participants are credited their shares
+	Type string `json:"type"`
+
+	// CategoryID may be left out to have a category suggested from the description
+	CategoryID uint `json:"category_id"`
+
+	// OccurredAt is the day of the expense (YYYY-MM-DD). It defaults to today in Timezone,
+	// an IANA name such as Europe/Copenhagen, or UTC.
+	OccurredAt string `json:"occurred_at"`
+	Timezone   string `json:"timezone"`
+
+	// Payers defaults to the current user paying the full amount
+	Payers []ExpensePayerInput `json:"payers"`
+
+	// Items, charges and charge split are used by the itemized split mode; amount may then be
+	// omitted and is derived as items plus charges
+	Items         []ExpenseItemInput `json:"items"`
+	Tax           money.Amount       `json:"tax"`
+	Tip           money.Amount       `json:"tip"`
+	ServiceCharge money.Amount       `json:"service_charge"`
+	ChargeSplit   string             `json:"charge_split"`
+
+	// Deprecated: use split_mode "exact" with participants instead
 	ExpenseShares []CreateExpenseShareInput `json:"expense_shares"`
 }
 
+// UpdateExpenseInput re-splits with the stored split mode and participants unless new ones are given.
+// Without new payers, the existing contributions are scaled to the new amount.
 type UpdateExpenseInput struct {
-	Amount      float64 `json:"amount"`
-	Description string  `json:"description"`
+	Amount       money.Amount            `json:"amount"`
+	Currency     string                  `json:"currency"`
+	Description  string                  `json:"description"`
+	SplitMode    string                  `json:"split_mode"`
+	Participants []SplitParticipantInput `json:"participants"`
+	Payers       []ExpensePayerInput     `json:"payers"`
+	CategoryID   uint                    `json:"category_id"`
+	OccurredAt   string                  `json:"occurred_at"`
+
+	// Itemized expenses keep their stored items and charges unless new ones are given
+	Items         []ExpenseItemInput `json:"items"`
+	Tax           *money.Amount      `json:"tax"`
+	Tip           *money.Amount      `json:"tip"`
+	ServiceCharge *money.Amount      `json:"service_charge"`
+	ChargeSplit   string             `json:"charge_split"`
+}
+
+// buildExpensePayers validates payer contributions and converts them into the group currency
+func buildExpensePayers(expense models.Expense, groupCurrency string, inputs []ExpensePayerInput) ([]models.ExpensePayer, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("at least one payer is required")
+	}
+
+	sorted := make([]ExpensePayerInput, len(inputs))
+	copy(sorted, inputs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+
+	amounts := make([]money.Amount, len(sorted))
+	for i, payer := range sorted {
+		if i > 0 && sorted[i-1].UserID == payer.UserID {
+			return nil, fmt.Errorf("payer %d listed more than once", payer.UserID)
+		}
+		if payer.Amount <= 0 {
+			return nil, fmt.Errorf("payer %d must contribute a positive amount", payer.UserID)
+		}
+		amounts[i] = payer.Amount
+	}
+	if money.Sum(amounts) != expense.Amount {
+		return nil, errors.New("payer contributions must add up to the expense amount")
+	}
+
+	// Converting through the total keeps the payers' base amounts equal to the expense base amount
+	baseAmounts := convertShares(amounts, expense.ExchangeRate, expense.Currency, groupCurrency)
+
+	payers := make([]models.ExpensePayer, len(sorted))
+	for i, payer := range sorted {
+		payers[i] = models.ExpensePayer{
+			ExpenseID:  expense.ID,
+			UserID:     payer.UserID,
+			Amount:     payer.Amount,
+			BaseAmount: baseAmounts[i],
+		}
+	}
+	return payers, nil
+}
+
+// scaleExpensePayers spreads a new amount over the existing payers in proportion to what they paid before
+func scaleExpensePayers(payers []models.ExpensePayer, amount money.Amount) []ExpensePayerInput {
+	weights := make([]int64, len(payers))
+	for i, payer := range payers {
+		weights[i] = int64(payer.Amount)
+	}
+
+	scaled, err := money.Allocate(amount, weights)
+	if err != nil {
+		scaled, _ = money.AllocateEvenly(amount, len(payers))
+	}
+
+	inputs := make([]ExpensePayerInput, len(payers))
+	for i, payer := range payers {
+		inputs[i] = ExpensePayerInput{UserID: payer.UserID, Amount: scaled[i]}
+	}
+	return inputs
+}
+
+// isExpensePayer reports whether a user contributed to an expense; Payers must be loaded
+func isExpensePayer(expense models.Expense, userID uint) bool {
+	if expense.PaidByID == userID {
+		return true
+	}
+	for _, payer := range expense.Payers {
+		if payer.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// entrySign is 1 for expenses and -1 for income, where what was received counts against the
+// receivers and the shares are credited to the participants
+func entrySign(expense models.Expense) money.Amount {
+	if expense.Type == models.EntryIncome {
+		return -1
+	}
+	return 1
+}
+
+// basePaidBy returns how much a user contributed to an expense in the group currency, negative for
+// income they received; Payers must be loaded
+func basePaidBy(expense models.Expense, userID uint) money.Amount {
+	var paid money.Amount
+	for _, payer := range expense.Payers {
+		if payer.UserID == userID {
+			paid += payer.BaseAmount
+		}
+	}
+	return entrySign(expense) * paid
+}
+
+// baseOwedBy returns a user's share of an expense in the group currency, negative for their share of
+// income; ExpenseShares must be loaded
+func baseOwedBy(expense models.Expense, userID uint) money.Amount {
+	var owed money.Amount
+	for _, share := range expense.ExpenseShares {
+		if share.UserID == userID {
+			owed += share.BaseAmountOwed
+		}
+	}
+	return entrySign(expense) * owed
+}
+
+// parseEntryType checks the type of a new expense, defaulting to a regular expense
+func parseEntryType(value string) (string, error) {
+	switch value {
+	case "", models.EntryExpense:
+		return models.EntryExpense, nil
+	case models.EntryIncome:
+		return models.EntryIncome, nil
+	}
+	return "", errors.New("unknown type " + value + ", expected expense or income")
+}
+
+func toSplitParticipants(inputs []SplitParticipantInput) []split.Participant {
+	participants := make([]split.Participant, len(inputs))
+	for i, input := range inputs {
+		participants[i] = split.Participant{UserID: input.UserID, Value: input.Value}
+	}
+	return participants
+}
+
+// buildExpenseShares splits an expense between participants and converts every share into the group currency
+func buildExpenseShares(expense models.Expense, groupCurrency string, participants []split.Participant) ([]models.ExpenseShare, error) {
+	computed, err := split.Compute(split.Mode(expense.SplitMode), expense.Amount, participants)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := make([]money.Amount, len(computed))
+	for i, share := range computed {
+		amounts[i] = share.Amount
+	}
+	baseAmounts := convertShares(amounts, expense.ExchangeRate, expense.Currency, groupCurrency)
+
+	shares := make([]models.ExpenseShare, len(computed))
+	for i, share := range computed {
+		shares[i] = models.ExpenseShare{
+			ExpenseID:      expense.ID,
+			UserID:         share.UserID,
+			AmountOwed:     share.Amount,
+			BaseAmountOwed: baseAmounts[i],
+			SplitValue:     share.Value,
+		}
+	}
+	return shares, nil
+}
+
+func toExpenseItems(inputs []ExpenseItemInput) []models.ExpenseItem {
+	items := make([]models.ExpenseItem, len(inputs))
+	for i, input := range inputs {
+		items[i] = models.ExpenseItem{Description: input.Description, Amount: input.Amount}
+		for _, userID := range input.UserIDs {
+			items[i].Assignees = append(items[i].Assignees, models.ExpenseItemAssignee{UserID: userID})
+		}
+	}
+	return items
+}
+
+// buildItemizedShares derives shares from the items and charges of an expense. It fills in what each
+// assignee owes per item, and sets the expense amount to items plus charges when it wasn't given.
+func buildItemizedShares(expense *models.Expense, groupCurrency string, items []models.ExpenseItem) ([]models.ExpenseShare, error) {
+	chargeMode, err := split.ParseChargeMode(expense.ChargeSplit)
+	if err != nil {
+		return nil, err
+	}
+
+	splitItems := make([]split.Item, len(items))
+	for i, item := range items {
+		splitItems[i] = split.Item{Amount: item.Amount}
+		for _, assignee := range item.Assignees {
+			splitItems[i].UserIDs = append(splitItems[i].UserIDs, assignee.UserID)
+		}
+	}
+
+	charges := expense.Tax + expense.Tip + expense.ServiceCharge
+	result, err := split.ComputeItemized(splitItems, charges, chargeMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if expense.Amount == 0 {
+		expense.Amount = result.Total
+	} else if expense.Amount != result.Total {
+		return nil, fmt.Errorf("amount must equal the items plus charges (%s)", result.Total.Format(expense.Currency))
+	}
+	expense.BaseAmount = money.Convert(expense.Amount, expense.ExchangeRate, expense.Currency, groupCurrency)
+
+	for i := range items {
+		items[i].ID = 0
+		items[i].ExpenseID = expense.ID
+		items[i].Assignees = nil
+		for _, share := range result.ItemShares[i] {
+			items[i].Assignees = append(items[i].Assignees, models.ExpenseItemAssignee{UserID: share.UserID, Amount: share.Amount})
+		}
+	}
+
+	amounts := make([]money.Amount, len(result.Shares))
+	for i, share := range result.Shares {
+		amounts[i] = share.Amount
+	}
+	baseAmounts := convertShares(amounts, expense.ExchangeRate, expense.Currency, groupCurrency)
+
+	shares := make([]models.ExpenseShare, len(result.Shares))
+	for i, share := range result.Shares {
+		shares[i] = models.ExpenseShare{
+			ExpenseID:      expense.ID,
+			UserID:         share.UserID,
+			AmountOwed:     share.Amount,
+			BaseAmountOwed: baseAmounts[i],
+		}
+	}
+	return shares, nil
+}
+
+// saveExpenseChildren creates the payers, shares and items of an expense inside a transaction
+func saveExpenseChildren(tx *gorm.DB, expenseID uint, payers []models.ExpensePayer, shares []models.ExpenseShare, items []models.ExpenseItem) error {
+	for i := range payers {
+		payers[i].ExpenseID = expenseID
+		if err := tx.Create(&payers[i]).Error; err != nil {
+			return fmt.Errorf("create expense payer: %w", err)
+		}
+	}
+
+	for i := range shares {
+		shares[i].ExpenseID = expenseID
+		if err := tx.Create(&shares[i]).Error; err != nil {
+			return fmt.Errorf("create expense share: %w", err)
+		}
+	}
+
+	// Creating an item also creates its assignees
+	for i := range items {
+		items[i].ExpenseID = expenseID
+		if err := tx.Create(&items[i]).Error; err != nil {
+			return fmt.Errorf("create expense item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteExpenseChildren removes the payers, shares and items of the given expenses
+func deleteExpenseChildren(tx *gorm.DB, expenseIDs []uint) error {
+	if len(expenseIDs) == 0 {
+		return nil
+	}
+
+	itemIDs := tx.Model(&models.ExpenseItem{}).Select("id").Where("expense_id IN ?", expenseIDs)
+	if err := tx.Where("expense_item_id IN (?)", itemIDs).Delete(&models.ExpenseItemAssignee{}).Error; err != nil {
+		return fmt.Errorf("delete expense item assignees: %w", err)
+	}
+	if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.ExpenseItem{}).Error; err != nil {
+		return fmt.Errorf("delete expense items: %w", err)
+	}
+	if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.ExpensePayer{}).Error; err != nil {
+		return fmt.Errorf("delete expense payers: %w", err)
+	}
+	if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.ExpenseShare{}).Error; err != nil {
+		return fmt.Errorf("delete expense shares: %w", err)
+	}
+
+	return nil
+}
+
+// checkGroupMembers returns an error naming the first user who is not an active member of the group
+func checkGroupMembers(groupID uint, userIDs []uint) error {
+	for _, userID := range userIDs {
+		var memberCheck models.GroupMember
+		if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", groupID, userID, true).First(&memberCheck).Error; err != nil {
+			return fmt.Errorf("user ID %d is not a member of this group", userID)
+		}
+	}
+	return nil
+}
+
+// convertShares converts share amounts into the group currency. The converted total is
+// allocated proportionally, so the converted shares add up exactly to the converted sum.
+func convertShares(amounts []money.Amount, rate float64, from, to string) []money.Amount {
+	converted := make([]money.Amount, len(amounts))
+	weights := make([]int64, len(amounts))
+	for i, amount := range amounts {
+		weights[i] = int64(amount)
+	}
+
+	allocated, err := money.Allocate(money.Convert(money.Sum(amounts), rate, from, to), weights)
+	if err != nil {
+		// Zero or mixed-sign shares; fall back to converting each share on its own
+		for i, amount := range amounts {
+			converted[i] = money.Convert(amount, rate, from, to)
+		}
+		return converted
+	}
+	return allocated
+}
+
+// lookupExchangeRate returns the rate from an expense currency into the group currency
+func lookupExchangeRate(group models.Group, expenseCurrency string) (float64, error) {
+	return currency.ForGroup(database.DB, group.ID).Rate(expenseCurrency, group.Currency)
+}
+
+// resolveExpenseCurrency validates the requested currency, defaulting to the group currency,
+// and returns it together with the rate into the group currency
+func resolveExpenseCurrency(group models.Group, requested string) (string, float64, error) {
+	if requested == "" {
+		return group.Currency, 1, nil
+	}
+
+	code, err := currency.Normalize(requested)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %s", err, requested)
+	}
+
+	rate, err := lookupExchangeRate(group, code)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s to %s: %w", code, group.Currency, err)
+	}
+
+	return code, rate, nil
+}
+
+// currencyErrorStatus maps currency lookup errors to an HTTP status
+func currencyErrorStatus(err error) int {
+	if errors.Is(err, currency.ErrInvalidCurrency) || errors.Is(err, currency.ErrRateNotFound) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+// resolveOccurredAt parses the day of an expense, defaulting to today in the given timezone
+func resolveOccurredAt(value, timezone string) (time.Time, error) {
+	if value != "" {
+		occurredAt, err := parseDate(value)
+		if err != nil {
+			return time.Time{}, errors.New("invalid occurred_at, expected YYYY-MM-DD")
+		}
+		return occurredAt, nil
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return time.Time{}, errors.New("unknown timezone " + timezone)
+		}
+	}
+	now := time.Now().In(location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// categoryErrorStatus maps a category lookup error to an HTTP status
+func categoryErrorStatus(err error) int {
+	if errors.Is(err, ErrUnknownCategory) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
 }
 
 // CreateExpense creates a new expense and splits it among specified users
@@ -52,52 +483,193 @@ func CreateExpense(ctx fiber.Ctx) error {
 		})
 	}
 
+	expenseCurrency, rate, err := resolveExpenseCurrency(groupMember.Group, input.Currency)
+	if err != nil {
+		return ctx.Status(currencyErrorStatus(err)).JSON(fiber.Map{
+			"error": "Failed to resolve currency: " + err.Error(),
+		})
+	}
+
+	// Older clients send precomputed shares, which are validated as an exact split
+	splitMode := input.SplitMode
+	participants := toSplitParticipants(input.Participants)
+	if splitMode == "" && len(input.ExpenseShares) > 0 {
+		splitMode = string(split.Exact)
+		participants = make([]split.Participant, len(input.ExpenseShares))
+		for i, share := range input.ExpenseShares {
+			participants[i] = split.Participant{UserID: share.UserID, Value: int64(share.AmountOwed)}
+		}
+	}
+
+	mode, err := split.ParseMode(splitMode)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid split: " + err.Error(),
+		})
+	}
+
+	occurredAt, err := resolveOccurredAt(input.OccurredAt, input.Timezone)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	entryType, err := parseEntryType(input.Type)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// Money coming in is an income entry with a positive amount rather than a negative expense
+	if input.Amount < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Amount must be positive; record refunds and other money received with type income",
+		})
+	}
+
 	// Create the expense
 	expense := models.Expense{
-		Amount:      input.Amount,
-		Description: input.Description,
-		GroupID:     input.GroupID,
-		PaidByID:    uint(userID),
+		Type:         entryType,
+		Amount:       input.Amount,
+		Currency:     expenseCurrency,
+		Description:  input.Description,
+		OccurredAt:   occurredAt,
+		GroupID:      input.GroupID,
+		PaidByID:     uint(userID),
+		ExchangeRate: rate,
+		BaseAmount:   money.Convert(input.Amount, rate, expenseCurrency, groupMember.Group.Currency),
+		SplitMode:    string(mode),
+
+		ApprovalStatus: approvalStatusFor(groupMember.Group, uint(userID)),
 	}
 
-	if err := database.DB.Create(&expense).Error; err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create expense: " + err.Error(),
-		})
+	if input.CategoryID != 0 {
+		if err := checkCategory(input.GroupID, input.CategoryID); err != nil {
+			return ctx.Status(categoryErrorStatus(err)).JSON(fiber.Map{
+				"error": "Invalid category: " + err.Error(),
+			})
+		}
+		expense.CategoryID = &input.CategoryID
+	} else if suggestion, _, err := suggestCategory(input.GroupID, input.Description); err != nil {
+		println("Failed to suggest category: " + err.Error())
+	} else if suggestion != nil {
+		expense.CategoryID = &suggestion.ID
 	}
 
-	// Create expense shares
-	for _, share := range input.ExpenseShares {
-		// Verify each user is a member of the group
-		var memberCheck models.GroupMember
-		if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", input.GroupID, share.UserID, true).First(&memberCheck).Error; err != nil {
+	var expenseShares []models.ExpenseShare
+	var expenseItems []models.ExpenseItem
+	if mode == split.Itemized {
+		chargeMode, err := split.ParseChargeMode(input.ChargeSplit)
+		if err != nil {
 			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "User ID " + strconv.Itoa(int(share.UserID)) + " is not a member of this group",
+				"error": "Invalid split: " + err.Error(),
 			})
 		}
+		expense.Tax = input.Tax
+		expense.Tip = input.Tip
+		expense.ServiceCharge = input.ServiceCharge
+		expense.ChargeSplit = string(chargeMode)
 
-		expenseShare := models.ExpenseShare{
-			ExpenseID:  expense.ID,
-			UserID:     share.UserID,
-			AmountOwed: share.AmountOwed,
-		}
+		expenseItems = toExpenseItems(input.Items)
+		expenseShares, err = buildItemizedShares(&expense, groupMember.Group.Currency, expenseItems)
+	} else {
+		expenseShares, err = buildExpenseShares(expense, groupMember.Group.Currency, participants)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid split: " + err.Error(),
+		})
+	}
 
-		if err := database.DB.Create(&expenseShare).Error; err != nil {
-			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create expense share: " + err.Error(),
-			})
-		}
+	payerInputs := input.Payers
+	if len(payerInputs) == 0 {
+		payerInputs = []ExpensePayerInput{{UserID: uint(userID), Amount: expense.Amount}}
+	}
+
+	expensePayers, err := buildExpensePayers(expense, groupMember.Group.Currency, payerInputs)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid payers: " + err.Error(),
+		})
+	}
+
+	// The current user records the expense but may not be among the payers
+	if !isExpensePayer(models.Expense{Payers: expensePayers}, uint(userID)) {
+		expense.PaidByID = expensePayers[0].UserID
+	}
+
+	participantIDs := make([]uint, 0, len(expenseShares)+len(expensePayers))
+	for _, share := range expenseShares {
+		participantIDs = append(participantIDs, share.UserID)
+	}
+	for _, payer := range expensePayers {
+		participantIDs = append(participantIDs, payer.UserID)
+	}
+	if err := checkGroupMembers(input.GroupID, participantIDs); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	acknowledgeShares(expenseShares, nil, expensePayers, uint(userID))
+
+	tx := database.DB.Begin()
+	if tx.Error != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start transaction: " + tx.Error.Error(),
+		})
+	}
+
+	if err := tx.Create(&expense).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create expense: " + err.Error(),
+		})
+	}
+
+	if err := saveExpenseChildren(tx, expense.ID, expensePayers, expenseShares, expenseItems); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create expense: " + err.Error(),
+		})
+	}
+
+	if err := recordRevision(tx, expense, uint(userID), expensePayers, expenseShares, expenseItems); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record revision: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to commit transaction: " + err.Error(),
+		})
 	}
 
 	// Load expense with relationships
-	database.DB.Preload("PaidBy").Preload("Group").Preload("ExpenseShares.User").First(&expense, expense.ID)
+	database.DB.Preload("PaidBy").Preload("Group").Preload("Category").Preload("Payers.User").Preload("ExpenseShares.User").Preload("Items.Assignees.User").First(&expense, expense.ID)
 
-	for _, share := range input.ExpenseShares {
-		if share.UserID == uint(userID) {
-			continue // Don't notify the person who paid
+	// Participants hear about the expense once the admin approves it
+	if expense.ApprovalStatus == models.ExpensePendingApproval {
+		notifyApprovalRequested(database.DB, groupMember.Group, expense)
+		return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"message": "Expense created and waiting for approval",
+			"expense": expense,
+		})
+	}
+
+	message := "New expense in group: " + groupMember.Group.Name
+	if expense.Type == models.EntryIncome {
+		message = "New income in group: " + groupMember.Group.Name
+	}
+	for _, share := range expenseShares {
+		if share.UserID == uint(userID) || isExpensePayer(expense, share.UserID) {
+			continue // Don't notify the people who paid
 		}
 		if err := database.DB.Create(&models.Notification{
-			Message: "New expense in group: " + groupMember.Group.Name,
+			Message: message,
 			UserID:  share.UserID,
 			New:     true,
 		}).Error; err != nil {
@@ -142,20 +714,46 @@ func GetExpenses(ctx fiber.Ctx) error {
 		})
 	}
 
-	var expenses []models.Expense
-	if err := database.DB.Where("group_id = ?", groupID).
-		Preload("PaidBy").
-		Preload("ExpenseShares.User").
-		Order("created_at DESC").
-		Find(&expenses).Error; err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch expenses: " + err.Error(),
+	page, status, err := findExpensePage(ctx, database.DB.Model(&models.Expense{}).Where("expenses.group_id = ?", groupID))
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	return ctx.JSON(fiber.Map{
-		"expenses": expenses,
-	})
+	return ctx.JSON(page)
+}
+
+// GetMyExpenses returns the expenses the current user paid for or shares in, across all their groups
+func GetMyExpenses(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	query := database.DB.Model(&models.Expense{}).
+		Where("expenses.group_id IN (SELECT group_id FROM group_members WHERE user_id = ? AND is_active = ?)", userID, true).
+		Where(`(EXISTS (SELECT 1 FROM expense_payers WHERE expense_payers.expense_id = expenses.id AND expense_payers.user_id = ?)
+			OR EXISTS (SELECT 1 FROM expense_shares WHERE expense_shares.expense_id = expenses.id AND expense_shares.user_id = ?))`, userID, userID)
+
+	if groupID, ok, err := parseUintQuery(ctx, "group_id"); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	} else if ok {
+		query = query.Where("expenses.group_id = ?", groupID)
+	}
+
+	page, status, err := findExpensePage(ctx, query)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(page)
 }
 
 // GetExpense returns a specific expense by ID
@@ -165,7 +763,11 @@ func GetExpense(ctx fiber.Ctx) error {
 	var expense models.Expense
 	if err := database.DB.Preload("PaidBy").
 		Preload("Group").
+		Preload("Category").
+		Preload("Payers.User").
 		Preload("ExpenseShares.User").
+		Preload("Items.Assignees.User").
+		Preload("Attachments.UploadedBy").
 		First(&expense, expenseID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -202,7 +804,7 @@ func GetExpense(ctx fiber.Ctx) error {
 	return ctx.JSON(expense)
 }
 
-// UpdateExpense updates an expense (only the person who paid can update)
+// UpdateExpense updates an expense and re-splits it (only the people who paid can update)
 func UpdateExpense(ctx fiber.Ctx) error {
 	expenseID := ctx.Params("id")
 	input := new(UpdateExpenseInput)
@@ -228,7 +830,7 @@ func UpdateExpense(ctx fiber.Ctx) error {
 	}
 
 	var expense models.Expense
-	if err := database.DB.First(&expense, expenseID).Error; err != nil {
+	if err := database.DB.Preload("Group").Preload("Payers").First(&expense, expenseID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Expense not found",
@@ -240,30 +842,254 @@ func UpdateExpense(ctx fiber.Ctx) error {
 	}
 
 	// Check if user paid for this expense
-	if expense.PaidByID != uint(userID) {
+	if !isExpensePayer(expense, uint(userID)) {
 		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Only the person who paid can update this expense",
+			"error": "Only the people who paid can update this expense",
+		})
+	}
+
+	if expense.Settled {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": settledExpenseError,
 		})
 	}
 
-	// Update expense
-	expense.Amount = input.Amount
-	expense.Description = input.Description
+	// Keep the original for the system comments describing the edit
+	before := expense
+	awaitsApproval := resubmitForApproval(&expense, expense.Group, uint(userID))
+
+	// A currency change looks up a fresh rate; otherwise the stored rate is kept
+	if input.Currency != "" && input.Currency != expense.Currency {
+		expenseCurrency, rate, err := resolveExpenseCurrency(expense.Group, input.Currency)
+		if err != nil {
+			return ctx.Status(currencyErrorStatus(err)).JSON(fiber.Map{
+				"error": "Failed to resolve currency: " + err.Error(),
+			})
+		}
+		expense.Currency = expenseCurrency
+		expense.ExchangeRate = rate
+	}
 
-	if err := database.DB.Save(&expense).Error; err != nil {
+	// Update expense; omitted fields keep their current value
+	if input.Amount != 0 {
+		expense.Amount = input.Amount
+	}
+	if input.Description != "" {
+		expense.Description = input.Description
+	}
+	if input.OccurredAt != "" {
+		occurredAt, err := resolveOccurredAt(input.OccurredAt, "")
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		expense.OccurredAt = occurredAt
+	}
+	if input.CategoryID != 0 {
+		if err := checkCategory(expense.GroupID, input.CategoryID); err != nil {
+			return ctx.Status(categoryErrorStatus(err)).JSON(fiber.Map{
+				"error": "Invalid category: " + err.Error(),
+			})
+		}
+		expense.CategoryID = &input.CategoryID
+	}
+	expense.BaseAmount = money.Convert(expense.Amount, expense.ExchangeRate, expense.Currency, expense.Group.Currency)
+
+	var existingShares []models.ExpenseShare
+	if err := database.DB.Where("expense_id = ?", expense.ID).Find(&existingShares).Error; err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update expense: " + err.Error(),
+			"error": "Failed to fetch expense shares: " + err.Error(),
 		})
 	}
+	before.ExpenseShares = existingShares
+
+	// Re-split with the original method and participants unless the client sends new ones
+	previousMode := expense.SplitMode
+	if input.SplitMode != "" {
+		mode, err := split.ParseMode(input.SplitMode)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid split: " + err.Error(),
+			})
+		}
+		expense.SplitMode = string(mode)
+	}
 
-	// If amount changed, update expense shares proportionally
 	var expenseShares []models.ExpenseShare
-	if err := database.DB.Where("expense_id = ?", expense.ID).Find(&expenseShares).Error; err == nil {
-		newSplitAmount := input.Amount / float64(len(expenseShares))
-		for _, share := range expenseShares {
-			share.AmountOwed = newSplitAmount
-			database.DB.Save(&share)
+	var expenseItems []models.ExpenseItem
+	if expense.SplitMode == string(split.Itemized) {
+		if input.Tax != nil {
+			expense.Tax = *input.Tax
+		}
+		if input.Tip != nil {
+			expense.Tip = *input.Tip
+		}
+		if input.ServiceCharge != nil {
+			expense.ServiceCharge = *input.ServiceCharge
+		}
+		if input.ChargeSplit != "" {
+			chargeMode, err := split.ParseChargeMode(input.ChargeSplit)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid split: " + err.Error(),
+				})
+			}
+			expense.ChargeSplit = string(chargeMode)
+		}
+
+		if len(input.Items) > 0 {
+			expenseItems = toExpenseItems(input.Items)
+		} else if previousMode == string(split.Itemized) {
+			if err := database.DB.Where("expense_id = ?", expense.ID).Preload("Assignees").Order("id").Find(&expenseItems).Error; err != nil {
+				return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to fetch expense items: " + err.Error(),
+				})
+			}
+		}
+
+		// The amount follows the items unless the client sends one to check against
+		if input.Amount == 0 {
+			expense.Amount = 0
+		}
+		expenseShares, err = buildItemizedShares(&expense, expense.Group.Currency, expenseItems)
+	} else {
+		participants := toSplitParticipants(input.Participants)
+		if len(participants) == 0 {
+			if expense.SplitMode != previousMode && expense.SplitMode != string(split.Equal) {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Participants are required when changing the split mode",
+				})
+			}
+			for _, share := range existingShares {
+				participants = append(participants, split.Participant{UserID: share.UserID, Value: share.SplitValue})
+			}
 		}
+
+		expenseShares, err = buildExpenseShares(expense, expense.Group.Currency, participants)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid split: " + err.Error(),
+		})
+	}
+
+	payerInputs := input.Payers
+	if len(payerInputs) == 0 {
+		payerInputs = scaleExpensePayers(expense.Payers, expense.Amount)
+	}
+
+	expensePayers, err := buildExpensePayers(expense, expense.Group.Currency, payerInputs)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid payers: " + err.Error(),
+		})
+	}
+
+	// Keep the primary payer if they still contribute
+	if !isExpensePayer(models.Expense{Payers: expensePayers}, expense.PaidByID) {
+		expense.PaidByID = expensePayers[0].UserID
+	}
+
+	participantIDs := make([]uint, 0, len(expenseShares)+len(expensePayers))
+	for _, share := range expenseShares {
+		participantIDs = append(participantIDs, share.UserID)
+	}
+	for _, payer := range expensePayers {
+		participantIDs = append(participantIDs, payer.UserID)
+	}
+	if err := checkGroupMembers(expense.GroupID, participantIDs); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Keep paid flags for participants that stay on the expense
+	paid := make(map[uint]bool)
+	for _, share := range existingShares {
+		paid[share.UserID] = share.IsPaid
+	}
+
+	tx := database.DB.Begin()
+	if tx.Error != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start transaction: " + tx.Error.Error(),
+		})
+	}
+
+	if err := lockUnsettledExpense(tx, expense.ID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errExpenseSettled) {
+			return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": settledExpenseError,
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to lock expense: " + err.Error(),
+		})
+	}
+
+	// Expenses from before revision history get their current state recorded first
+	if err := ensureBaselineRevision(tx, expense.ID); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record revision: " + err.Error(),
+		})
+	}
+
+	if err := tx.Model(&expense).Select(editedExpenseColumns).Updates(&expense).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update expense: " + err.Error(),
+		})
+	}
+
+	for i := range expenseShares {
+		expenseShares[i].IsPaid = paid[expenseShares[i].UserID]
+	}
+	// Changed shares need to be acknowledged again, which resolves disputes about them
+	acknowledgeShares(expenseShares, existingShares, expensePayers, uint(userID))
+
+	if err := deleteExpenseChildren(tx, []uint{expense.ID}); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update expense: " + err.Error(),
+		})
+	}
+
+	if err := saveExpenseChildren(tx, expense.ID, expensePayers, expenseShares, expenseItems); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update expense: " + err.Error(),
+		})
+	}
+
+	expense.Payers = expensePayers
+	expense.ExpenseShares = expenseShares
+	expense.Items = expenseItems
+
+	if err := addSystemComments(tx, expense.ID, uint(userID), describeExpenseChanges(before, expense)); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record changes: " + err.Error(),
+		})
+	}
+
+	if err := recordRevision(tx, expense, uint(userID), expensePayers, expenseShares, expenseItems); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record revision: " + err.Error(),
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to commit transaction: " + err.Error(),
+		})
+	}
+
+	if awaitsApproval {
+		notifyApprovalRequested(database.DB, expense.Group, expense)
 	}
 
 	return ctx.JSON(fiber.Map{
@@ -272,7 +1098,7 @@ func UpdateExpense(ctx fiber.Ctx) error {
 	})
 }
 
-// DeleteExpense deletes an expense (only the person who paid can delete)
+// DeleteExpense moves an expense to the group trash (only the people who paid can delete)
 func DeleteExpense(ctx fiber.Ctx) error {
 	expenseID := ctx.Params("id")
 
@@ -291,7 +1117,7 @@ func DeleteExpense(ctx fiber.Ctx) error {
 	}
 
 	var expense models.Expense
-	if err := database.DB.First(&expense, expenseID).Error; err != nil {
+	if err := database.DB.Preload("Payers").First(&expense, expenseID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Expense not found",
@@ -303,35 +1129,49 @@ func DeleteExpense(ctx fiber.Ctx) error {
 	}
 
 	// Check if user paid for this expense
-	if expense.PaidByID != uint(userID) {
+	if !isExpensePayer(expense, uint(userID)) {
 		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Only the person who paid can delete this expense",
+			"error": "Only the people who paid can delete this expense",
 		})
 	}
 
-	// Delete expense shares first (due to foreign key constraints)
-	if err := database.DB.Where("expense_id = ?", expense.ID).Delete(&models.ExpenseShare{}).Error; err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete expense shares: " + err.Error(),
+	if expense.Settled {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": settledExpenseError,
 		})
 	}
 
-	// Delete expense
-	if err := database.DB.Delete(&expense).Error; err != nil {
+	// The expense goes to the group trash and can be restored until it is purged
+	deletedBy := uint(userID)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&expense).Update("deleted_by_id", deletedBy).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&expense).Error
+	})
+	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete expense: " + err.Error(),
 		})
 	}
 
+	notifyExpenseTrashed(expense, deletedBy)
+
 	return ctx.JSON(fiber.Map{
-		"message": "Expense deleted successfully",
+		"message":          "Expense moved to trash",
+		"restorable_until": time.Now().Add(trashRetention()),
 	})
 }
 
 // GetUserBalance calculates what a user owes or is owed in a group
 func GetUserBalance(ctx fiber.Ctx) error {
-	groupID := ctx.Query("group_id")
-	if groupID == "" {
+	groupID, ok, err := parseUintQuery(ctx, "group_id")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !ok {
 		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Group ID is required",
 		})
@@ -351,19 +1191,38 @@ func GetUserBalance(ctx fiber.Ctx) error {
 		})
 	}
 
-	// Calculate total paid by user
-	var totalPaid float64
-	database.DB.Model(&models.Expense{}).Where("group_id = ? AND paid_by_id = ?", groupID, userID).Select("COALESCE(SUM(amount), 0)").Scan(&totalPaid)
+	// Calculate total paid by user in open expenses
+	var totalPaid money.Amount
+	if err := database.DB.Table("expense_payers").
+		Joins("JOIN expenses ON expenses.id = expense_payers.expense_id").
+		Where("expenses.group_id = ? AND expense_payers.user_id = ? AND expenses.deleted_at IS NULL AND expenses.settled = ? AND expenses.approval_status = ?", groupID, userID, false, models.ExpenseApproved).
+		Select("COALESCE(SUM(CASE WHEN expenses.type = ? THEN -expense_payers.base_amount ELSE expense_payers.base_amount END), 0)::bigint", models.EntryIncome).
+		Scan(&totalPaid).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate total paid: " + err.Error(),
+		})
+	}
 
-	// Calculate total owed by user
-	var totalOwed float64
-	database.DB.Table("expense_shares").
+	// Calculate total owed by user in open expenses
+	var totalOwed money.Amount
+	if err := database.DB.Table("expense_shares").
 		Joins("JOIN expenses ON expenses.id = expense_shares.expense_id").
-		Where("expenses.group_id = ? AND expense_shares.user_id = ?", groupID, userID).
-		Select("COALESCE(SUM(expense_shares.amount_owed), 0)").
-		Scan(&totalOwed)
+		Where("expenses.group_id = ? AND expense_shares.user_id = ? AND expenses.deleted_at IS NULL AND expenses.settled = ? AND expenses.approval_status = ?", groupID, userID, false, models.ExpenseApproved).
+		Select("COALESCE(SUM(CASE WHEN expenses.type = ? THEN -expense_shares.base_amount_owed ELSE expense_shares.base_amount_owed END), 0)::bigint", models.EntryIncome).
+		Scan(&totalOwed).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate total owed: " + err.Error(),
+		})
+	}
+
+	transfers, err := openTransferBalance(uint(groupID), uint(userID))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch payments and settlements: " + err.Error(),
+		})
+	}
 
-	balance := totalPaid - totalOwed
+	balance := totalPaid - totalOwed + transfers
 
 	return ctx.JSON(fiber.Map{
 		"user_id":    userID,
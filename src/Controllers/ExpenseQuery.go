@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/cursor"
+	"gorm.io/gorm"
+)
+
+const defaultExpensePageSize = 50
+const maxExpensePageSize = 200
+
+// ExpensePage is one page of an expense listing. NextCursor is null on the last page.
+type ExpensePage struct {
+	Expenses   []models.Expense `json:"expenses"`
+	NextCursor *string          `json:"next_cursor"`
+}
+
+// expenseSort is a column expenses can be ordered by, with how its values go in and out of cursors
+type expenseSort struct {
+	column string
+	key    func(models.Expense) string
+	parse  func(string) (interface{}, error)
+}
+
+var expenseSorts = map[string]expenseSort{
+	"occurred_at": {
+		column: "expenses.occurred_at",
+		key:    func(e models.Expense) string { return e.OccurredAt.Format("2006-01-02") },
+		parse: func(value string) (interface{}, error) {
+			return parseDate(value)
+		},
+	},
+	"created_at": {
+		column: "expenses.created_at",
+		key:    func(e models.Expense) string { return e.CreatedAt.Format(time.RFC3339Nano) },
+		parse: func(value string) (interface{}, error) {
+			return time.Parse(time.RFC3339Nano, value)
+		},
+	},
+	"amount": {
+		column: "expenses.base_amount",
+		key:    func(e models.Expense) string { return strconv.FormatInt(int64(e.BaseAmount), 10) },
+		parse: func(value string) (interface{}, error) {
+			return strconv.ParseInt(value, 10, 64)
+		},
+	},
+}
+
+// parseUintQuery reads an optional numeric ID from the query string
+func parseUintQuery(ctx fiber.Ctx, name string) (uint64, bool, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, errors.New("Invalid " + name)
+	}
+	return id, true, nil
+}
+
+// applyExpenseFilters narrows an expenses query with the filters in the query string:
+// from/to (YYYY-MM-DD), paid_by, participant, category_id ("none" for uncategorized),
+// min_amount/max_amount (minor units of the group currency), type, settled, disputed, approval and q (full-text search)
+func applyExpenseFilters(ctx fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if from := ctx.Query("from"); from != "" {
+		fromDate, err := parseDate(from)
+		if err != nil {
+			return nil, errors.New("Invalid from date, expected YYYY-MM-DD")
+		}
+		query = query.Where("expenses.occurred_at >= ?", fromDate)
+	}
+	if to := ctx.Query("to"); to != "" {
+		toDate, err := parseDate(to)
+		if err != nil {
+			return nil, errors.New("Invalid to date, expected YYYY-MM-DD")
+		}
+		query = query.Where("expenses.occurred_at <= ?", toDate)
+	}
+
+	if payerID, ok, err := parseUintQuery(ctx, "paid_by"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("EXISTS (SELECT 1 FROM expense_payers WHERE expense_payers.expense_id = expenses.id AND expense_payers.user_id = ?)", payerID)
+	}
+	if participantID, ok, err := parseUintQuery(ctx, "participant"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("EXISTS (SELECT 1 FROM expense_shares WHERE expense_shares.expense_id = expenses.id AND expense_shares.user_id = ?)", participantID)
+	}
+
+	if ctx.Query("category_id") == "none" {
+		query = query.Where("expenses.category_id IS NULL")
+	} else if categoryID, ok, err := parseUintQuery(ctx, "category_id"); err != nil {
+		return nil, err
+	} else if ok {
+		query = query.Where("expenses.category_id = ?", categoryID)
+	}
+
+	for _, bound := range []struct{ name, op string }{{"min_amount", ">="}, {"max_amount", "<="}} {
+		value := ctx.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid " + bound.name + ", expected minor units")
+		}
+		query = query.Where("expenses.base_amount "+bound.op+" ?", amount)
+	}
+
+	if value := ctx.Query("type"); value != "" {
+		if value != models.EntryExpense && value != models.EntryIncome {
+			return nil, errors.New("Invalid type, expected expense or income")
+		}
+		query = query.Where("expenses.type = ?", value)
+	}
+
+	if value := ctx.Query("settled"); value != "" {
+		settled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("Invalid settled, expected true or false")
+		}
+		query = query.Where("expenses.settled = ?", settled)
+	}
+
+	if value := ctx.Query("disputed"); value != "" {
+		disputed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("Invalid disputed, expected true or false")
+		}
+		condition := "EXISTS (SELECT 1 FROM expense_shares WHERE expense_shares.expense_id = expenses.id AND expense_shares.acknowledgement = ?)"
+		if !disputed {
+			condition = "NOT " + condition
+		}
+		query = query.Where(condition, models.ShareDisputed)
+	}
+
+	if approval := ctx.Query("approval"); approval != "" {
+		if approval != models.ExpensePendingApproval && approval != models.ExpenseApproved && approval != models.ExpenseRejected {
+			return nil, errors.New("Invalid approval, expected pending, approved or rejected")
+		}
+		query = query.Where("expenses.approval_status = ?", approval)
+	}
+
+	if search := strings.TrimSpace(ctx.Query("q")); search != "" {
+		query = query.Where("to_tsvector('simple', expenses.description) @@ websearch_to_tsquery('simple', ?)", search)
+	}
+
+	return query, nil
+}
+
+// findExpensePage applies the filters, sort order (sort=occurred_at|created_at|amount, order=desc|asc),
+// cursor and limit from the query string and loads one page of expenses
+func findExpensePage(ctx fiber.Ctx, query *gorm.DB) (ExpensePage, int, error) {
+	query, err := applyExpenseFilters(ctx, query)
+	if err != nil {
+		return ExpensePage{}, fiber.StatusBadRequest, err
+	}
+
+	sortName := ctx.Query("sort", "occurred_at")
+	sort, ok := expenseSorts[sortName]
+	if !ok {
+		return ExpensePage{}, fiber.StatusBadRequest, errors.New("Invalid sort, expected occurred_at, created_at or amount")
+	}
+
+	order := strings.ToLower(ctx.Query("order", "desc"))
+	if order != "asc" && order != "desc" {
+		return ExpensePage{}, fiber.StatusBadRequest, errors.New("Invalid order, expected asc or desc")
+	}
+
+	limit := defaultExpensePageSize
+	if value := ctx.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxExpensePageSize {
+			return ExpensePage{}, fiber.StatusBadRequest, errors.New("Invalid limit, expected 1 to " + strconv.Itoa(maxExpensePageSize))
+		}
+	}
+
+	// The cursor holds the sort key and ID of the last row, so pages stay stable while
+	// expenses are added
+	sortName = sortName + ":" + order
+	if value := ctx.Query("cursor"); value != "" {
+		position, err := cursor.Decode(value)
+		if err != nil || position.Sort != sortName {
+			return ExpensePage{}, fiber.StatusBadRequest, errors.New("Invalid cursor")
+		}
+		key, err := sort.parse(position.Value)
+		if err != nil {
+			return ExpensePage{}, fiber.StatusBadRequest, errors.New("Invalid cursor")
+		}
+
+		comparison := "<"
+		if order == "asc" {
+			comparison = ">"
+		}
+		query = query.Where("("+sort.column+", expenses.id) "+comparison+" (?, ?)", key, position.ID)
+	}
+
+	var expenses []models.Expense
+	if err := query.
+		Preload("PaidBy").
+		Preload("Category").
+		Preload("Payers.User").
+		Preload("ExpenseShares.User").
+		Preload("Items.Assignees").
+		Order(sort.column + " " + order + ", expenses.id " + order).
+		Limit(limit + 1).
+		Find(&expenses).Error; err != nil {
+		return ExpensePage{}, fiber.StatusInternalServerError, errors.New("Failed to fetch expenses: " + err.Error())
+	}
+
+	page := ExpensePage{Expenses: expenses}
+	if len(expenses) > limit {
+		page.Expenses = expenses[:limit]
+		last := page.Expenses[limit-1]
+		next := cursor.Encode(cursor.Cursor{Sort: sortName, Value: sort.key(last), ID: last.ID})
+		page.NextCursor = &next
+	}
+
+	if err := countComments(page.Expenses); err != nil {
+		return ExpensePage{}, fiber.StatusInternalServerError, errors.New("Failed to count comments: " + err.Error())
+	}
+
+	return page, fiber.StatusOK, nil
+}
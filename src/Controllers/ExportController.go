@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/export"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many expenses are loaded at a time while exporting
+const exportBatchSize = 200
+
+// ledgerExport is what goes into an export of a group's ledger. Members are everyone who was ever
+// in the group, in the order they joined, and get a share column each.
+type ledgerExport struct {
+	group   models.Group
+	members []models.User
+	from    *time.Time
+	to      *time.Time
+}
+
+// memberTotals adds up what a member paid, owed, sent and received over the exported period, in the group currency
+type memberTotals struct {
+	paid, owed, sent, received money.Amount
+}
+
+// within limits a query to the exported period; to is inclusive
+func (l ledgerExport) within(query *gorm.DB, column string) *gorm.DB {
+	if l.from != nil {
+		query = query.Where(column+" >= ?", *l.from)
+	}
+	if l.to != nil {
+		query = query.Where(column+" < ?", l.to.AddDate(0, 0, 1))
+	}
+	return query
+}
+
+// write streams the expenses, settlements and a balance summary to out
+func (l ledgerExport) write(out io.Writer, format string) error {
+	writer, err := export.New(format, out)
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[uint]*memberTotals)
+	for _, member := range l.members {
+		totals[member.ID] = &memberTotals{}
+	}
+
+	if err := l.writeExpenses(writer, totals); err != nil {
+		return err
+	}
+	if err := l.writeSettlements(writer, totals); err != nil {
+		return err
+	}
+	if err := l.writeBalances(writer, totals); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeExpenses writes approved expenses and income, oldest first, loading them in batches
+func (l ledgerExport) writeExpenses(writer export.Writer, totals map[uint]*memberTotals) error {
+	currency := l.group.Currency
+	columns := []export.Column{
+		{Key: "date", Title: "Date"},
+		{Key: "description", Title: "Description"},
+		{Key: "category", Title: "Category"},
+		{Key: "type", Title: "Type"},
+		{Key: "amount", Title: "Amount"},
+		{Key: "currency", Title: "Currency"},
+		{Key: "base_amount", Title: "Amount (" + currency + ")"},
+		{Key: "paid_by", Title: "Paid by"},
+		{Key: "participants", Title: "Participants"},
+		{Key: "settled", Title: "Settled"},
+	}
+	for _, member := range l.members {
+		columns = append(columns, export.Column{Key: "share_" + member.Username, Title: member.Username + " (" + currency + ")"})
+	}
+	if err := writer.Sheet(export.Sheet{Key: "expenses", Title: "Expenses", Columns: columns}); err != nil {
+		return err
+	}
+
+	var last *models.Expense
+	for {
+		query := l.within(database.DB.Where("group_id = ? AND approval_status = ?", l.group.ID, models.ExpenseApproved), "occurred_at")
+		if last != nil {
+			query = query.Where("(occurred_at, id) > (?, ?)", last.OccurredAt, last.ID)
+		}
+
+		var expenses []models.Expense
+		if err := query.
+			Preload("Category").
+			Preload("Payers.User").
+			Preload("ExpenseShares.User").
+			Order("occurred_at, id").
+			Limit(exportBatchSize).
+			Find(&expenses).Error; err != nil {
+			return fmt.Errorf("fetch expenses: %w", err)
+		}
+
+		for _, expense := range expenses {
+			if err := writer.Row(l.expenseRow(expense, totals)); err != nil {
+				return err
+			}
+		}
+
+		if len(expenses) < exportBatchSize {
+			return nil
+		}
+		last = &expenses[len(expenses)-1]
+	}
+}
+
+func (l ledgerExport) expenseRow(expense models.Expense, totals map[uint]*memberTotals) []export.Cell {
+	category := ""
+	if expense.Category != nil {
+		category = expense.Category.Name
+	}
+
+	payers := make([]string, len(expense.Payers))
+	for i, payer := range expense.Payers {
+		payers[i] = payer.User.Username + " " + payer.Amount.Format(expense.Currency)
+		if member, ok := totals[payer.UserID]; ok {
+			member.paid += basePaidBy(expense, payer.UserID)
+		}
+	}
+
+	participants := make([]string, len(expense.ExpenseShares))
+	shares := make(map[uint]money.Amount)
+	for i, share := range expense.ExpenseShares {
+		participants[i] = share.User.Username
+		shares[share.UserID] = share.BaseAmountOwed
+		if member, ok := totals[share.UserID]; ok {
+			member.owed += entrySign(expense) * share.BaseAmountOwed
+		}
+	}
+
+	settled := "no"
+	if expense.Settled {
+		settled = "yes"
+	}
+
+	row := []export.Cell{
+		export.Text(expense.OccurredAt.Format("2006-01-02")),
+		export.Text(expense.Description),
+		export.Text(category),
+		export.Text(expense.Type),
+		export.Number(expense.Amount.Format(expense.Currency)),
+		export.Text(expense.Currency),
+		export.Number(expense.BaseAmount.Format(l.group.Currency)),
+		export.Text(strings.Join(payers, "; ")),
+		export.Text(strings.Join(participants, "; ")),
+		export.Text(settled),
+	}
+	for _, member := range l.members {
+		share, ok := shares[member.ID]
+		if !ok {
+			row = append(row, export.Number(""))
+			continue
+		}
+		row = append(row, export.Number(share.Format(l.group.Currency)))
+	}
+	return row
+}
+
+// writeSettlements writes settlements and direct payments. Confirmed settlements and payments that
+// weren't rejected count towards the balances.
+func (l ledgerExport) writeSettlements(writer export.Writer, totals map[uint]*memberTotals) error {
+	if err := writer.Sheet(export.Sheet{Key: "settlements", Title: "Settlements", Columns: []export.Column{
+		{Key: "date", Title: "Date"},
+		{Key: "kind", Title: "Kind"},
+		{Key: "from", Title: "From"},
+		{Key: "to", Title: "To"},
+		{Key: "amount", Title: "Amount"},
+		{Key: "currency", Title: "Currency"},
+		{Key: "status", Title: "Status"},
+		{Key: "paid_at", Title: "Paid at"},
+		{Key: "note", Title: "Note"},
+	}}); err != nil {
+		return err
+	}
+
+	usernames := make(map[uint]string)
+	for _, member := range l.members {
+		usernames[member.ID] = member.Username
+	}
+	count := func(payerID, receiverID uint, amount money.Amount) {
+		if member, ok := totals[payerID]; ok {
+			member.sent += amount
+		}
+		if member, ok := totals[receiverID]; ok {
+			member.received += amount
+		}
+	}
+
+	// Both are written in the order they were created, which is the order of their IDs
+	var settlements []models.Settlement
+	err := l.within(database.DB.Where("group_id = ?", l.group.ID), "created_at").FindInBatches(&settlements, exportBatchSize, func(*gorm.DB, int) error {
+		for _, settlement := range settlements {
+			if err := l.settlementRow(writer, settlement, usernames, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return fmt.Errorf("export settlements: %w", err)
+	}
+
+	var payments []models.Payment
+	err = l.within(database.DB.Where("group_id = ?", l.group.ID), "created_at").FindInBatches(&payments, exportBatchSize, func(*gorm.DB, int) error {
+		for _, payment := range payments {
+			if err := l.paymentRow(writer, payment, usernames, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return fmt.Errorf("export payments: %w", err)
+	}
+	return nil
+}
+
+// settlementRow writes a settlement; what was paid towards it is counted as sent and received,
+// unless the receiver disputes receiving it
+func (l ledgerExport) settlementRow(writer export.Writer, settlement models.Settlement, usernames map[uint]string, count func(uint, uint, money.Amount)) error {
+	status, paidAt := settlement.Status, ""
+	if status == models.SettlementPending && settlement.AmountPaid > 0 {
+		status = "partially paid (" + settlement.AmountPaid.Format(settlement.Currency) + ")"
+	}
+	if settlement.AmountPaid > 0 && settlement.Status != models.SettlementDisputed {
+		count(settlement.PayerID, settlement.ReceiverID, settlement.AmountPaid)
+	}
+	if settlement.PaidAt != nil {
+		paidAt = settlement.PaidAt.Format("2006-01-02")
+	}
+	return writer.Row([]export.Cell{
+		export.Text(settlement.CreatedAt.Format("2006-01-02")),
+		export.Text("settlement"),
+		export.Text(usernames[settlement.PayerID]),
+		export.Text(usernames[settlement.ReceiverID]),
+		export.Number(settlement.Amount.Format(settlement.Currency)),
+		export.Text(settlement.Currency),
+		export.Text(status),
+		export.Text(paidAt),
+		export.Text(""),
+	})
+}
+
+// paymentRow writes a direct payment; unless it was rejected it is counted as sent and received
+func (l ledgerExport) paymentRow(writer export.Writer, payment models.Payment, usernames map[uint]string, count func(uint, uint, money.Amount)) error {
+	if payment.Status != models.PaymentRejected {
+		count(payment.PayerID, payment.ReceiverID, payment.Amount)
+	}
+	return writer.Row([]export.Cell{
+		export.Text(payment.CreatedAt.Format("2006-01-02")),
+		export.Text("payment"),
+		export.Text(usernames[payment.PayerID]),
+		export.Text(usernames[payment.ReceiverID]),
+		export.Number(payment.Amount.Format(payment.Currency)),
+		export.Text(payment.Currency),
+		export.Text(payment.Status),
+		export.Text(payment.CreatedAt.Format("2006-01-02")),
+		export.Text(payment.Note),
+	})
+}
+
+// writeBalances writes a summary per member. The balance is what they paid minus what they owed,
+// plus what they sent minus what they received; positive means the group owes them.
+func (l ledgerExport) writeBalances(writer export.Writer, totals map[uint]*memberTotals) error {
+	currency := l.group.Currency
+	if err := writer.Sheet(export.Sheet{Key: "balances", Title: "Balances", Columns: []export.Column{
+		{Key: "member", Title: "Member"},
+		{Key: "paid", Title: "Paid (" + currency + ")"},
+		{Key: "owed", Title: "Owed (" + currency + ")"},
+		{Key: "sent", Title: "Sent (" + currency + ")"},
+		{Key: "received", Title: "Received (" + currency + ")"},
+		{Key: "balance", Title: "Balance (" + currency + ")"},
+	}}); err != nil {
+		return err
+	}
+
+	for _, member := range l.members {
+		total := totals[member.ID]
+		balance := total.paid - total.owed + total.sent - total.received
+		if err := writer.Row([]export.Cell{
+			export.Text(member.Username),
+			export.Number(total.paid.Format(currency)),
+			export.Number(total.owed.Format(currency)),
+			export.Number(total.sent.Format(currency)),
+			export.Number(total.received.Format(currency)),
+			export.Number(balance.Format(currency)),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// @Summary Export a group's ledger
+// @Description Download the approved expenses and income of a group with payers and each member's share, its settlements and direct payments, and a balance summary per member. CSV puts the three tables in one file separated by empty lines; XLSX has a sheet each; JSON has an array each. The file is streamed as it is written.
+// @Tags groups
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param format query string false "csv (default), xlsx or json"
+// @Param from query string false "First day to include (YYYY-MM-DD)"
+// @Param to query string false "Last day to include (YYYY-MM-DD)"
+// @Success 200 {file} file "Ledger export"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/export [get]
+func ExportGroupLedger(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Preload("Group").Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	format := ctx.Query("format", export.CSV)
+	if !export.Valid(format) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": export.ErrUnknownFormat.Error(),
+		})
+	}
+
+	ledger := ledgerExport{group: groupMember.Group}
+	if from := ctx.Query("from"); from != "" {
+		fromDate, err := parseDate(from)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from date, expected YYYY-MM-DD",
+			})
+		}
+		ledger.from = &fromDate
+	}
+	if to := ctx.Query("to"); to != "" {
+		toDate, err := parseDate(to)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to date, expected YYYY-MM-DD",
+			})
+		}
+		ledger.to = &toDate
+	}
+
+	var members []models.GroupMember
+	if err := database.DB.Preload("User").Where("group_id = ?", ledger.group.ID).Order("joined_at, id").Find(&members).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch members: " + err.Error(),
+		})
+	}
+	seen := make(map[uint]bool)
+	for _, member := range members {
+		// Members who left and joined again have more than one membership
+		if !seen[member.UserID] {
+			seen[member.UserID] = true
+			ledger.members = append(ledger.members, member.User)
+		}
+	}
+
+	// The response has started once streaming begins, so later errors can only cut the file short
+	ctx.Attachment(fmt.Sprintf("group-%d-ledger.%s", ledger.group.ID, format))
+	ctx.Set(fiber.HeaderContentType, export.ContentType(format))
+	return ctx.SendStreamWriter(func(w *bufio.Writer) {
+		if err := ledger.write(w, format); err != nil {
+			log.Printf("Export of group %d failed: %v", ledger.group.ID, err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("Export of group %d failed: %v", ledger.group.ID, err)
+		}
+	})
+}
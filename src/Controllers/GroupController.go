@@ -9,6 +9,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	database "github.com/tjens23/tabsplit-backend/src/Database"
 	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/currency"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/settle"
 	"gorm.io/gorm"
 )
 
@@ -16,12 +19,21 @@ type CreateGroupInput struct {
 	Name         string `json:"name"`
 	ProfileImage string `json:"profile_image"`
 	Description  string `json:"description"`
+	Currency     string `json:"currency"`
 }
 
 type UpdateGroupInput struct {
 	Name         string `json:"name"`
 	ProfileImage string `json:"profile_image"`
 	Description  string `json:"description"`
+	Currency     string `json:"currency"`
+
+	// Settings are left unchanged when omitted
+	ExcludeDisputedExpenses *bool `json:"exclude_disputed_expenses"`
+	RequireApproval         *bool `json:"require_approval"`
+
+	// SettlementStrategy is greedy, exact or none
+	SettlementStrategy *string `json:"settlement_strategy"`
 }
 
 // Helper function to extract user ID from JWT token
@@ -45,6 +57,56 @@ func getUserIDFromJWT(ctx fiber.Ctx) (uint, error) {
 	return uint(userID), nil
 }
 
+// openTransferBalance returns what still counts of a user's direct payments and settlements in a
+// group: payments until settlements cover them, and settlements until they are paid in full
+func openTransferBalance(groupID uint, userID uint) (money.Amount, error) {
+	payments, err := openPaymentBalance(groupID, userID)
+	if err != nil {
+		return 0, err
+	}
+	settlements, err := openSettlementBalance(groupID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return payments + settlements, nil
+}
+
+// openInGroupCurrency names what a group still has open in its currency: unsettled expenses,
+// including trashed ones that can be restored, unsettled direct payments, or settlements that
+// aren't confirmed. It returns "" when there is nothing.
+func openInGroupCurrency(groupID uint) (string, error) {
+	var count int64
+	if err := database.DB.Unscoped().Model(&models.Expense{}).Where("group_id = ? AND settled = ?", groupID, false).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "unsettled expenses, including any in the trash", nil
+	}
+
+	if err := database.DB.Model(&models.Payment{}).Where("group_id = ? AND settled = ? AND status <> ?", groupID, false, models.PaymentRejected).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "direct payments that aren't covered by a settlement", nil
+	}
+
+	if err := database.DB.Model(&models.SettlementRound{}).Where("group_id = ? AND status IN ?", groupID, []string{models.RoundOpen, models.RoundPartiallyPaid}).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "a settlement round that isn't completed", nil
+	}
+
+	// Settlements from before rounds
+	if err := database.DB.Model(&models.Settlement{}).Where("group_id = ? AND is_confirmed = ?", groupID, false).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "settlements that aren't confirmed", nil
+	}
+	return "", nil
+}
+
 // @Summary Create a new group
 // @Description Create a new expense group with the authenticated user as admin
 // @Tags groups
@@ -77,11 +139,22 @@ func CreateGroup(ctx fiber.Ctx) error {
 		})
 	}
 
+	groupCurrency := currency.DefaultCurrency
+	if input.Currency != "" {
+		groupCurrency, err = currency.Normalize(input.Currency)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid currency code: " + input.Currency,
+			})
+		}
+	}
+
 	group := models.Group{
 		Name:         input.Name,
 		ProfileImage: input.ProfileImage,
 		Description:  input.Description,
 		AdminID:      userID,
+		Currency:     groupCurrency,
 	}
 
 	if err := database.DB.Create(&group).Error; err != nil {
@@ -105,17 +178,18 @@ func CreateGroup(ctx fiber.Ctx) error {
 	database.DB.Preload("GroupAdmin").First(&group, group.ID)
 
 	type GroupWithBalance struct {
-		ID           uint      `json:"id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		ProfileImage string    `json:"profile_image"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Admin        any       `json:"admin"`
-		Members      any       `json:"members"`
-		Status       float64   `json:"status"`
-		Expenses     any       `json:"expenses"`
-		Settlements  any       `json:"settlements"`
+		ID           uint         `json:"id"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		ProfileImage string       `json:"profile_image"`
+		Currency     string       `json:"currency"`
+		CreatedAt    time.Time    `json:"created_at"`
+		UpdatedAt    time.Time    `json:"updated_at"`
+		Admin        any          `json:"admin"`
+		Members      any          `json:"members"`
+		Status       money.Amount `json:"status"`
+		Expenses     any          `json:"expenses"`
+		Settlements  any          `json:"settlements"`
 	}
 
 	response := GroupWithBalance{
@@ -123,6 +197,7 @@ func CreateGroup(ctx fiber.Ctx) error {
 		Name:         group.Name,
 		Description:  group.Description,
 		ProfileImage: group.ProfileImage,
+		Currency:     group.Currency,
 		CreatedAt:    group.CreatedAt,
 		UpdatedAt:    group.UpdatedAt,
 		Admin:        group.GroupAdmin,
@@ -167,13 +242,14 @@ func GetGroups(ctx fiber.Ctx) error {
 	}
 
 	type CompactGroup struct {
-		ID           uint      `json:"id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		ProfileImage string    `json:"profile_image"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Status       float64   `json:"status"`
+		ID           uint         `json:"id"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		ProfileImage string       `json:"profile_image"`
+		Currency     string       `json:"currency"`
+		CreatedAt    time.Time    `json:"created_at"`
+		UpdatedAt    time.Time    `json:"updated_at"`
+		Status       money.Amount `json:"status"`
 	}
 
 	var groups []CompactGroup
@@ -182,12 +258,13 @@ func GetGroups(ctx fiber.Ctx) error {
 		group := membership.Group
 
 		// Calculate net balance for the user in this group
-		var totalPaid float64
-		var totalOwed float64
+		var totalPaid money.Amount
+		var totalOwed money.Amount
 
 		// Load all expenses for this group with shares
 		var expenses []models.Expense
 		if err := database.DB.
+			Preload("Payers").
 			Preload("ExpenseShares").
 			Where("group_id = ?", group.ID).
 			Find(&expenses).Error; err != nil {
@@ -197,30 +274,32 @@ func GetGroups(ctx fiber.Ctx) error {
 		}
 
 		for _, expense := range expenses {
-			if expense.Settled {
+			if !countsTowardsBalance(expense) {
 				continue
 			}
 
-			// If user paid this expense, add to totalPaid
-			if expense.PaidByID == userID {
-				totalPaid += expense.Amount
-			}
+			// Add what the user contributed to paying this expense
+			totalPaid += basePaidBy(expense, userID)
 
 			// Check how much this user owes in this expense
-			for _, share := range expense.ExpenseShares {
-				if share.UserID == userID {
-					totalOwed += share.AmountOwed
-				}
-			}
+			totalOwed += baseOwedBy(expense, userID)
+		}
+
+		transfers, err := openTransferBalance(group.ID, userID)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch payments and settlements: " + err.Error(),
+			})
 		}
 
-		netBalance := totalPaid - totalOwed // positive = user is owed, negative = user owes
+		netBalance := totalPaid - totalOwed + transfers // positive = user is owed, negative = user owes
 
 		groups = append(groups, CompactGroup{
 			ID:           group.ID,
 			Name:         group.Name,
 			Description:  group.Description,
 			ProfileImage: group.ProfileImage,
+			Currency:     group.Currency,
 			CreatedAt:    group.CreatedAt,
 			UpdatedAt:    group.UpdatedAt,
 			Status:       netBalance,
@@ -274,6 +353,7 @@ func GetGroup(ctx fiber.Ctx) error {
 	var expenses []models.Expense = []models.Expense{}
 	if err := database.DB.
 		Preload("PaidBy").
+		Preload("Payers").
 		Preload("ExpenseShares").
 		Preload("ExpenseShares.User").
 		Where("group_id = ?", group.ID).
@@ -288,45 +368,44 @@ func GetGroup(ctx fiber.Ctx) error {
 		users = append(users, member.User)
 	}
 
-	var totalPaid float64
-	var totalOwed float64
+	var totalPaid money.Amount
+	var totalOwed money.Amount
 
 	for i := range expenses {
 		expense := &expenses[i]
 
-		if expense.PaidByID == userID {
-			if !expense.Settled {
-				totalPaid += expense.Amount
-			}
-
-			expense.Status = expense.Amount
+		paid := basePaidBy(*expense, userID)
+		owed := baseOwedBy(*expense, userID)
+		if countsTowardsBalance(*expense) {
+			totalPaid += paid
+			totalOwed += owed
 		}
+		expense.Status = paid - owed
+	}
 
-		for _, share := range expense.ExpenseShares {
-			if share.UserID == userID {
-				if !expense.Settled {
-					totalOwed += share.AmountOwed
-				}
-				expense.Status -= share.AmountOwed
-			}
-		}
+	transfers, err := openTransferBalance(group.ID, userID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch payments and settlements: " + err.Error(),
+		})
 	}
 
-	netBalance := totalPaid - totalOwed
+	netBalance := totalPaid - totalOwed + transfers
 
 	// Build a response struct with net balance
 	type GroupWithBalance struct {
-		ID           uint      `json:"id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		ProfileImage string    `json:"profile_image"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Admin        any       `json:"admin"`
-		Members      any       `json:"members"`
-		Status       float64   `json:"status"`
-		Expenses     any       `json:"expenses"`
-		Settlements  any       `json:"settlements"`
+		ID           uint         `json:"id"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		ProfileImage string       `json:"profile_image"`
+		Currency     string       `json:"currency"`
+		CreatedAt    time.Time    `json:"created_at"`
+		UpdatedAt    time.Time    `json:"updated_at"`
+		Admin        any          `json:"admin"`
+		Members      any          `json:"members"`
+		Status       money.Amount `json:"status"`
+		Expenses     any          `json:"expenses"`
+		Settlements  any          `json:"settlements"`
 	}
 
 	response := GroupWithBalance{
@@ -334,6 +413,7 @@ func GetGroup(ctx fiber.Ctx) error {
 		Name:         group.Name,
 		Description:  group.Description,
 		ProfileImage: group.ProfileImage,
+		Currency:     group.Currency,
 		CreatedAt:    group.CreatedAt,
 		UpdatedAt:    group.UpdatedAt,
 		Admin:        group.GroupAdmin,
@@ -401,9 +481,49 @@ func UpdateGroup(ctx fiber.Ctx) error {
 		})
 	}
 
+	if input.Currency != "" {
+		groupCurrency, err := currency.Normalize(input.Currency)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid currency code: " + input.Currency,
+			})
+		}
+
+		// Open balances are stored in the group currency, so it can only change once everything is settled
+		if groupCurrency != group.Currency {
+			blocker, err := openInGroupCurrency(group.ID)
+			if err != nil {
+				return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to check open balances: " + err.Error(),
+				})
+			}
+			if blocker != "" {
+				return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Group currency can only be changed when everything is settled; the group still has " + blocker,
+				})
+			}
+			group.Currency = groupCurrency
+		}
+	}
+
 	group.Name = input.Name
 	group.ProfileImage = input.ProfileImage
 	group.Description = input.Description
+	if input.ExcludeDisputedExpenses != nil {
+		group.ExcludeDisputedExpenses = *input.ExcludeDisputedExpenses
+	}
+	if input.RequireApproval != nil {
+		group.RequireApproval = *input.RequireApproval
+	}
+	if input.SettlementStrategy != nil {
+		strategy, err := settle.Parse(*input.SettlementStrategy)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		group.SettlementStrategy = strategy.Name()
+	}
 
 	if err := database.DB.Save(&group).Error; err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -415,6 +535,7 @@ func UpdateGroup(ctx fiber.Ctx) error {
 	var expenses []models.Expense = []models.Expense{}
 	if err := database.DB.
 		Preload("PaidBy").
+		Preload("Payers").
 		Preload("ExpenseShares").
 		Preload("ExpenseShares.User").
 		Where("group_id = ?", group.ID).
@@ -429,44 +550,46 @@ func UpdateGroup(ctx fiber.Ctx) error {
 		users = append(users, member.User)
 	}
 
-	var totalPaid float64
-	var totalOwed float64
+	var totalPaid money.Amount
+	var totalOwed money.Amount
 
 	for i := range expenses {
 		expense := &expenses[i]
 
-		if expense.Settled {
+		if !countsTowardsBalance(*expense) {
 			continue
 		}
 
-		if expense.PaidByID == userID {
-			totalPaid += expense.Amount
-			expense.Status = expense.Amount
-		}
+		paid := basePaidBy(*expense, userID)
+		owed := baseOwedBy(*expense, userID)
+		totalPaid += paid
+		totalOwed += owed
+		expense.Status = paid - owed
+	}
 
-		for _, share := range expense.ExpenseShares {
-			if share.UserID == userID {
-				totalOwed += share.AmountOwed
-				expense.Status = -share.AmountOwed
-			}
-		}
+	transfers, err := openTransferBalance(group.ID, userID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch payments and settlements: " + err.Error(),
+		})
 	}
 
-	netBalance := totalPaid - totalOwed
+	netBalance := totalPaid - totalOwed + transfers
 
 	// Build a response struct with net balance
 	type GroupWithBalance struct {
-		ID           uint      `json:"id"`
-		Name         string    `json:"name"`
-		Description  string    `json:"description"`
-		ProfileImage string    `json:"profile_image"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Admin        any       `json:"admin"`
-		Members      any       `json:"members"`
-		Status       float64   `json:"status"`
-		Expenses     any       `json:"expenses"`
-		Settlements  any       `json:"settlements"`
+		ID           uint         `json:"id"`
+		Name         string       `json:"name"`
+		Description  string       `json:"description"`
+		ProfileImage string       `json:"profile_image"`
+		Currency     string       `json:"currency"`
+		CreatedAt    time.Time    `json:"created_at"`
+		UpdatedAt    time.Time    `json:"updated_at"`
+		Admin        any          `json:"admin"`
+		Members      any          `json:"members"`
+		Status       money.Amount `json:"status"`
+		Expenses     any          `json:"expenses"`
+		Settlements  any          `json:"settlements"`
 	}
 
 	response := GroupWithBalance{
@@ -474,6 +597,7 @@ func UpdateGroup(ctx fiber.Ctx) error {
 		Name:         group.Name,
 		Description:  group.Description,
 		ProfileImage: group.ProfileImage,
+		Currency:     group.Currency,
 		CreatedAt:    group.CreatedAt,
 		UpdatedAt:    group.UpdatedAt,
 		Admin:        group.GroupAdmin,
@@ -549,34 +673,47 @@ func DeleteGroup(ctx fiber.Ctx) error {
 		})
 	}
 
-	// Delete all expense shares for expenses in this group first (if expense_shares table exists)
-	if tx.Migrator().HasTable(&models.ExpenseShare{}) {
-		var expenseIDs []uint
-		if err := tx.Model(&models.Expense{}).Where("group_id = ?", groupID).Pluck("id", &expenseIDs).Error; err != nil {
-			tx.Rollback()
-			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to get expense IDs: " + err.Error(),
-			})
-		}
-
-		if len(expenseIDs) > 0 {
-			if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.ExpenseShare{}).Error; err != nil {
-				tx.Rollback()
-				return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Failed to delete expense shares: " + err.Error(),
-				})
-			}
-		}
+	// Expenses go for good, including those in the trash
+	var expenseIDs []uint
+	if err := tx.Unscoped().Model(&models.Expense{}).Where("group_id = ?", groupID).Pluck("id", &expenseIDs).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get expense IDs: " + err.Error(),
+		})
 	}
 
-	// Delete all expenses associated with this group
-	if err := tx.Where("group_id = ?", groupID).Delete(&models.Expense{}).Error; err != nil {
+	// Files are removed once the rows are gone for good
+	attachments, err := purgeExpenses(tx, expenseIDs)
+	if err != nil {
 		tx.Rollback()
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete group expenses: " + err.Error(),
 		})
 	}
 
+	settlementAttachments, err := deleteAttachmentRecords(tx, "settlement_id IN (?)", tx.Model(&models.Settlement{}).Select("id").Where("group_id = ?", groupID))
+	if err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group attachments: " + err.Error(),
+		})
+	}
+	attachments = append(attachments, settlementAttachments...)
+
+	if err := tx.Where("settlement_id IN (?)", tx.Model(&models.Settlement{}).Select("id").Where("group_id = ?", groupID)).Delete(&models.SettlementPayment{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group settlement payments: " + err.Error(),
+		})
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.Payment{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group payments: " + err.Error(),
+		})
+	}
+
 	// Delete all settlements associated with this group (if settlements exist)
 	// Check if settlements table exists first
 	if tx.Migrator().HasTable(&models.Settlement{}) {
@@ -588,6 +725,49 @@ func DeleteGroup(ctx fiber.Ctx) error {
 		}
 	}
 
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.SettlementRound{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group settlement rounds: " + err.Error(),
+		})
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.SettlementRule{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group settlement rules: " + err.Error(),
+		})
+	}
+
+	var recurringIDs []uint
+	if err := tx.Model(&models.RecurringExpense{}).Where("group_id = ?", groupID).Pluck("id", &recurringIDs).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch group recurring expenses: " + err.Error(),
+		})
+	}
+
+	if err := deleteRecurringExpenses(tx, recurringIDs); err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group recurring expenses: " + err.Error(),
+		})
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.Category{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group categories: " + err.Error(),
+		})
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.ExchangeRate{}).Error; err != nil {
+		tx.Rollback()
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete group exchange rates: " + err.Error(),
+		})
+	}
+
 	// Finally delete the group itself
 	if err := tx.Delete(&group).Error; err != nil {
 		tx.Rollback()
@@ -602,6 +782,7 @@ func DeleteGroup(ctx fiber.Ctx) error {
 			"error": "Failed to commit transaction: " + err.Error(),
 		})
 	}
+	removeAttachmentFiles(attachments)
 
 	return ctx.JSON(fiber.Map{
 		"message": "Group and all associated data deleted successfully",
@@ -0,0 +1,498 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/importer"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/split"
+	"gorm.io/gorm"
+)
+
+// maxImportSize is the largest file accepted for an import, in bytes
+const maxImportSize = 5 << 20
+
+var (
+	// errInvalidImport marks problems with the file or the options rather than the server
+	errInvalidImport = errors.New("invalid import")
+
+	// errImportRolledBack ends the import transaction without saving anything
+	errImportRolledBack = errors.New("import rolled back")
+)
+
+// ImportOptions describe how to read an import file. Format is "splitwise" (the default) or "csv",
+// which needs a Mapping. People maps names in the file to user IDs of group members; other names
+// are matched to members by username, and with CreatePlaceholders a placeholder member is created
+// for anyone left.
+type ImportOptions struct {
+	Format             string           `json:"format"`
+	Mapping            importer.Mapping `json:"mapping"`
+	People             map[string]uint  `json:"people"`
+	CreatePlaceholders bool             `json:"create_placeholders"`
+	DryRun             bool             `json:"dry_run"`
+}
+
+// ImportPerson is how a name from the file was matched. Balance is how the imported entries change
+// their balance in the group currency, which should match the balances in the app the file came from.
+type ImportPerson struct {
+	Name        string       `json:"name"`
+	UserID      *uint        `json:"user_id"`
+	Username    string       `json:"username"`
+	Placeholder bool         `json:"placeholder"`
+	Balance     money.Amount `json:"balance"`
+}
+
+// ImportReport summarizes an import. Errors on line 0 are about the file as a whole, such as people
+// who couldn't be matched. Nothing is saved when there are errors or on a dry run.
+type ImportReport struct {
+	DryRun   bool                 `json:"dry_run"`
+	Currency string               `json:"currency"`
+	Expenses int                  `json:"expenses"`
+	Income   int                  `json:"income"`
+	Payments int                  `json:"payments"`
+	Skipped  int                  `json:"skipped"`
+	People   []ImportPerson       `json:"people"`
+	Errors   []importer.LineError `json:"errors"`
+}
+
+// importRate is a currency used in an import file with its rate into the group currency
+type importRate struct {
+	code string
+	rate float64
+}
+
+// ImportLedger imports the expenses, income and payments in a file into a group, all in one
+// transaction. Everything is checked as if it were saved, so a dry run reports the same problems as
+// the real import would. Problems with the file itself are returned as errors wrapping errInvalidImport.
+func ImportLedger(group models.Group, importedBy uint, options ImportOptions, file io.Reader) (ImportReport, error) {
+	report := ImportReport{DryRun: options.DryRun, Currency: group.Currency, Errors: []importer.LineError{}}
+
+	if options.Format == "" {
+		options.Format = importer.Splitwise
+	}
+	result, err := importer.Parse(options.Format, file, options.Mapping, group.Currency)
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", errInvalidImport, err)
+	}
+	if len(result.Entries) == 0 && len(result.Errors) == 0 {
+		return report, fmt.Errorf("%w: the file has no expenses", errInvalidImport)
+	}
+	report.Skipped = result.Skipped
+	report.Errors = append(report.Errors, result.Errors...)
+
+	source := "a CSV file"
+	if options.Format == importer.Splitwise {
+		source = "a Splitwise export"
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		people, userIDs, err := matchImportPeople(tx, group, result.People, options, &report)
+		if err != nil {
+			return err
+		}
+
+		categories, err := groupCategories(group.ID)
+		if err != nil {
+			return fmt.Errorf("fetch categories: %w", err)
+		}
+		categoryIDs := make(map[string]uint)
+		for _, category := range categories {
+			// Custom categories come first and win over built-in ones with the same name
+			if _, ok := categoryIDs[strings.ToLower(category.Name)]; !ok {
+				categoryIDs[strings.ToLower(category.Name)] = category.ID
+			}
+		}
+
+		rates := make(map[string]importRate)
+		balances := make(map[uint]money.Amount)
+		for _, entry := range result.Entries {
+			rate, ok := rates[entry.Currency]
+			if !ok {
+				code, value, err := resolveExpenseCurrency(group, entry.Currency)
+				if err != nil {
+					report.Errors = append(report.Errors, importer.LineError{Line: entry.Line, Message: "Failed to resolve currency: " + err.Error()})
+					continue
+				}
+				rate = importRate{code: code, rate: value}
+				rates[entry.Currency] = rate
+			}
+
+			if entry.Payment {
+				payment, err := buildImportedPayment(group, entry, rate, userIDs)
+				if err != nil {
+					report.Errors = append(report.Errors, importer.LineError{Line: entry.Line, Message: err.Error()})
+					continue
+				}
+				if err := tx.Create(&payment).Error; err != nil {
+					return fmt.Errorf("create payment: %w", err)
+				}
+				balances[payment.PayerID] += payment.Amount
+				balances[payment.ReceiverID] -= payment.Amount
+				report.Payments++
+				continue
+			}
+
+			expense, payers, shares, err := buildImportedExpense(group, entry, rate, userIDs, categoryIDs)
+			if err != nil {
+				report.Errors = append(report.Errors, importer.LineError{Line: entry.Line, Message: err.Error()})
+				continue
+			}
+			if err := tx.Create(&expense).Error; err != nil {
+				return fmt.Errorf("create expense: %w", err)
+			}
+			if err := saveExpenseChildren(tx, expense.ID, payers, shares, nil); err != nil {
+				return err
+			}
+			if err := recordRevision(tx, expense, importedBy, payers, shares, nil); err != nil {
+				return fmt.Errorf("record revision: %w", err)
+			}
+			if err := addSystemComments(tx, expense.ID, importedBy, []string{"imported from " + source}); err != nil {
+				return err
+			}
+
+			expense.Payers = payers
+			expense.ExpenseShares = shares
+			for _, payer := range payers {
+				balances[payer.UserID] += basePaidBy(expense, payer.UserID)
+			}
+			for _, share := range shares {
+				balances[share.UserID] -= baseOwedBy(expense, share.UserID)
+			}
+			if expense.Type == models.EntryIncome {
+				report.Income++
+			} else {
+				report.Expenses++
+			}
+		}
+
+		for i := range people {
+			people[i].Balance = balances[*people[i].UserID]
+			// Placeholders of a dry run are never saved
+			if people[i].Placeholder && options.DryRun {
+				people[i].UserID = nil
+			}
+		}
+		report.People = people
+
+		if options.DryRun || len(report.Errors) > 0 {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportRolledBack) {
+		return report, err
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	return report, nil
+}
+
+// matchImportPeople matches every name in an import file to a group member, creating placeholders
+// when allowed. Names that can't be matched are added to the report's errors.
+func matchImportPeople(tx *gorm.DB, group models.Group, names []string, options ImportOptions, report *ImportReport) ([]ImportPerson, map[string]uint, error) {
+	var members []models.GroupMember
+	if err := tx.Preload("User").Where("group_id = ? AND is_active = ?", group.ID, true).Find(&members).Error; err != nil {
+		return nil, nil, fmt.Errorf("fetch members: %w", err)
+	}
+	byID := make(map[uint]models.User)
+	byUsername := make(map[string]models.User)
+	for _, member := range members {
+		byID[member.UserID] = member.User
+		byUsername[strings.ToLower(member.User.Username)] = member.User
+	}
+
+	people := make([]ImportPerson, 0, len(names))
+	userIDs := make(map[string]uint)
+	for _, name := range names {
+		var user models.User
+		placeholder := false
+
+		if id, mapped := options.People[name]; mapped {
+			var ok bool
+			if user, ok = byID[id]; !ok {
+				report.Errors = append(report.Errors, importer.LineError{Message: fmt.Sprintf("%s is mapped to user ID %d, who is not a member of this group", name, id)})
+				continue
+			}
+		} else if member, ok := byUsername[strings.ToLower(name)]; ok {
+			user = member
+		} else if options.CreatePlaceholders {
+			var err error
+			if user, err = createPlaceholder(tx, group, name); err != nil {
+				return nil, nil, err
+			}
+			placeholder = true
+		} else {
+			report.Errors = append(report.Errors, importer.LineError{Message: fmt.Sprintf("Nobody in the group is called %s; map them to a member in people or allow placeholders", name)})
+			continue
+		}
+
+		userIDs[name] = user.ID
+		people = append(people, ImportPerson{Name: name, UserID: &user.ID, Username: user.Username, Placeholder: placeholder})
+	}
+	return people, userIDs, nil
+}
+
+// createPlaceholder adds a placeholder member for someone without an account. The username is the
+// name from the file, numbered when it is already taken.
+func createPlaceholder(tx *gorm.DB, group models.Group, name string) (models.User, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return models.User{}, fmt.Errorf("create placeholder: %w", err)
+	}
+	suffix := hex.EncodeToString(token)
+
+	username := name
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return models.User{}, fmt.Errorf("create placeholder: %w", err)
+		}
+		if count == 0 {
+			break
+		}
+		username = fmt.Sprintf("%s (%d)", name, n)
+	}
+
+	user := models.User{
+		Username:      username,
+		Email:         "placeholder-" + suffix + "@placeholder.invalid",
+		Phone:         "placeholder-" + suffix,
+		IsPlaceholder: true,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return user, fmt.Errorf("create placeholder: %w", err)
+	}
+	if err := tx.Create(&models.GroupMember{GroupID: group.ID, UserID: user.ID, IsActive: true}).Error; err != nil {
+		return user, fmt.Errorf("add placeholder to group: %w", err)
+	}
+	return user, nil
+}
+
+// importedAmounts adds up amounts per member, for files where two names belong to the same member
+func importedAmounts(amounts map[string]money.Amount, userIDs map[string]uint) (map[uint]money.Amount, error) {
+	byUser := make(map[uint]money.Amount)
+	for name, amount := range amounts {
+		userID, ok := userIDs[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not matched to a member", name)
+		}
+		byUser[userID] += amount
+	}
+	return byUser, nil
+}
+
+// buildImportedExpense turns an entry from an import file into an approved expense with an exact
+// split. The shares count as accepted, since the people involved already agreed to them in the app
+// the file came from.
+func buildImportedExpense(group models.Group, entry importer.Entry, rate importRate, userIDs map[string]uint, categoryIDs map[string]uint) (models.Expense, []models.ExpensePayer, []models.ExpenseShare, error) {
+	paid, err := importedAmounts(entry.Payers, userIDs)
+	if err != nil {
+		return models.Expense{}, nil, nil, err
+	}
+	owed, err := importedAmounts(entry.Shares, userIDs)
+	if err != nil {
+		return models.Expense{}, nil, nil, err
+	}
+
+	entryType := models.EntryExpense
+	if entry.Income {
+		entryType = models.EntryIncome
+	}
+	expense := models.Expense{
+		Type:           entryType,
+		Amount:         entry.Amount,
+		Currency:       rate.code,
+		Description:    entry.Description,
+		OccurredAt:     entry.Date,
+		GroupID:        group.ID,
+		ExchangeRate:   rate.rate,
+		BaseAmount:     money.Convert(entry.Amount, rate.rate, rate.code, group.Currency),
+		SplitMode:      string(split.Exact),
+		ApprovalStatus: models.ExpenseApproved,
+	}
+
+	if id, ok := categoryIDs[strings.ToLower(entry.Category)]; ok {
+		expense.CategoryID = &id
+	} else if suggestion, _, err := suggestCategory(group.ID, entry.Description); err == nil && suggestion != nil {
+		expense.CategoryID = &suggestion.ID
+	}
+
+	payerInputs := make([]ExpensePayerInput, 0, len(paid))
+	for userID, amount := range paid {
+		payerInputs = append(payerInputs, ExpensePayerInput{UserID: userID, Amount: amount})
+	}
+	payers, err := buildExpensePayers(expense, group.Currency, payerInputs)
+	if err != nil {
+		return expense, nil, nil, fmt.Errorf("Invalid payers: %w", err)
+	}
+	expense.PaidByID = payers[0].UserID
+
+	participants := make([]split.Participant, 0, len(owed))
+	for userID, amount := range owed {
+		participants = append(participants, split.Participant{UserID: userID, Value: int64(amount)})
+	}
+	sort.Slice(participants, func(i, j int) bool { return participants[i].UserID < participants[j].UserID })
+	shares, err := buildExpenseShares(expense, group.Currency, participants)
+	if err != nil {
+		return expense, nil, nil, fmt.Errorf("Invalid split: %w", err)
+	}
+
+	now := time.Now()
+	for i := range shares {
+		shares[i].Acknowledgement = models.ShareAccepted
+		shares[i].AcknowledgedAt = &now
+	}
+	return expense, payers, shares, nil
+}
+
+// buildImportedPayment turns a payment from an import file into a confirmed payment in the group currency
+func buildImportedPayment(group models.Group, entry importer.Entry, rate importRate, userIDs map[string]uint) (models.Payment, error) {
+	var payment models.Payment
+	if len(entry.Payers) != 1 || len(entry.Shares) != 1 {
+		return payment, fmt.Errorf("a payment needs exactly one payer and one receiver, found %d and %d", len(entry.Payers), len(entry.Shares))
+	}
+	for name := range entry.Payers {
+		payment.PayerID = userIDs[name]
+	}
+	for name := range entry.Shares {
+		payment.ReceiverID = userIDs[name]
+	}
+	if payment.PayerID == 0 || payment.ReceiverID == 0 {
+		return payment, errors.New("the payer or receiver is not matched to a member")
+	}
+	if payment.PayerID == payment.ReceiverID {
+		return payment, errors.New("the payer and receiver are the same member")
+	}
+
+	payment.GroupID = group.ID
+	payment.Amount = money.Convert(entry.Amount, rate.rate, rate.code, group.Currency)
+	payment.Currency = group.Currency
+	payment.Note = entry.Description
+	payment.Status = models.PaymentConfirmed
+	payment.RespondedAt = &entry.Date
+	payment.CreatedAt = entry.Date
+	if payment.Amount <= 0 {
+		return payment, errors.New("the payment is 0 in the group currency")
+	}
+	return payment, nil
+}
+
+// @Summary Import expenses into a group
+// @Description Import expenses, income and payments from a Splitwise CSV export or a CSV file described by a column mapping. Send the file in the "file" form field and ImportOptions as JSON in the "options" field. Everything is imported in one transaction, and nothing is saved when a line has a problem. Only the group admin can import.
+// @Tags groups
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param file formData file true "CSV file"
+// @Param options formData string false "ImportOptions as JSON"
+// @Param dry_run query bool false "Only check the file and report what would be imported"
+// @Success 200 {object} ImportReport "Dry run report"
+// @Success 201 {object} map[string]interface{} "Import report"
+// @Failure 400 {object} map[string]interface{} "Invalid file or options, with the report when lines have problems"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Group not found"
+// @Failure 413 {object} map[string]interface{} "File too large"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/import [post]
+func ImportGroupLedger(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Group not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch group: " + err.Error(),
+		})
+	}
+	if group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the group admin can import expenses",
+		})
+	}
+
+	var options ImportOptions
+	if raw := ctx.FormValue("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &options); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot parse options: " + err.Error(),
+			})
+		}
+	}
+	if fiber.Query[bool](ctx, "dry_run") {
+		options.DryRun = true
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A file is required in the \"file\" form field",
+		})
+	}
+	if header.Size > maxImportSize {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "File is larger than 5 MB",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file: " + err.Error(),
+		})
+	}
+	defer file.Close()
+
+	report, err := ImportLedger(group, userID, options, file)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, errInvalidImport) {
+			status = fiber.StatusBadRequest
+		}
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": "Failed to import: " + err.Error(),
+		})
+	}
+
+	if len(report.Errors) > 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Some lines can't be imported, nothing was saved",
+			"report": report,
+		})
+	}
+	if report.DryRun {
+		return ctx.JSON(report)
+	}
+
+	var memberIDs []uint
+	for _, person := range report.People {
+		if !person.Placeholder && *person.UserID != userID {
+			memberIDs = append(memberIDs, *person.UserID)
+		}
+	}
+	notifyUsers(database.DB, memberIDs, fmt.Sprintf("%d expenses and %d payments were imported into %s", report.Expenses+report.Income, report.Payments, group.Name))
+
+	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Import completed",
+		"report":  report,
+	})
+}
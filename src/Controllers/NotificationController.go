@@ -6,8 +6,22 @@ import (
 	"github.com/gofiber/fiber/v3"
 	database "github.com/tjens23/tabsplit-backend/src/Database"
 	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
 )
 
+// notifyUsers sends the same notification to every user; failures are logged and otherwise ignored
+func notifyUsers(db *gorm.DB, userIDs []uint, message string) {
+	for _, userID := range userIDs {
+		if err := db.Create(&models.Notification{
+			Message: message,
+			UserID:  userID,
+			New:     true,
+		}).Error; err != nil {
+			println("Failed to send notification: " + err.Error())
+		}
+	}
+}
+
 // @Summary Get New Notifications
 // @Description Gets new notification of the authenticated user
 // @Tags notification
@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/settle"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxPaymentNoteLength is the longest payment note or rejection reason accepted, in characters
+const maxPaymentNoteLength = 500
+
+type CreatePaymentInput struct {
+	GroupID    uint         `json:"group_id"`
+	ReceiverID uint         `json:"receiver_id"`
+	Amount     money.Amount `json:"amount"`
+	Note       string       `json:"note"`
+}
+
+type RejectPaymentInput struct {
+	Reason string `json:"reason"`
+}
+
+// openPaymentBalance returns what a user paid minus what they received in direct payments that are
+// not covered by settlements yet and weren't rejected
+func openPaymentBalance(groupID uint, userID uint) (money.Amount, error) {
+	var balance money.Amount
+	err := database.DB.Model(&models.Payment{}).
+		Where("group_id = ? AND settled = ? AND status <> ? AND (payer_id = ? OR receiver_id = ?)", groupID, false, models.PaymentRejected, userID, userID).
+		Select("COALESCE(SUM(CASE WHEN payer_id = ? THEN amount ELSE -amount END), 0)::bigint", userID).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// openSettlementsRound finds the settlements a new payment is folded into: those of the latest
+// round that isn't completed or cancelled, or for a group settled before rounds existed, its
+// pending settlements without a round (roundID nil). found is false when there are none.
+func openSettlementsRound(tx *gorm.DB, groupID uint) (roundID *uint, found bool, err error) {
+	var round models.SettlementRound
+	err = tx.Where("group_id = ? AND status IN ?", groupID, []string{models.RoundOpen, models.RoundPartiallyPaid}).
+		Order("id DESC").
+		Limit(1).
+		Find(&round).Error
+	if err != nil || round.ID != 0 {
+		return &round.ID, round.ID != 0, err
+	}
+
+	var legacy int64
+	err = tx.Model(&models.Settlement{}).Where("group_id = ? AND round_id IS NULL AND status = ?", groupID, models.SettlementPending).Count(&legacy).Error
+	return nil, legacy > 0, err
+}
+
+// rebalanceOpenSettlements recomputes the unconfirmed settlements of a round (or without a round,
+// when roundID is nil) after adding a debt to the ones they settle, simplified the way the round
+// was and honouring the rules. Only what is left of partly paid settlements is recomputed. Rows for
+// pairs that still pay each other are kept, so their attachments and instalments stay; partly paid
+// rows that are no longer needed are marked paid at what was paid, and the rest are deleted. The
+// attachments of deleted rows are returned for deleting their files after commit. Nothing is
+// changed when the rules leave no plan (settle.ErrNoValidPlan).
+func rebalanceOpenSettlements(tx *gorm.DB, group models.Group, roundID *uint, adjustment settle.Transfer, rules settle.Rules) ([]models.Attachment, error) {
+	strategyName := group.SettlementStrategy
+	if roundID != nil {
+		var round models.SettlementRound
+		if err := tx.Select("strategy").First(&round, *roundID).Error; err != nil {
+			return nil, err
+		}
+		strategyName = round.Strategy
+	}
+	strategy, err := settle.Parse(strategyName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Settlements that are marked paid wait for their receiver and are left alone
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("group_id = ? AND status = ?", group.ID, models.SettlementPending)
+	if roundID != nil {
+		query = query.Where("round_id = ?", *roundID)
+	} else {
+		query = query.Where("round_id IS NULL")
+	}
+	var open []models.Settlement
+	if err := query.Order("id").Find(&open).Error; err != nil {
+		return nil, err
+	}
+
+	debts := []settle.Transfer{adjustment}
+	for _, settlement := range open {
+		debts = append(debts, settle.Transfer{PayerID: settlement.PayerID, ReceiverID: settlement.ReceiverID, Amount: settlement.Amount - settlement.AmountPaid})
+	}
+	plan, err := settle.Plan(strategy, rules, debts)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[[2]uint]*models.Settlement)
+	for i := range open {
+		pair := [2]uint{open[i].PayerID, open[i].ReceiverID}
+		if existing[pair] == nil {
+			existing[pair] = &open[i]
+		}
+	}
+
+	kept := make(map[uint]bool)
+	for _, transfer := range plan {
+		if row := existing[[2]uint{transfer.PayerID, transfer.ReceiverID}]; row != nil && !kept[row.ID] {
+			kept[row.ID] = true
+			if err := tx.Model(row).Update("amount", row.AmountPaid+transfer.Amount).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := tx.Create(&models.Settlement{
+			GroupID:    group.ID,
+			PayerID:    transfer.PayerID,
+			ReceiverID: transfer.ReceiverID,
+			Amount:     transfer.Amount,
+			Currency:   group.Currency,
+			RoundID:    roundID,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var stale []uint
+	for _, settlement := range open {
+		if kept[settlement.ID] {
+			continue
+		}
+		if settlement.AmountPaid > 0 {
+			now := time.Now()
+			if err := tx.Model(&settlement).Select("amount", "status", "paid_at").Updates(models.Settlement{
+				Amount: settlement.AmountPaid,
+				Status: models.SettlementMarkedPaid,
+				PaidAt: &now,
+			}).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		stale = append(stale, settlement.ID)
+	}
+
+	var attachments []models.Attachment
+	if len(stale) > 0 {
+		if attachments, err = deleteAttachmentRecords(tx, "settlement_id IN ?", stale); err != nil {
+			return nil, err
+		}
+		if err := tx.Where("id IN ?", stale).Delete(&models.Settlement{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if roundID != nil {
+		if err := refreshRoundStatus(tx, *roundID); err != nil {
+			return nil, err
+		}
+	}
+	return attachments, nil
+}
+
+// findReceivedPayment loads a pending payment that the current user received
+func findReceivedPayment(ctx fiber.Ctx) (models.Payment, int, error) {
+	var payment models.Payment
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return payment, fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	if err := database.DB.Preload("Group").Preload("Payer").Preload("Receiver").First(&payment, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return payment, fiber.StatusNotFound, errors.New("Payment not found")
+		}
+		return payment, fiber.StatusInternalServerError, errors.New("Failed to fetch payment: " + err.Error())
+	}
+
+	if payment.ReceiverID != userID {
+		return payment, fiber.StatusForbidden, errors.New("Only the receiver can respond to this payment")
+	}
+	if payment.Status != models.PaymentPending {
+		return payment, fiber.StatusConflict, errors.New("This payment was already " + payment.Status)
+	}
+
+	return payment, fiber.StatusOK, nil
+}
+
+// @Summary Record a direct payment
+// @Description Record money you paid another member outside a settlement. It counts towards balances right away and open settlements of the group are recalculated. The receiver is asked to confirm it.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param payment body CreatePaymentInput true "Payment data"
+// @Success 201 {object} map[string]interface{} "Payment recorded"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /payments [post]
+func CreatePayment(ctx fiber.Ctx) error {
+	var input CreatePaymentInput
+	if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, status, err := memberGroupAccess(ctx, input.GroupID)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if input.Amount <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Amount must be positive",
+		})
+	}
+	if input.ReceiverID == userID {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You can't record a payment to yourself",
+		})
+	}
+	if err := checkGroupMembers(input.GroupID, []uint{input.ReceiverID}); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	note := strings.TrimSpace(input.Note)
+	if utf8.RuneCountInString(note) > maxPaymentNoteLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Note can be at most 500 characters",
+		})
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, input.GroupID).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch group: " + err.Error(),
+		})
+	}
+
+	payment := models.Payment{
+		GroupID:    group.ID,
+		PayerID:    userID,
+		ReceiverID: input.ReceiverID,
+		Amount:     input.Amount,
+		Currency:   group.Currency,
+		Note:       note,
+		Status:     models.PaymentPending,
+	}
+
+	// With open settlements the payment is folded into them; otherwise, or when the group's
+	// settlement rules leave no way to fold it in, it stays in the balances until the next settlement
+	var removed []models.Attachment
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		roundID, found, err := openSettlementsRound(tx, group.ID)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			rules, err := groupRules(tx, group.ID)
+			if err != nil {
+				return err
+			}
+			// The receiver now owes the payment back, which cancels what the payer owed them
+			removed, err = rebalanceOpenSettlements(tx, group, roundID, settle.Transfer{
+				PayerID:    payment.ReceiverID,
+				ReceiverID: payment.PayerID,
+				Amount:     payment.Amount,
+			}, rules)
+			if errors.Is(err, settle.ErrNoValidPlan) {
+				found, roundID = false, nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		payment.Settled = found
+		payment.SettlementRoundID = roundID
+		return tx.Create(&payment).Error
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record payment: " + err.Error(),
+		})
+	}
+	removeAttachmentFiles(removed)
+
+	var payer models.User
+	database.DB.Select("username").First(&payer, userID)
+	if err := database.DB.Create(&models.Notification{
+		Message:    fmt.Sprintf("%s paid you %s %s in %s. Please confirm you received it.", payer.Username, payment.Amount.Format(payment.Currency), payment.Currency, group.Name),
+		UserID:     payment.ReceiverID,
+		New:        true,
+		Action:     "confirm",
+		ActionPath: fmt.Sprintf("/payments/%d/confirm", payment.ID),
+	}).Error; err != nil {
+		println("Failed to send notification: " + err.Error())
+	}
+
+	database.DB.Preload("Payer").Preload("Receiver").First(&payment, payment.ID)
+
+	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":             "Payment recorded successfully",
+		"payment":             payment,
+		"settlements_updated": payment.Settled,
+	})
+}
+
+// @Summary Get the direct payments of a group
+// @Description Get the direct payments between members of a group, newest first
+// @Tags payments
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.Payment "Payments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/payments [get]
+func GetGroupPayments(ctx fiber.Ctx) error {
+	groupID := fiber.Params[uint](ctx, "id")
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var payments []models.Payment
+	if err := database.DB.Where("group_id = ?", groupID).
+		Preload("Payer").
+		Preload("Receiver").
+		Order("created_at DESC, id DESC").
+		Find(&payments).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch payments: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(payments)
+}
+
+// @Summary Confirm a direct payment
+// @Description Confirm that you received a payment (receiver only)
+// @Tags payments
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Success 200 {object} models.Payment "Confirmed payment"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the receiver"
+// @Failure 404 {object} map[string]interface{} "Payment not found"
+// @Failure 409 {object} map[string]interface{} "Payment already confirmed or rejected"
+// @Security ApiKeyAuth
+// @Router /payments/{id}/confirm [post]
+func ConfirmPayment(ctx fiber.Ctx) error {
+	payment, status, err := findReceivedPayment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	now := time.Now()
+	payment.Status = models.PaymentConfirmed
+	payment.RespondedAt = &now
+	if err := database.DB.Model(&payment).Select("status", "responded_at").Updates(&payment).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to confirm payment: " + err.Error(),
+		})
+	}
+
+	notifyUsers(database.DB, []uint{payment.PayerID}, fmt.Sprintf("%s confirmed your payment of %s %s in %s",
+		payment.Receiver.Username, payment.Amount.Format(payment.Currency), payment.Currency, payment.Group.Name))
+
+	return ctx.JSON(payment)
+}
+
+// @Summary Reject a direct payment
+// @Description Reject a payment you didn't receive (receiver only). It no longer counts towards balances, and open settlements are recalculated if it was folded into them.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param rejection body RejectPaymentInput false "Optional reason"
+// @Success 200 {object} models.Payment "Rejected payment"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the receiver"
+// @Failure 404 {object} map[string]interface{} "Payment not found"
+// @Failure 409 {object} map[string]interface{} "Payment already confirmed or rejected"
+// @Security ApiKeyAuth
+// @Router /payments/{id}/reject [post]
+func RejectPayment(ctx fiber.Ctx) error {
+	var input RejectPaymentInput
+	if len(ctx.Body()) > 0 {
+		if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot parse JSON: " + err.Error(),
+			})
+		}
+	}
+	reason := strings.TrimSpace(input.Reason)
+	if utf8.RuneCountInString(reason) > maxPaymentNoteLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Reason can be at most 500 characters",
+		})
+	}
+
+	payment, status, err := findReceivedPayment(ctx)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	now := time.Now()
+	payment.Status = models.PaymentRejected
+	payment.RespondedAt = &now
+
+	// A payment that settlements already account for is taken back out of them
+	var removed []models.Attachment
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&payment).Select("status", "responded_at").Updates(&payment).Error; err != nil {
+			return err
+		}
+		if !payment.Settled {
+			return nil
+		}
+
+		rules, err := groupRules(tx, payment.GroupID)
+		if err != nil {
+			return err
+		}
+		// Without the payment, the payer owes its amount again. The round has to take it back even
+		// if the group's settlement rules leave no way to, so then they are set aside.
+		debt := settle.Transfer{
+			PayerID:    payment.PayerID,
+			ReceiverID: payment.ReceiverID,
+			Amount:     payment.Amount,
+		}
+		removed, err = rebalanceOpenSettlements(tx, payment.Group, payment.SettlementRoundID, debt, rules)
+		if errors.Is(err, settle.ErrNoValidPlan) {
+			removed, err = rebalanceOpenSettlements(tx, payment.Group, payment.SettlementRoundID, debt, settle.Rules{})
+		}
+		return err
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reject payment: " + err.Error(),
+		})
+	}
+	removeAttachmentFiles(removed)
+
+	message := fmt.Sprintf("%s rejected your payment of %s %s in %s", payment.Receiver.Username, payment.Amount.Format(payment.Currency), payment.Currency, payment.Group.Name)
+	if reason != "" {
+		message += ": " + reason
+	}
+	notifyUsers(database.DB, []uint{payment.PayerID}, message)
+
+	return ctx.JSON(payment)
+}
@@ -0,0 +1,653 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/recurrence"
+	"github.com/tjens23/tabsplit-backend/src/split"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxCatchUpOccurrences limits how many missed occurrences of one template are created in a single run
+const maxCatchUpOccurrences = 100
+
+// Dates are given as YYYY-MM-DD
+type CreateRecurringExpenseInput struct {
+	GroupID      uint                    `json:"group_id"`
+	Description  string                  `json:"description"`
+	Amount       money.Amount            `json:"amount"`
+	Currency     string                  `json:"currency"`
+	PaidByID     uint                    `json:"paid_by_id"`
+	SplitMode    string                  `json:"split_mode"`
+	Participants []SplitParticipantInput `json:"participants"`
+	Frequency    string                  `json:"frequency"`
+	Interval     int                     `json:"interval"`
+	AnchorDate   string                  `json:"anchor_date"`
+	EndDate      string                  `json:"end_date"`
+}
+
+// UpdateRecurringExpenseInput changes a template; omitted fields keep their value.
+// An end_date of "none" removes the end date.
+type UpdateRecurringExpenseInput struct {
+	Description  string                  `json:"description"`
+	Amount       money.Amount            `json:"amount"`
+	Currency     string                  `json:"currency"`
+	PaidByID     uint                    `json:"paid_by_id"`
+	SplitMode    string                  `json:"split_mode"`
+	Participants []SplitParticipantInput `json:"participants"`
+	Frequency    string                  `json:"frequency"`
+	Interval     int                     `json:"interval"`
+	AnchorDate   string                  `json:"anchor_date"`
+	EndDate      string                  `json:"end_date"`
+}
+
+func parseDate(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", value, time.UTC)
+}
+
+// scheduleFrom moves a template to its first occurrence on or after the given day, skipping missed ones
+func scheduleFrom(template *models.RecurringExpense, from time.Time) {
+	day := recurrence.Date(from.UTC())
+	template.NextIndex = 0
+	template.NextOccurrence = template.AnchorDate
+	for template.NextOccurrence.Before(day) {
+		template.NextIndex++
+		template.NextOccurrence = recurrence.Occurrence(template.AnchorDate, recurrence.Frequency(template.Frequency), template.Interval, template.NextIndex)
+	}
+}
+
+// validateRecurringTemplate checks that a template can be turned into an expense
+func validateRecurringTemplate(template models.RecurringExpense, group models.Group) error {
+	if template.Description == "" {
+		return errors.New("description is required")
+	}
+	if template.Interval < 1 {
+		return recurrence.ErrInvalidInterval
+	}
+	if _, err := recurrence.ParseFrequency(template.Frequency); err != nil {
+		return err
+	}
+	if template.EndDate != nil && template.EndDate.Before(template.AnchorDate) {
+		return errors.New("end date is before the anchor date")
+	}
+
+	mode, err := split.ParseMode(template.SplitMode)
+	if err != nil {
+		return err
+	}
+	if mode == split.Itemized {
+		return errors.New("recurring expenses cannot be itemized")
+	}
+
+	participants := make([]split.Participant, len(template.Participants))
+	userIDs := []uint{template.PaidByID}
+	for i, participant := range template.Participants {
+		participants[i] = split.Participant{UserID: participant.UserID, Value: participant.Value}
+		userIDs = append(userIDs, participant.UserID)
+	}
+	if _, err := split.Compute(mode, template.Amount, participants); err != nil {
+		return err
+	}
+
+	if _, _, err := resolveExpenseCurrency(group, template.Currency); err != nil {
+		return err
+	}
+
+	return checkGroupMembers(group.ID, userIDs)
+}
+
+func toRecurringParticipants(inputs []SplitParticipantInput) []models.RecurringExpenseParticipant {
+	participants := make([]models.RecurringExpenseParticipant, len(inputs))
+	for i, input := range inputs {
+		participants[i] = models.RecurringExpenseParticipant{UserID: input.UserID, Value: input.Value}
+	}
+	return participants
+}
+
+// canManageRecurringExpense allows the creator, the payer and the group admin to change a template
+func canManageRecurringExpense(template models.RecurringExpense, userID uint) bool {
+	return template.CreatedByID == userID || template.PaidByID == userID || template.Group.AdminID == userID
+}
+
+// findManagedRecurringExpense fetches a template with its group and participants and checks the
+// user may manage it. On failure it returns the HTTP status to respond with.
+func findManagedRecurringExpense(ctx fiber.Ctx, template *models.RecurringExpense) (int, error) {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	if err := database.DB.Preload("Group").Preload("Participants").First(template, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fiber.StatusNotFound, errors.New("Recurring expense not found")
+		}
+		return fiber.StatusInternalServerError, errors.New("Failed to fetch recurring expense: " + err.Error())
+	}
+
+	if !canManageRecurringExpense(*template, userID) {
+		return fiber.StatusForbidden, errors.New("Only the creator, the payer or the group admin can change this recurring expense")
+	}
+
+	return fiber.StatusOK, nil
+}
+
+// @Summary Create a recurring expense
+// @Description Create a template that is turned into a real expense every time it comes due
+// @Tags recurring-expenses
+// @Accept json
+// @Produce json
+// @Param recurring body CreateRecurringExpenseInput true "Recurring expense data"
+// @Success 201 {object} models.RecurringExpense "Recurring expense created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /recurring-expenses [post]
+func CreateRecurringExpense(ctx fiber.Ctx) error {
+	input := new(CreateRecurringExpenseInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Preload("Group").Where("group_id = ? AND user_id = ? AND is_active = ?", input.GroupID, userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	anchorDate, err := parseDate(input.AnchorDate)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid anchor date, expected YYYY-MM-DD",
+		})
+	}
+
+	template := models.RecurringExpense{
+		GroupID:      input.GroupID,
+		CreatedByID:  userID,
+		PaidByID:     input.PaidByID,
+		Description:  input.Description,
+		Amount:       input.Amount,
+		Currency:     groupMember.Group.Currency,
+		SplitMode:    input.SplitMode,
+		Frequency:    input.Frequency,
+		Interval:     input.Interval,
+		AnchorDate:   anchorDate,
+		Participants: toRecurringParticipants(input.Participants),
+	}
+	if template.PaidByID == 0 {
+		template.PaidByID = userID
+	}
+	if template.Interval == 0 {
+		template.Interval = 1
+	}
+	if input.Currency != "" {
+		template.Currency = input.Currency
+	}
+
+	if input.EndDate != "" {
+		endDate, err := parseDate(input.EndDate)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid end date, expected YYYY-MM-DD",
+			})
+		}
+		template.EndDate = &endDate
+	}
+
+	if err := validateRecurringTemplate(template, groupMember.Group); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid recurring expense: " + err.Error(),
+		})
+	}
+	template.Currency, _, _ = resolveExpenseCurrency(groupMember.Group, template.Currency)
+
+	// Occurrences before today are not created retroactively
+	scheduleFrom(&template, time.Now())
+
+	if err := database.DB.Create(&template).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create recurring expense: " + err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(template)
+}
+
+// @Summary Get recurring expenses for a group
+// @Description Get all recurring expense templates of a group
+// @Tags recurring-expenses
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.RecurringExpense "List of recurring expenses"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/recurring-expenses [get]
+func GetRecurringExpenses(ctx fiber.Ctx) error {
+	groupID := ctx.Params("id")
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", groupID, userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	var templates []models.RecurringExpense
+	if err := database.DB.Where("group_id = ?", groupID).
+		Preload("PaidBy").
+		Preload("Participants.User").
+		Order("next_occurrence ASC").
+		Find(&templates).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch recurring expenses: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(templates)
+}
+
+// @Summary Update a recurring expense
+// @Description Change a recurring expense template. Expenses that were already created are not touched.
+// @Tags recurring-expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Recurring expense ID"
+// @Param recurring body UpdateRecurringExpenseInput true "Updated recurring expense data"
+// @Success 200 {object} models.RecurringExpense "Recurring expense updated"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not allowed to change this recurring expense"
+// @Failure 404 {object} map[string]interface{} "Recurring expense not found"
+// @Security ApiKeyAuth
+// @Router /recurring-expenses/{id} [patch]
+func UpdateRecurringExpense(ctx fiber.Ctx) error {
+	input := new(UpdateRecurringExpenseInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	var template models.RecurringExpense
+	if status, err := findManagedRecurringExpense(ctx, &template); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if input.Description != "" {
+		template.Description = input.Description
+	}
+	if input.Amount != 0 {
+		template.Amount = input.Amount
+	}
+	if input.Currency != "" {
+		template.Currency = input.Currency
+	}
+	if input.PaidByID != 0 {
+		template.PaidByID = input.PaidByID
+	}
+	if input.SplitMode != "" {
+		template.SplitMode = input.SplitMode
+	}
+	if len(input.Participants) > 0 {
+		template.Participants = toRecurringParticipants(input.Participants)
+	}
+
+	// Schedule changes start over from today; past occurrences are never re-created
+	reschedule := false
+	if input.Frequency != "" {
+		template.Frequency = input.Frequency
+		reschedule = true
+	}
+	if input.Interval != 0 {
+		template.Interval = input.Interval
+		reschedule = true
+	}
+	if input.AnchorDate != "" {
+		anchorDate, err := parseDate(input.AnchorDate)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid anchor date, expected YYYY-MM-DD",
+			})
+		}
+		template.AnchorDate = anchorDate
+		reschedule = true
+	}
+	if input.EndDate == "none" {
+		template.EndDate = nil
+	} else if input.EndDate != "" {
+		endDate, err := parseDate(input.EndDate)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid end date, expected YYYY-MM-DD",
+			})
+		}
+		template.EndDate = &endDate
+	}
+
+	if err := validateRecurringTemplate(template, template.Group); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid recurring expense: " + err.Error(),
+		})
+	}
+	template.Currency, _, _ = resolveExpenseCurrency(template.Group, template.Currency)
+
+	if reschedule {
+		scheduleFrom(&template, time.Now())
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(&template).Error; err != nil {
+			return err
+		}
+		if len(input.Participants) > 0 {
+			if err := tx.Where("recurring_expense_id = ?", template.ID).Delete(&models.RecurringExpenseParticipant{}).Error; err != nil {
+				return err
+			}
+			for i := range template.Participants {
+				template.Participants[i].RecurringExpenseID = template.ID
+				if err := tx.Create(&template.Participants[i]).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update recurring expense: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(template)
+}
+
+// @Summary Pause a recurring expense
+// @Description Stop creating expenses from a template until it is resumed
+// @Tags recurring-expenses
+// @Produce json
+// @Param id path string true "Recurring expense ID"
+// @Success 200 {object} models.RecurringExpense "Recurring expense paused"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not allowed to change this recurring expense"
+// @Failure 404 {object} map[string]interface{} "Recurring expense not found"
+// @Security ApiKeyAuth
+// @Router /recurring-expenses/{id}/pause [post]
+func PauseRecurringExpense(ctx fiber.Ctx) error {
+	var template models.RecurringExpense
+	if status, err := findManagedRecurringExpense(ctx, &template); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	template.IsPaused = true
+	if err := database.DB.Omit(clause.Associations).Save(&template).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to pause recurring expense: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(template)
+}
+
+// @Summary Resume a recurring expense
+// @Description Start creating expenses from a paused template again. Occurrences missed while paused are skipped.
+// @Tags recurring-expenses
+// @Produce json
+// @Param id path string true "Recurring expense ID"
+// @Success 200 {object} models.RecurringExpense "Recurring expense resumed"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not allowed to change this recurring expense"
+// @Failure 404 {object} map[string]interface{} "Recurring expense not found"
+// @Security ApiKeyAuth
+// @Router /recurring-expenses/{id}/resume [post]
+func ResumeRecurringExpense(ctx fiber.Ctx) error {
+	var template models.RecurringExpense
+	if status, err := findManagedRecurringExpense(ctx, &template); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if template.IsPaused {
+		template.IsPaused = false
+		if template.NextOccurrence.Before(recurrence.Date(time.Now().UTC())) {
+			scheduleFrom(&template, time.Now())
+		}
+	}
+
+	if err := database.DB.Omit(clause.Associations).Save(&template).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to resume recurring expense: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(template)
+}
+
+// @Summary Delete a recurring expense
+// @Description Delete a template. Expenses that were already created are kept.
+// @Tags recurring-expenses
+// @Produce json
+// @Param id path string true "Recurring expense ID"
+// @Success 200 {object} map[string]interface{} "Recurring expense deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not allowed to change this recurring expense"
+// @Failure 404 {object} map[string]interface{} "Recurring expense not found"
+// @Security ApiKeyAuth
+// @Router /recurring-expenses/{id} [delete]
+func DeleteRecurringExpense(ctx fiber.Ctx) error {
+	var template models.RecurringExpense
+	if status, err := findManagedRecurringExpense(ctx, &template); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := deleteRecurringExpenses(database.DB, []uint{template.ID}); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete recurring expense: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Recurring expense deleted successfully",
+	})
+}
+
+// deleteRecurringExpenses removes templates and unlinks the expenses created from them
+func deleteRecurringExpenses(db *gorm.DB, templateIDs []uint) error {
+	if len(templateIDs) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Expense{}).Where("recurring_expense_id IN ?", templateIDs).Update("recurring_expense_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("recurring_expense_id IN ?", templateIDs).Delete(&models.RecurringExpenseParticipant{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", templateIDs).Delete(&models.RecurringExpense{}).Error
+	})
+}
+
+// MaterializeRecurringExpenses creates the expenses of every template that has come due.
+// It is safe to run concurrently from several instances: each template is locked while it is
+// processed, and an expense is never created twice for the same occurrence.
+func MaterializeRecurringExpenses(now time.Time) error {
+	for {
+		processed, err := materializeNextTemplate(now)
+		if err != nil {
+			return err
+		}
+		if !processed {
+			return nil
+		}
+	}
+}
+
+// materializeNextTemplate processes one due template; it reports false when none is left
+func materializeNextTemplate(now time.Time) (bool, error) {
+	processed := false
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var locked models.RecurringExpense
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id").
+			Where("is_paused = ? AND next_occurrence <= ? AND (end_date IS NULL OR next_occurrence <= end_date)", false, now).
+			Order("next_occurrence ASC").
+			First(&locked).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		processed = true
+
+		var template models.RecurringExpense
+		if err := tx.Preload("Group").Preload("Participants").First(&template, locked.ID).Error; err != nil {
+			return err
+		}
+
+		for created := 0; created < maxCatchUpOccurrences; created++ {
+			if template.NextOccurrence.After(now) || (template.EndDate != nil && template.NextOccurrence.After(*template.EndDate)) {
+				break
+			}
+
+			if err := createExpenseOccurrence(tx, template, template.NextOccurrence); err != nil {
+				// A template that can't be applied any more (e.g. a participant left) is paused instead
+				// of failing on every run; its creator is told why
+				log.Printf("Pausing recurring expense %d: %v", template.ID, err)
+				template.IsPaused = true
+				notifyUsers(tx, []uint{template.CreatedByID}, fmt.Sprintf("Recurring expense \"%s\" was paused: %v", template.Description, err))
+				break
+			}
+
+			template.NextIndex++
+			template.NextOccurrence = recurrence.Occurrence(template.AnchorDate, recurrence.Frequency(template.Frequency), template.Interval, template.NextIndex)
+		}
+
+		return tx.Omit(clause.Associations).Save(&template).Error
+	})
+
+	return processed, err
+}
+
+// createExpenseOccurrence creates the expense for one occurrence of a template, unless it already exists
+func createExpenseOccurrence(tx *gorm.DB, template models.RecurringExpense, date time.Time) error {
+	var existing int64
+	// Occurrences in the trash count as well, so deleting one doesn't bring it back
+	if err := tx.Unscoped().Model(&models.Expense{}).Where("recurring_expense_id = ? AND occurrence_date = ?", template.ID, date).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	expenseCurrency, rate, err := resolveExpenseCurrency(template.Group, template.Currency)
+	if err != nil {
+		return err
+	}
+
+	occurrenceDate := date
+	expense := models.Expense{
+		Amount:             template.Amount,
+		Currency:           expenseCurrency,
+		Description:        template.Description,
+		OccurredAt:         date,
+		GroupID:            template.GroupID,
+		PaidByID:           template.PaidByID,
+		ExchangeRate:       rate,
+		BaseAmount:         money.Convert(template.Amount, rate, expenseCurrency, template.Group.Currency),
+		SplitMode:          template.SplitMode,
+		RecurringExpenseID: &template.ID,
+		OccurrenceDate:     &occurrenceDate,
+		ApprovalStatus:     approvalStatusFor(template.Group, template.CreatedByID),
+	}
+
+	if suggestion, _, err := suggestCategory(template.GroupID, template.Description); err != nil {
+		println("Failed to suggest category: " + err.Error())
+	} else if suggestion != nil {
+		expense.CategoryID = &suggestion.ID
+	}
+
+	participants := make([]split.Participant, len(template.Participants))
+	userIDs := []uint{template.PaidByID}
+	for i, participant := range template.Participants {
+		participants[i] = split.Participant{UserID: participant.UserID, Value: participant.Value}
+		userIDs = append(userIDs, participant.UserID)
+	}
+	if err := checkGroupMembers(template.GroupID, userIDs); err != nil {
+		return err
+	}
+
+	expenseShares, err := buildExpenseShares(expense, template.Group.Currency, participants)
+	if err != nil {
+		return err
+	}
+	expensePayers, err := buildExpensePayers(expense, template.Group.Currency, []ExpensePayerInput{{UserID: template.PaidByID, Amount: template.Amount}})
+	if err != nil {
+		return err
+	}
+
+	acknowledgeShares(expenseShares, nil, expensePayers, template.CreatedByID)
+
+	if err := tx.Create(&expense).Error; err != nil {
+		return err
+	}
+	if err := saveExpenseChildren(tx, expense.ID, expensePayers, expenseShares, nil); err != nil {
+		return err
+	}
+	if err := recordRevision(tx, expense, template.CreatedByID, expensePayers, expenseShares, nil); err != nil {
+		return err
+	}
+
+	if expense.ApprovalStatus == models.ExpensePendingApproval {
+		notifyApprovalRequested(tx, template.Group, expense)
+		return nil
+	}
+
+	var recipients []uint
+	for _, share := range expenseShares {
+		if share.UserID != template.PaidByID {
+			recipients = append(recipients, share.UserID)
+		}
+	}
+	notifyUsers(tx, recipients, "New recurring expense in group "+template.Group.Name+": "+template.Description)
+
+	return nil
+}
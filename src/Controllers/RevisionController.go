@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// settledExpenseError is returned when an expense that is part of a settlement is changed
+const settledExpenseError = "This expense is part of a settlement and can't be changed; add a new expense to correct it"
+
+var errExpenseSettled = errors.New(settledExpenseError)
+
+// editedExpenseColumns are the columns edits and reverts write. The settlement columns are left
+// out, so an edit never undoes a settlement made while it was in progress.
+var editedExpenseColumns = []string{
+	"amount", "currency", "exchange_rate", "base_amount", "description", "occurred_at", "paid_by_id",
+	"category_id", "split_mode", "tax", "tip", "service_charge", "charge_split",
+	"approval_status", "reviewed_by_id", "reviewed_at", "review_comment", "updated_at",
+}
+
+// lockUnsettledExpense locks an expense for an edit and checks that it wasn't settled since it was
+// read, returning errExpenseSettled if it was
+func lockUnsettledExpense(tx *gorm.DB, expenseID uint) error {
+	var current models.Expense
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "settled").First(&current, expenseID).Error; err != nil {
+		return err
+	}
+	if current.Settled {
+		return errExpenseSettled
+	}
+	return nil
+}
+
+// snapshotExpense captures an expense with its payers, shares and items
+func snapshotExpense(expense models.Expense, payers []models.ExpensePayer, shares []models.ExpenseShare, items []models.ExpenseItem) models.ExpenseSnapshot {
+	snapshot := models.ExpenseSnapshot{
+		Amount:        expense.Amount,
+		Currency:      expense.Currency,
+		ExchangeRate:  expense.ExchangeRate,
+		BaseAmount:    expense.BaseAmount,
+		Description:   expense.Description,
+		OccurredAt:    expense.OccurredAt,
+		PaidByID:      expense.PaidByID,
+		CategoryID:    expense.CategoryID,
+		SplitMode:     expense.SplitMode,
+		Tax:           expense.Tax,
+		Tip:           expense.Tip,
+		ServiceCharge: expense.ServiceCharge,
+		ChargeSplit:   expense.ChargeSplit,
+		Payers:        make([]models.SnapshotPayer, len(payers)),
+		Shares:        make([]models.SnapshotShare, len(shares)),
+	}
+
+	for i, payer := range payers {
+		snapshot.Payers[i] = models.SnapshotPayer{UserID: payer.UserID, Amount: payer.Amount, BaseAmount: payer.BaseAmount}
+	}
+	for i, share := range shares {
+		snapshot.Shares[i] = models.SnapshotShare{
+			UserID:         share.UserID,
+			AmountOwed:     share.AmountOwed,
+			BaseAmountOwed: share.BaseAmountOwed,
+			SplitValue:     share.SplitValue,
+		}
+	}
+	for _, item := range items {
+		snapshotItem := models.SnapshotItem{Description: item.Description, Amount: item.Amount}
+		for _, assignee := range item.Assignees {
+			snapshotItem.Assignees = append(snapshotItem.Assignees, models.SnapshotItemAssignee{UserID: assignee.UserID, Amount: assignee.Amount})
+		}
+		snapshot.Items = append(snapshot.Items, snapshotItem)
+	}
+
+	return snapshot
+}
+
+// restoreSnapshot sets the fields of an expense from a snapshot and returns the children to save
+func restoreSnapshot(expense *models.Expense, snapshot models.ExpenseSnapshot) ([]models.ExpensePayer, []models.ExpenseShare, []models.ExpenseItem) {
+	expense.Amount = snapshot.Amount
+	expense.Currency = snapshot.Currency
+	expense.ExchangeRate = snapshot.ExchangeRate
+	expense.BaseAmount = snapshot.BaseAmount
+	expense.Description = snapshot.Description
+	if !snapshot.OccurredAt.IsZero() {
+		expense.OccurredAt = snapshot.OccurredAt
+	}
+	expense.PaidByID = snapshot.PaidByID
+	expense.CategoryID = snapshot.CategoryID
+	expense.SplitMode = snapshot.SplitMode
+	expense.Tax = snapshot.Tax
+	expense.Tip = snapshot.Tip
+	expense.ServiceCharge = snapshot.ServiceCharge
+	expense.ChargeSplit = snapshot.ChargeSplit
+
+	payers := make([]models.ExpensePayer, len(snapshot.Payers))
+	for i, payer := range snapshot.Payers {
+		payers[i] = models.ExpensePayer{UserID: payer.UserID, Amount: payer.Amount, BaseAmount: payer.BaseAmount}
+	}
+
+	shares := make([]models.ExpenseShare, len(snapshot.Shares))
+	for i, share := range snapshot.Shares {
+		shares[i] = models.ExpenseShare{
+			UserID:         share.UserID,
+			AmountOwed:     share.AmountOwed,
+			BaseAmountOwed: share.BaseAmountOwed,
+			SplitValue:     share.SplitValue,
+		}
+	}
+
+	var items []models.ExpenseItem
+	for _, snapshotItem := range snapshot.Items {
+		item := models.ExpenseItem{Description: snapshotItem.Description, Amount: snapshotItem.Amount}
+		for _, assignee := range snapshotItem.Assignees {
+			item.Assignees = append(item.Assignees, models.ExpenseItemAssignee{UserID: assignee.UserID, Amount: assignee.Amount})
+		}
+		items = append(items, item)
+	}
+
+	return payers, shares, items
+}
+
+// recordRevision stores the next revision of an expense
+func recordRevision(tx *gorm.DB, expense models.Expense, editorID uint, payers []models.ExpensePayer, shares []models.ExpenseShare, items []models.ExpenseItem) error {
+	var last int
+	if err := tx.Model(&models.ExpenseRevision{}).
+		Where("expense_id = ?", expense.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&last).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.ExpenseRevision{
+		ExpenseID:  expense.ID,
+		Number:     last + 1,
+		EditedByID: editorID,
+		Snapshot:   snapshotExpense(expense, payers, shares, items),
+	}).Error
+}
+
+// ensureBaselineRevision records the current state of an expense created before revisions existed,
+// so its first edit can still be reverted. It must run before the expense is changed.
+func ensureBaselineRevision(tx *gorm.DB, expenseID uint) error {
+	var count int64
+	if err := tx.Model(&models.ExpenseRevision{}).Where("expense_id = ?", expenseID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var expense models.Expense
+	if err := tx.Preload("Payers").Preload("ExpenseShares").Preload("Items.Assignees").First(&expense, expenseID).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.ExpenseRevision{
+		ExpenseID:  expense.ID,
+		Number:     1,
+		EditedByID: expense.PaidByID,
+		Snapshot:   snapshotExpense(expense, expense.Payers, expense.ExpenseShares, expense.Items),
+		CreatedAt:  expense.CreatedAt,
+	}).Error
+}
+
+// @Summary Get the revisions of an expense
+// @Description Get every version of an expense, oldest first, with who made each change
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {array} models.ExpenseRevision "List of revisions"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not found"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/revisions [get]
+func GetExpenseRevisions(ctx fiber.Ctx) error {
+	groupID, status, err := recordGroupID(ctx, "expense")
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var revisions []models.ExpenseRevision
+	if err := database.DB.Where("expense_id = ?", ctx.Params("id")).
+		Preload("EditedBy").
+		Order("number ASC").
+		Find(&revisions).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch revisions: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(revisions)
+}
+
+// @Summary Revert an expense to a revision
+// @Description Restore an earlier version of an expense. The revert is recorded as a new revision. Only the people who paid can revert.
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param number path int true "Revision number"
+// @Success 200 {object} map[string]interface{} "Expense reverted"
+// @Failure 400 {object} map[string]interface{} "Revision can't be applied"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Only payers can revert"
+// @Failure 404 {object} map[string]interface{} "Expense or revision not found"
+// @Failure 409 {object} map[string]interface{} "Expense is settled"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/revisions/{number}/revert [post]
+func RevertExpense(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var expense models.Expense
+	if err := database.DB.Preload("Group").Preload("Payers").Preload("ExpenseShares").Preload("Items.Assignees").First(&expense, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Expense not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch expense: " + err.Error(),
+		})
+	}
+
+	if !isExpensePayer(expense, userID) {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the people who paid can revert this expense",
+		})
+	}
+
+	if expense.Settled {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": settledExpenseError,
+		})
+	}
+
+	number := fiber.Params[int](ctx, "number")
+	var revision models.ExpenseRevision
+	if err := database.DB.Where("expense_id = ? AND number = ?", expense.ID, number).First(&revision).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch revision: " + err.Error(),
+		})
+	}
+
+	before := expense
+	payers, shares, items := restoreSnapshot(&expense, revision.Snapshot)
+	awaitsApproval := resubmitForApproval(&expense, expense.Group, userID)
+
+	// Everyone in the old version must still be in the group
+	userIDs := make([]uint, 0, len(payers)+len(shares))
+	for _, payer := range payers {
+		userIDs = append(userIDs, payer.UserID)
+	}
+	for _, share := range shares {
+		userIDs = append(userIDs, share.UserID)
+	}
+	if err := checkGroupMembers(expense.GroupID, userIDs); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Revision can't be restored: " + err.Error(),
+		})
+	}
+	if expense.CategoryID != nil && checkCategory(expense.GroupID, *expense.CategoryID) != nil {
+		expense.CategoryID = nil
+	}
+
+	// Keep paid flags for participants that stay on the expense
+	paid := make(map[uint]bool)
+	for _, share := range before.ExpenseShares {
+		paid[share.UserID] = share.IsPaid
+	}
+	for i := range shares {
+		shares[i].IsPaid = paid[shares[i].UserID]
+	}
+	acknowledgeShares(shares, before.ExpenseShares, payers, userID)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockUnsettledExpense(tx, expense.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&expense).Select(editedExpenseColumns).Updates(&expense).Error; err != nil {
+			return err
+		}
+		if err := deleteExpenseChildren(tx, []uint{expense.ID}); err != nil {
+			return err
+		}
+		if err := saveExpenseChildren(tx, expense.ID, payers, shares, items); err != nil {
+			return err
+		}
+
+		expense.Payers = payers
+		expense.ExpenseShares = shares
+		expense.Items = items
+
+		comments := append([]string{"reverted to revision " + strconv.Itoa(number)}, describeExpenseChanges(before, expense)...)
+		if err := addSystemComments(tx, expense.ID, userID, comments); err != nil {
+			return err
+		}
+		return recordRevision(tx, expense, userID, payers, shares, items)
+	})
+	if errors.Is(err, errExpenseSettled) {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": settledExpenseError,
+		})
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revert expense: " + err.Error(),
+		})
+	}
+
+	if awaitsApproval {
+		notifyApprovalRequested(database.DB, expense.Group, expense)
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Expense reverted successfully",
+		"expense": expense,
+	})
+}
@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultSettlementReminderDays is how long a receiver has to confirm a settlement before they are
+// reminded, unless SETTLEMENT_REMINDER_DAYS is set
+const defaultSettlementReminderDays = 3
+
+var errSettlementStatus = errors.New("settlement can't change to that status")
+
+type DisputeSettlementInput struct {
+	Reason string `json:"reason"`
+}
+
+// settlementReminderAge is how long a settlement can wait for the receiver before they are reminded,
+// and how long between reminders
+func settlementReminderAge() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("SETTLEMENT_REMINDER_DAYS"))
+	if err != nil || days < 1 {
+		days = defaultSettlementReminderDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// lockSettlement loads a settlement for changing it. The round is locked first, so a settlement
+// can't change while its round is cancelled, and then the settlement, so two changes at the same
+// time happen one after the other.
+func lockSettlement(tx *gorm.DB, settlementID uint) (models.Settlement, error) {
+	var settlement models.Settlement
+	if err := tx.Select("round_id").First(&settlement, settlementID).Error; err != nil {
+		return settlement, err
+	}
+	if settlement.RoundID != nil {
+		var round models.SettlementRound
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&round, *settlement.RoundID).Error; err != nil {
+			return settlement, err
+		}
+		if round.Status == models.RoundCancelled {
+			return settlement, errRoundCancelled
+		}
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&settlement, settlementID).Error
+	return settlement, err
+}
+
+// notifyMarkedPaid asks the receiver of a settlement to confirm they received it
+func notifyMarkedPaid(payerName string, groupName string, settlement models.Settlement) {
+	if err := database.DB.Create(&models.Notification{
+		Message:    fmt.Sprintf("%s marked their settlement of %s %s in %s as paid. Please confirm you received it.", payerName, settlement.Amount.Format(settlement.Currency), settlement.Currency, groupName),
+		UserID:     settlement.ReceiverID,
+		New:        true,
+		Action:     "confirm",
+		ActionPath: fmt.Sprintf("/settlements/%d/confirm", settlement.ID),
+	}).Error; err != nil {
+		println("Failed to send notification: " + err.Error())
+	}
+}
+
+// findSettlement loads the settlement named by the :id route parameter with its group and people
+// and checks that the current user is its payer or, with receiver, its receiver
+func findSettlement(ctx fiber.Ctx, receiver bool) (models.Settlement, int, error) {
+	var settlement models.Settlement
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return settlement, fiber.StatusUnauthorized, errors.New("Failed to extract user ID from token")
+	}
+
+	if err := database.DB.Preload("Group").Preload("Payer").Preload("Receiver").First(&settlement, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return settlement, fiber.StatusNotFound, errors.New("Settlement not found")
+		}
+		return settlement, fiber.StatusInternalServerError, errors.New("Failed to fetch settlement: " + err.Error())
+	}
+
+	if receiver && settlement.ReceiverID != userID {
+		return settlement, fiber.StatusForbidden, errors.New("Only the receiver can do this")
+	}
+	if !receiver && settlement.PayerID != userID {
+		return settlement, fiber.StatusForbidden, errors.New("Only the payer can do this")
+	}
+	return settlement, fiber.StatusOK, nil
+}
+
+// settlementStatusError answers a failed status change
+func settlementStatusError(ctx fiber.Ctx, err error, conflict string) error {
+	switch {
+	case errors.Is(err, errRoundCancelled):
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The settlement round was cancelled",
+		})
+	case errors.Is(err, errSettlementStatus):
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": conflict,
+		})
+	}
+	return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to update settlement: " + err.Error(),
+	})
+}
+
+// @Summary Mark a settlement as paid
+// @Description The payer marks a pending settlement as paid, recording whatever is left of it as a final instalment, or marks a disputed settlement as paid again. The receiver is asked to confirm it.
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Success 200 {object} models.Settlement "Settlement marked paid"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the payer"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Failure 409 {object} map[string]interface{} "Already marked paid or confirmed, or the round was cancelled"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/mark-paid [post]
+func MarkSettlementPaid(ctx fiber.Ctx) error {
+	settlement, status, err := findSettlement(ctx, false)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var marked models.Settlement
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if marked, err = lockSettlement(tx, settlement.ID); err != nil {
+			return err
+		}
+
+		switch marked.Status {
+		case models.SettlementPending:
+			marked, _, err = paySettlement(tx, marked.ID, 0, "")
+			return err
+		case models.SettlementDisputed:
+			now := time.Now()
+			marked.Status = models.SettlementMarkedPaid
+			marked.PaidAt = &now
+			return tx.Model(&marked).Select("status", "paid_at").Updates(&marked).Error
+		}
+		return errSettlementStatus
+	})
+	if errors.Is(err, errSettlementPaid) {
+		err = errSettlementStatus
+	}
+	if err != nil {
+		return settlementStatusError(ctx, err, "This settlement is already "+strings.ReplaceAll(marked.Status, "_", " "))
+	}
+
+	notifyMarkedPaid(settlement.Payer.Username, settlement.Group.Name, marked)
+
+	return ctx.JSON(marked)
+}
+
+// @Summary Confirm a settlement
+// @Description The receiver confirms they received a settlement the payer marked as paid, or one they disputed after all. This completes the settlement.
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Success 200 {object} map[string]interface{} "Settlement confirmed"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the receiver"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Failure 409 {object} map[string]interface{} "Not marked paid yet, or the round was cancelled"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/confirm [post]
+func ConfirmSettlement(ctx fiber.Ctx) error {
+	settlement, status, err := findSettlement(ctx, true)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var confirmed models.Settlement
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if confirmed, err = lockSettlement(tx, settlement.ID); err != nil {
+			return err
+		}
+		if confirmed.Status != models.SettlementMarkedPaid && confirmed.Status != models.SettlementDisputed {
+			return errSettlementStatus
+		}
+
+		now := time.Now()
+		confirmed.Status = models.SettlementConfirmed
+		confirmed.IsConfirmed = true
+		confirmed.ConfirmedAt = &now
+		if err := tx.Model(&confirmed).Select("status", "is_confirmed", "confirmed_at").Updates(&confirmed).Error; err != nil {
+			return err
+		}
+		if confirmed.RoundID != nil {
+			return refreshRoundStatus(tx, *confirmed.RoundID)
+		}
+		return nil
+	})
+	if err != nil {
+		message := "The payer hasn't marked this settlement as paid yet"
+		if confirmed.Status == models.SettlementConfirmed {
+			message = "This settlement is already confirmed"
+		}
+		return settlementStatusError(ctx, err, message)
+	}
+
+	notifyUsers(database.DB, []uint{settlement.PayerID}, fmt.Sprintf("%s confirmed receiving your settlement of %s %s in %s",
+		settlement.Receiver.Username, settlement.Amount.Format(settlement.Currency), settlement.Currency, settlement.Group.Name))
+
+	return ctx.JSON(fiber.Map{
+		"message":       "Settlement confirmed successfully",
+		"settlement_id": settlement.ID,
+	})
+}
+
+// @Summary Dispute a settlement
+// @Description The receiver says they didn't receive a settlement the payer marked as paid. Balances count it as unpaid while it is disputed. The payer is notified and can mark it paid again once it is sorted out.
+// @Tags settlements
+// @Accept json
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Param dispute body DisputeSettlementInput false "Why the settlement is disputed"
+// @Success 200 {object} models.Settlement "Settlement disputed"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the receiver"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Failure 409 {object} map[string]interface{} "Not marked paid, or the round was cancelled"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/dispute [post]
+func DisputeSettlement(ctx fiber.Ctx) error {
+	var input DisputeSettlementInput
+	if len(ctx.Body()) > 0 {
+		if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot parse JSON: " + err.Error(),
+			})
+		}
+	}
+
+	settlement, status, err := findSettlement(ctx, true)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	reason := strings.TrimSpace(input.Reason)
+	if utf8.RuneCountInString(reason) > maxPaymentNoteLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Reason can be at most 500 characters",
+		})
+	}
+
+	var disputed models.Settlement
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if disputed, err = lockSettlement(tx, settlement.ID); err != nil {
+			return err
+		}
+		if disputed.Status != models.SettlementMarkedPaid {
+			return errSettlementStatus
+		}
+
+		now := time.Now()
+		disputed.Status = models.SettlementDisputed
+		disputed.DisputedAt = &now
+		disputed.DisputeReason = reason
+		return tx.Model(&disputed).Select("status", "disputed_at", "dispute_reason").Updates(&disputed).Error
+	})
+	if err != nil {
+		return settlementStatusError(ctx, err, "Only a settlement marked as paid can be disputed; this one is "+strings.ReplaceAll(disputed.Status, "_", " "))
+	}
+
+	message := fmt.Sprintf("%s hasn't received your settlement of %s %s in %s", settlement.Receiver.Username, settlement.Amount.Format(settlement.Currency), settlement.Currency, settlement.Group.Name)
+	if reason != "" {
+		message += ": " + reason
+	}
+	notifyUsers(database.DB, []uint{settlement.PayerID}, message)
+
+	return ctx.JSON(disputed)
+}
+
+// RemindUnconfirmedSettlements reminds receivers of settlements that were marked paid a while ago
+// and that they haven't confirmed or disputed, and reminds them again as long as they don't
+func RemindUnconfirmedSettlements(now time.Time) error {
+	cutoff := now.Add(-settlementReminderAge())
+
+	var settlements []models.Settlement
+	if err := database.DB.Preload("Group").Preload("Payer").
+		Where("status = ? AND paid_at < ? AND (reminded_at IS NULL OR reminded_at < ?)", models.SettlementMarkedPaid, cutoff, cutoff).
+		Order("id").
+		Find(&settlements).Error; err != nil {
+		return err
+	}
+
+	for _, settlement := range settlements {
+		// Claiming the reminder first keeps other instances running this job from sending it too
+		claim := database.DB.Model(&models.Settlement{}).
+			Where("id = ? AND status = ? AND (reminded_at IS NULL OR reminded_at < ?)", settlement.ID, models.SettlementMarkedPaid, cutoff).
+			Update("reminded_at", now)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		if err := database.DB.Create(&models.Notification{
+			Message:    fmt.Sprintf("Reminder: %s marked their settlement of %s %s in %s as paid. Please confirm you received it.", settlement.Payer.Username, settlement.Amount.Format(settlement.Currency), settlement.Currency, settlement.Group.Name),
+			UserID:     settlement.ReceiverID,
+			New:        true,
+			Action:     "confirm",
+			ActionPath: fmt.Sprintf("/settlements/%d/confirm", settlement.ID),
+		}).Error; err != nil {
+			println("Failed to send notification: " + err.Error())
+		}
+	}
+	return nil
+}
@@ -2,37 +2,43 @@ package controllers
 
 import (
 	"encoding/json"
-	"math"
-	"sort"
-	"time"
+	"errors"
+	"fmt"
 
 	"github.com/gofiber/fiber/v3"
 	database "github.com/tjens23/tabsplit-backend/src/Database"
 	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"github.com/tjens23/tabsplit-backend/src/settle"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SettlementInput represents the input for settlement calculation
 type SettlementInput struct {
 	GroupID uint `json:"group_id"`
+
+	// Strategy overrides the group's settlement strategy for this request: greedy, exact or none
+	Strategy string `json:"strategy"`
 }
 
 // DebtBalance represents the net balance for a user (positive = owed to them, negative = they owe)
 type DebtBalance struct {
-	UserID uint    `json:"user_id"`
-	Amount float64 `json:"amount"`
+	UserID uint         `json:"user_id"`
+	Amount money.Amount `json:"amount"`
 }
 
-// SettlementTransaction represents a transaction needed to settle debts
-type SettlementTransaction struct {
-	PayerID    uint    `json:"payer_id"`
-	ReceiverID uint    `json:"receiver_id"`
-	Amount     float64 `json:"amount"`
+// settlementStrategy picks the strategy of a settlement request, falling back to the group's
+func settlementStrategy(input SettlementInput, group models.Group) (settle.Strategy, error) {
+	if input.Strategy != "" {
+		return settle.Parse(input.Strategy)
+	}
+	return settle.Parse(group.SettlementStrategy)
 }
 
 // CalculateSettlements calculates optimal settlements for a group
 // @Summary Calculate settlements for a group
-// @Description Calculate the minimum number of transactions needed to settle all debts in a group
+// @Description Preview the transactions that settle all debts in a group, with the balances they settle. They are simplified with the group's settlement strategy unless the request picks another, and honour the group's settlement rules. Nothing is changed; use /settlements/create to settle.
 // @Tags settlements
 // @Accept json
 // @Produce json
@@ -41,6 +47,7 @@ type SettlementTransaction struct {
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 404 {object} map[string]interface{} "Group not found"
+// @Failure 422 {object} map[string]interface{} "No settlement honours the group's settlement rules"
 // @Security ApiKeyAuth
 // @Router /settlements/calculate [post]
 func CalculateSettlements(ctx fiber.Ctx) error {
@@ -62,7 +69,7 @@ func CalculateSettlements(ctx fiber.Ctx) error {
 
 	// Check if user is member of the group
 	var groupMember models.GroupMember
-	if err := database.DB.Where("group_id = ? AND user_id = ?", input.GroupID, userID).First(&groupMember).Error; err != nil {
+	if err := database.DB.Preload("Group").Where("group_id = ? AND user_id = ?", input.GroupID, userID).First(&groupMember).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "You are not a member of this group",
@@ -73,16 +80,38 @@ func CalculateSettlements(ctx fiber.Ctx) error {
 		})
 	}
 
-	// Calculate debt balances
-	balances, err := calculateDebtBalances(input.GroupID, database.DB)
+	strategy, err := settlementStrategy(input, groupMember.Group)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// A preview only reads, so it can't settle anything by accident
+	ledger, err := loadOpenLedger(database.DB, input.GroupID, false)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to calculate balances: " + err.Error(),
 		})
 	}
+	debts := ledger.debts()
 
-	// Calculate optimal settlements
-	settlements := calculateOptimalSettlements(balances)
+	rules, err := groupRules(database.DB, input.GroupID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement rules: " + err.Error(),
+		})
+	}
+	settlements, err := settle.Plan(strategy, rules, debts)
+	var noPlan *settle.NoPlanError
+	if errors.As(err, &noPlan) {
+		return noPlanResponse(ctx, noPlan, groupMember.Group.Currency)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to plan settlements: " + err.Error(),
+		})
+	}
 
 	// Get user details for response
 	settlementsWithUsers, err := enrichSettlementsWithUserDetails(settlements)
@@ -93,15 +122,20 @@ func CalculateSettlements(ctx fiber.Ctx) error {
 	}
 
 	return ctx.JSON(fiber.Map{
-		"group_id":    input.GroupID,
-		"settlements": settlementsWithUsers,
-		"message":     "Settlement calculation completed successfully",
+		"group_id":      input.GroupID,
+		"currency":      groupMember.Group.Currency,
+		"strategy":      strategy.Name(),
+		"balances":      debtBalances(debts),
+		"expense_count": len(ledger.expenses),
+		"payment_count": len(ledger.payments),
+		"settlements":   settlementsWithUsers,
+		"message":       "Settlement calculation completed successfully",
 	})
 }
 
 // CreateSettlements creates settlements in the database
 // @Summary Create settlements for a group
-// @Description Create settlement records based on calculated optimal settlements
+// @Description Settle the group's open expenses and payments in a new settlement round, with settlement records simplified by the group's settlement strategy unless the request picks another, honouring the group's settlement rules
 // @Tags settlements
 // @Accept json
 // @Produce json
@@ -110,6 +144,8 @@ func CalculateSettlements(ctx fiber.Ctx) error {
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 404 {object} map[string]interface{} "Group not found"
+// @Failure 409 {object} map[string]interface{} "Nothing to settle"
+// @Failure 422 {object} map[string]interface{} "No settlement honours the group's settlement rules"
 // @Security ApiKeyAuth
 // @Router /settlements/create [post]
 func CreateSettlements(ctx fiber.Ctx) error {
@@ -142,58 +178,74 @@ func CreateSettlements(ctx fiber.Ctx) error {
 		})
 	}
 
-	tx := database.DB.Begin()
-	if tx.Error != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to start transaction: " + tx.Error.Error(),
-		})
-	}
-
-	// Calculate debt balances
-	balances, err := calculateDebtBalances(input.GroupID, tx)
+	strategy, err := settlementStrategy(input, group)
 	if err != nil {
-		tx.Rollback()
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to calculate balances: " + err.Error(),
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
 
-	// Calculate optimal settlements
-	settlements := calculateOptimalSettlements(balances)
-
-	// Start transaction
+	// Balances are computed, turned into a round of settlements and marked settled on the same locked rows
+	var settlements []settle.Transfer
+	round := models.SettlementRound{
+		GroupID:     input.GroupID,
+		CreatedByID: userID,
+		Status:      models.RoundOpen,
+		Currency:    group.Currency,
+		Strategy:    strategy.Name(),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		ledger, err := loadOpenLedger(tx, input.GroupID, true)
+		if err != nil {
+			return fmt.Errorf("calculate balances: %w", err)
+		}
+		if ledger.empty() {
+			return errNothingToSettle
+		}
 
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+		rules, err := groupRules(tx, input.GroupID)
+		if err != nil {
+			return fmt.Errorf("load settlement rules: %w", err)
+		}
+		if settlements, err = settle.Plan(strategy, rules, ledger.debts()); err != nil {
+			return err
 		}
-	}()
 
-	// Create settlement records
-	var createdSettlements []models.Settlement
-	for _, settlement := range settlements {
-		settlementRecord := models.Settlement{
-			GroupID:     input.GroupID,
-			PayerID:     settlement.PayerID,
-			ReceiverID:  settlement.ReceiverID,
-			Amount:      settlement.Amount,
-			IsConfirmed: false,
+		if err := tx.Create(&round).Error; err != nil {
+			return fmt.Errorf("create settlement round: %w", err)
+		}
+		for _, settlement := range settlements {
+			if err := tx.Create(&models.Settlement{
+				GroupID:    input.GroupID,
+				PayerID:    settlement.PayerID,
+				ReceiverID: settlement.ReceiverID,
+				Amount:     settlement.Amount,
+				Currency:   group.Currency,
+				RoundID:    &round.ID,
+			}).Error; err != nil {
+				return fmt.Errorf("create settlement: %w", err)
+			}
 		}
 
-		if err := tx.Create(&settlementRecord).Error; err != nil {
-			tx.Rollback()
-			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create settlement: " + err.Error(),
-			})
+		if err := ledger.markSettled(tx, round.ID); err != nil {
+			return fmt.Errorf("mark expenses settled: %w", err)
 		}
 
-		createdSettlements = append(createdSettlements, settlementRecord)
+		// A ledger whose balances cancel out needs no transfers and is completed right away
+		return refreshRoundStatus(tx, round.ID)
+	})
+	if errors.Is(err, errNothingToSettle) {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "There is nothing to settle in this group",
+		})
 	}
-
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
+	var noPlan *settle.NoPlanError
+	if errors.As(err, &noPlan) {
+		return noPlanResponse(ctx, noPlan, group.Currency)
+	}
+	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to commit transaction: " + err.Error(),
+			"error": "Failed to create settlements: " + err.Error(),
 		})
 	}
 
@@ -221,134 +273,137 @@ func CreateSettlements(ctx fiber.Ctx) error {
 
 	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"group_id":    input.GroupID,
+		"round_id":    round.ID,
+		"currency":    group.Currency,
+		"strategy":    strategy.Name(),
 		"settlements": settlementsWithUsers,
 		"message":     "Settlements created successfully",
 	})
 }
 
-// calculateDebtBalances calculates the net balance for each user in a group
-func calculateDebtBalances(groupID uint, tx *gorm.DB) ([]DebtBalance, error) {
-	// Get all expenses for the group
-	var expenses []models.Expense
-	if err := database.DB.Where("group_id = ? and settled = ?", groupID, false).Preload("ExpenseShares").Find(&expenses).Error; err != nil {
-		return nil, err
-	}
-
-	// Calculate net balances for each user
-	userBalances := make(map[uint]float64)
+// openLedger is what the next settlement of a group covers: its approved expenses that aren't
+// settled yet and its direct payments since the last settlement that weren't rejected
+type openLedger struct {
+	expenses []models.Expense
+	payments []models.Payment
+}
 
-	for _, expense := range expenses {
-		// Add the amount paid by the payer
-		userBalances[expense.PaidByID] += expense.Amount
+// loadOpenLedger reads the open ledger of a group without changing it. With lock, the rows stay
+// locked until the transaction ends, so they can't change between computing balances and marking
+// them settled, and a concurrent settlement waits and then finds them settled.
+func loadOpenLedger(db *gorm.DB, groupID uint, lock bool) (openLedger, error) {
+	var ledger openLedger
 
-		// Subtract the amounts owed by each user
-		for _, share := range expense.ExpenseShares {
-			if !share.IsPaid {
-				userBalances[share.UserID] -= share.AmountOwed
-			}
-		}
-
-		expense.Settled = true
-		if err := tx.Save(&expense).Error; err != nil {
-			return nil, err
-		}
+	var group models.Group
+	if err := db.Select("exclude_disputed_expenses").First(&group, groupID).Error; err != nil {
+		return ledger, err
 	}
 
-	// Convert to slice and filter out zero balances
-	var balances []DebtBalance
-	for userID, amount := range userBalances {
-		if math.Abs(amount) > 0.01 { // Ignore amounts less than 1 cent
-			balances = append(balances, DebtBalance{
-				UserID: userID,
-				Amount: amount,
-			})
-		}
+	if lock {
+		// A new session keeps the conditions of the two queries below apart
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"}).Session(&gorm.Session{})
 	}
 
-	return balances, nil
-}
+	query := db.Where("group_id = ? and settled = ? and approval_status = ?", groupID, false, models.ExpenseApproved)
+	if group.ExcludeDisputedExpenses {
+		// Disputed expenses wait for a later settlement, once they are resolved
+		query = query.Where("NOT EXISTS (SELECT 1 FROM expense_shares WHERE expense_shares.expense_id = expenses.id AND expense_shares.acknowledgement = ?)", models.ShareDisputed)
+	}
+	if err := query.Preload("Payers").Preload("ExpenseShares").Order("id").Find(&ledger.expenses).Error; err != nil {
+		return ledger, err
+	}
 
-// calculateOptimalSettlements implements the debt simplification algorithm
-func calculateOptimalSettlements(balances []DebtBalance) []SettlementTransaction {
-	if len(balances) == 0 {
-		return []SettlementTransaction{}
+	if err := db.Where("group_id = ? AND settled = ? AND status <> ?", groupID, false, models.PaymentRejected).
+		Order("id").
+		Find(&ledger.payments).Error; err != nil {
+		return ledger, err
 	}
 
-	// Make a copy to avoid modifying original
-	workingBalances := make([]DebtBalance, len(balances))
-	copy(workingBalances, balances)
+	return ledger, nil
+}
 
-	var settlements []SettlementTransaction
+// debts works out who owes whom in the ledger, in the group currency using the rate stored on each
+// expense. A participant owes each payer of an expense part of their share, in proportion to what
+// that payer paid; shares already marked paid are left out. A direct payment is owed back by its
+// receiver, so it cancels out what the payer owed them. What two users owe each other is netted.
+func (l openLedger) debts() []settle.Transfer {
+	var debts []settle.Transfer
+	for _, expense := range l.expenses {
+		weights := make([]int64, len(expense.Payers))
+		for i, payer := range expense.Payers {
+			weights[i] = int64(payer.BaseAmount)
+		}
 
-	for {
-		// Sort balances: most negative (owes most) first, then most positive (owed most) first
-		sort.Slice(workingBalances, func(i, j int) bool {
-			if workingBalances[i].Amount < 0 && workingBalances[j].Amount < 0 {
-				return workingBalances[i].Amount < workingBalances[j].Amount // More negative first
-			}
-			if workingBalances[i].Amount > 0 && workingBalances[j].Amount > 0 {
-				return workingBalances[i].Amount > workingBalances[j].Amount // More positive first
+		for _, share := range expense.ExpenseShares {
+			if share.IsPaid || share.BaseAmountOwed == 0 {
+				continue
 			}
-			return workingBalances[i].Amount < workingBalances[j].Amount // Negative before positive
-		})
-
-		// Find the most negative and most positive balances
-		var minIndex, maxIndex int = -1, -1
-
-		for i, balance := range workingBalances {
-			if balance.Amount < -0.01 && minIndex == -1 {
-				minIndex = i
+			parts, err := money.Allocate(share.BaseAmountOwed, weights)
+			if err != nil {
+				// Nobody paid anything, so nobody is owed
+				continue
 			}
-			if balance.Amount > 0.01 && maxIndex == -1 {
-				maxIndex = i
+			for i, payer := range expense.Payers {
+				debt := settle.Transfer{PayerID: share.UserID, ReceiverID: payer.UserID, Amount: parts[i]}
+				// Income is the other way around: the receivers owe it to the participants
+				if expense.Type == models.EntryIncome {
+					debt.PayerID, debt.ReceiverID = debt.ReceiverID, debt.PayerID
+				}
+				debts = append(debts, debt)
 			}
 		}
+	}
 
-		// If no negative or no positive balance found, we're done
-		if minIndex == -1 || maxIndex == -1 {
-			break
-		}
-
-		debtor := &workingBalances[minIndex]
-		creditor := &workingBalances[maxIndex]
+	for _, payment := range l.payments {
+		debts = append(debts, settle.Transfer{PayerID: payment.ReceiverID, ReceiverID: payment.PayerID, Amount: payment.Amount})
+	}
 
-		// Calculate settlement amount (minimum of what debtor owes and what creditor is owed)
-		settlementAmount := math.Min(math.Abs(debtor.Amount), creditor.Amount)
+	return settle.Net(debts)
+}
 
-		// Round to 2 decimal places
-		settlementAmount = math.Round(settlementAmount*100) / 100
+// debtBalances is the net balance of each user in the debts, sorted by user ID
+func debtBalances(debts []settle.Transfer) []DebtBalance {
+	balances := []DebtBalance{}
+	for _, balance := range settle.Balances(debts) {
+		balances = append(balances, DebtBalance{UserID: balance.UserID, Amount: balance.Amount})
+	}
+	return balances
+}
 
-		// Create settlement transaction
-		settlements = append(settlements, SettlementTransaction{
-			PayerID:    debtor.UserID,
-			ReceiverID: creditor.UserID,
-			Amount:     settlementAmount,
-		})
+// empty reports whether there is nothing to settle
+func (l openLedger) empty() bool {
+	return len(l.expenses) == 0 && len(l.payments) == 0
+}
 
-		// Update balances
-		debtor.Amount += settlementAmount
-		creditor.Amount -= settlementAmount
+// markSettled marks the expenses and payments of the ledger as covered by a settlement round
+func (l openLedger) markSettled(tx *gorm.DB, roundID uint) error {
+	settled := map[string]any{"settled": true, "settlement_round_id": roundID}
 
-		// Remove balances that are now effectively zero
-		workingBalances = filterNonZeroBalances(workingBalances)
+	if len(l.expenses) > 0 {
+		ids := make([]uint, len(l.expenses))
+		for i, expense := range l.expenses {
+			ids[i] = expense.ID
+		}
+		if err := tx.Model(&models.Expense{}).Where("id IN ?", ids).Updates(settled).Error; err != nil {
+			return err
+		}
 	}
 
-	return settlements
-}
-
-// filterNonZeroBalances removes balances that are effectively zero
-func filterNonZeroBalances(balances []DebtBalance) []DebtBalance {
-	var filtered []DebtBalance
-	for _, balance := range balances {
-		if math.Abs(balance.Amount) > 0.01 { // Keep balances greater than 1 cent
-			filtered = append(filtered, balance)
+	if len(l.payments) > 0 {
+		ids := make([]uint, len(l.payments))
+		for i, payment := range l.payments {
+			ids[i] = payment.ID
+		}
+		if err := tx.Model(&models.Payment{}).Where("id IN ?", ids).Updates(settled).Error; err != nil {
+			return err
 		}
 	}
-	return filtered
+
+	return nil
 }
 
 // enrichSettlementsWithUserDetails adds user information to settlements
-func enrichSettlementsWithUserDetails(settlements []SettlementTransaction) ([]map[string]interface{}, error) {
+func enrichSettlementsWithUserDetails(settlements []settle.Transfer) ([]map[string]interface{}, error) {
 	var enrichedSettlements []map[string]interface{}
 
 	for _, settlement := range settlements {
@@ -422,6 +477,7 @@ func GetGroupSettlements(ctx fiber.Ctx) error {
 	if err := database.DB.Where("group_id = ?", groupID).
 		Preload("Payer").
 		Preload("Receiver").
+		Preload("Attachments").
 		Find(&settlements).Error; err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch settlements: " + err.Error(),
@@ -430,62 +486,3 @@ func GetGroupSettlements(ctx fiber.Ctx) error {
 
 	return ctx.JSON(settlements)
 }
-
-// ConfirmSettlement confirms a settlement transaction
-// @Summary Confirm a settlement
-// @Description Mark a settlement as confirmed by the payer
-// @Tags settlements
-// @Produce json
-// @Param id path string true "Settlement ID"
-// @Success 200 {object} map[string]interface{} "Settlement confirmed"
-// @Failure 401 {object} map[string]interface{} "Unauthorized"
-// @Failure 403 {object} map[string]interface{} "Not authorized to confirm"
-// @Failure 404 {object} map[string]interface{} "Settlement not found"
-// @Security ApiKeyAuth
-// @Router /settlements/{id}/confirm [post]
-func ConfirmSettlement(ctx fiber.Ctx) error {
-	settlementID := ctx.Params("id")
-
-	// Get user ID from JWT token
-	userID, err := getUserIDFromJWT(ctx)
-	if err != nil {
-		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Failed to extract user ID from token",
-		})
-	}
-
-	// Get settlement
-	var settlement models.Settlement
-	if err := database.DB.First(&settlement, settlementID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Settlement not found",
-			})
-		}
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch settlement: " + err.Error(),
-		})
-	}
-
-	// Check if user is the payer of this settlement
-	if settlement.PayerID != userID {
-		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Only the payer can confirm this settlement",
-		})
-	}
-
-	// Update settlement status and paid date
-	settlement.IsConfirmed = true
-	now := time.Now()
-	settlement.PaidAt = &now
-	if err := database.DB.Save(&settlement).Error; err != nil {
-		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update settlement: " + err.Error(),
-		})
-	}
-
-	return ctx.JSON(fiber.Map{
-		"message":       "Settlement confirmed successfully",
-		"settlement_id": settlement.ID,
-	})
-}
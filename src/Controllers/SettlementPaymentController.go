@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
+
+var (
+	errSettlementPaid = errors.New("settlement is already marked paid")
+	errOverpayment    = errors.New("payment is more than what is left of the settlement")
+)
+
+type SettlementPaymentInput struct {
+	Amount money.Amount `json:"amount"`
+	Note   string       `json:"note"`
+}
+
+// SettlementProgress is how much of a settlement is paid and what is left
+type SettlementProgress struct {
+	SettlementID uint                       `json:"settlement_id"`
+	Currency     string                     `json:"currency"`
+	Amount       money.Amount               `json:"amount"`
+	AmountPaid   money.Amount               `json:"amount_paid"`
+	Remaining    money.Amount               `json:"remaining"`
+	Status       string                     `json:"status"`
+	IsConfirmed  bool                       `json:"is_confirmed"`
+	PaidAt       *time.Time                 `json:"paid_at"`
+	Payments     []models.SettlementPayment `json:"payments"`
+}
+
+func settlementProgress(settlement models.Settlement, payments []models.SettlementPayment) SettlementProgress {
+	if payments == nil {
+		payments = []models.SettlementPayment{}
+	}
+	return SettlementProgress{
+		SettlementID: settlement.ID,
+		Currency:     settlement.Currency,
+		Amount:       settlement.Amount,
+		AmountPaid:   settlement.AmountPaid,
+		Remaining:    settlement.Amount - settlement.AmountPaid,
+		Status:       settlement.Status,
+		IsConfirmed:  settlement.IsConfirmed,
+		PaidAt:       settlement.PaidAt,
+		Payments:     payments,
+	}
+}
+
+// openSettlementBalance returns what others still owe a user minus what the user still owes on
+// settlements that aren't paid in full, so balances count a settlement until its last instalment.
+// A disputed settlement counts as unpaid until the payer marks it paid again.
+func openSettlementBalance(groupID uint, userID uint) (money.Amount, error) {
+	var balance money.Amount
+	err := database.DB.Model(&models.Settlement{}).
+		Where("group_id = ? AND is_confirmed = ? AND (payer_id = ? OR receiver_id = ?)", groupID, false, userID, userID).
+		Select(`COALESCE(SUM((CASE WHEN receiver_id = ? THEN 1 ELSE -1 END) *
+			(amount - CASE WHEN status = ? THEN 0 ELSE amount_paid END)), 0)::bigint`, userID, models.SettlementDisputed).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// paySettlement records an instalment towards a pending settlement, or pays what is left when
+// amount is 0, and marks the settlement paid once it is paid in full, for the receiver to confirm
+func paySettlement(tx *gorm.DB, settlementID uint, amount money.Amount, note string) (models.Settlement, models.SettlementPayment, error) {
+	var payment models.SettlementPayment
+
+	settlement, err := lockSettlement(tx, settlementID)
+	if err != nil {
+		return settlement, payment, err
+	}
+
+	remaining := settlement.Amount - settlement.AmountPaid
+	if settlement.Status != models.SettlementPending || remaining <= 0 {
+		return settlement, payment, errSettlementPaid
+	}
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return settlement, payment, errOverpayment
+	}
+
+	payment = models.SettlementPayment{
+		SettlementID: settlement.ID,
+		Amount:       amount,
+		Currency:     settlement.Currency,
+		Note:         note,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return settlement, payment, err
+	}
+
+	settlement.AmountPaid += amount
+	if settlement.AmountPaid == settlement.Amount {
+		now := time.Now()
+		settlement.Status = models.SettlementMarkedPaid
+		settlement.PaidAt = &now
+	}
+	if err := tx.Model(&settlement).Select("amount_paid", "status", "paid_at").Updates(&settlement).Error; err != nil {
+		return settlement, payment, err
+	}
+
+	if settlement.RoundID != nil {
+		if err := refreshRoundStatus(tx, *settlement.RoundID); err != nil {
+			return settlement, payment, err
+		}
+	}
+	return settlement, payment, nil
+}
+
+// @Summary Pay part of a settlement
+// @Description Record an instalment the payer paid towards a settlement. The settlement is marked paid automatically once its instalments add up to its amount, and the receiver is asked to confirm it; until then balances count what is left.
+// @Tags settlements
+// @Accept json
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Param payment body SettlementPaymentInput true "Instalment"
+// @Success 201 {object} SettlementProgress "Payment recorded"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the payer"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Failure 409 {object} map[string]interface{} "Already marked paid, more than what is left, or the round was cancelled"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/payments [post]
+func PaySettlement(ctx fiber.Ctx) error {
+	var input SettlementPaymentInput
+	if err := json.Unmarshal(ctx.Body(), &input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var settlement models.Settlement
+	if err := database.DB.Preload("Group").Preload("Payer").First(&settlement, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Settlement not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement: " + err.Error(),
+		})
+	}
+
+	if settlement.PayerID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the payer can pay this settlement",
+		})
+	}
+	if input.Amount <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Amount must be positive",
+		})
+	}
+	note := strings.TrimSpace(input.Note)
+	if utf8.RuneCountInString(note) > maxPaymentNoteLength {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Note can be at most 500 characters",
+		})
+	}
+
+	var paid models.Settlement
+	var payment models.SettlementPayment
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		paid, payment, err = paySettlement(tx, settlement.ID, input.Amount, note)
+		return err
+	})
+	switch {
+	case errors.Is(err, errRoundCancelled):
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The settlement round was cancelled",
+		})
+	case errors.Is(err, errSettlementPaid):
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "This settlement is already marked paid",
+		})
+	case errors.Is(err, errOverpayment):
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Only %s %s is left to pay", (paid.Amount - paid.AmountPaid).Format(paid.Currency), paid.Currency),
+		})
+	case err != nil:
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record payment: " + err.Error(),
+		})
+	}
+
+	if paid.Status == models.SettlementMarkedPaid {
+		notifyMarkedPaid(settlement.Payer.Username, settlement.Group.Name, paid)
+	} else {
+		notifyUsers(database.DB, []uint{paid.ReceiverID}, fmt.Sprintf("%s paid you %s %s towards their settlement in %s. %s %s is left.",
+			settlement.Payer.Username, payment.Amount.Format(paid.Currency), paid.Currency, settlement.Group.Name,
+			(paid.Amount-paid.AmountPaid).Format(paid.Currency), paid.Currency))
+	}
+
+	var payments []models.SettlementPayment
+	database.DB.Where("settlement_id = ?", paid.ID).Order("id").Find(&payments)
+
+	return ctx.Status(fiber.StatusCreated).JSON(settlementProgress(paid, payments))
+}
+
+// @Summary Get the payments of a settlement
+// @Description Show how much of a settlement is paid, the instalments, and what is left
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement ID"
+// @Success 200 {object} SettlementProgress "Settlement progress"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Settlement not found"
+// @Security ApiKeyAuth
+// @Router /settlements/{id}/payments [get]
+func GetSettlementPayments(ctx fiber.Ctx) error {
+	var settlement models.Settlement
+	if err := database.DB.Preload("Payments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&settlement, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Settlement not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement: " + err.Error(),
+		})
+	}
+
+	if _, status, err := memberGroupAccess(ctx, settlement.GroupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(settlementProgress(settlement, settlement.Payments))
+}
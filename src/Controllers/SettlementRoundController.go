@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errNothingToSettle = errors.New("nothing to settle")
+	errRoundNotOpen    = errors.New("only settlement rounds without confirmed settlements can be cancelled")
+	errRoundCancelled  = errors.New("settlement round was cancelled")
+)
+
+// refreshRoundStatus derives the status of a round from its settlements: open until something is
+// paid towards one, partially paid until all are confirmed, then completed. Cancelled rounds stay
+// cancelled.
+func refreshRoundStatus(tx *gorm.DB, roundID uint) error {
+	var round models.SettlementRound
+	if err := tx.First(&round, roundID).Error; err != nil {
+		return err
+	}
+	if round.Status == models.RoundCancelled {
+		return nil
+	}
+
+	var total, confirmed, started int64
+	if err := tx.Model(&models.Settlement{}).Where("round_id = ?", roundID).Count(&total).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Settlement{}).Where("round_id = ? AND is_confirmed = ?", roundID, true).Count(&confirmed).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Settlement{}).Where("round_id = ? AND amount_paid > 0", roundID).Count(&started).Error; err != nil {
+		return err
+	}
+
+	status := models.RoundOpen
+	completedAt := round.CompletedAt
+	switch {
+	case confirmed == total:
+		status = models.RoundCompleted
+		if completedAt == nil {
+			now := time.Now()
+			completedAt = &now
+		}
+	case confirmed > 0 || started > 0:
+		status = models.RoundPartiallyPaid
+		completedAt = nil
+	default:
+		completedAt = nil
+	}
+
+	if status == round.Status && completedAt == round.CompletedAt {
+		return nil
+	}
+	return tx.Model(&round).Select("status", "completed_at").Updates(models.SettlementRound{Status: status, CompletedAt: completedAt}).Error
+}
+
+// loadRoundCoverage fills in the expenses and payments each round covers
+func loadRoundCoverage(rounds []models.SettlementRound) error {
+	if len(rounds) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(rounds))
+	byID := make(map[uint]*models.SettlementRound)
+	for i := range rounds {
+		ids[i] = rounds[i].ID
+		byID[rounds[i].ID] = &rounds[i]
+		rounds[i].ExpenseIDs = []uint{}
+		rounds[i].PaymentIDs = []uint{}
+	}
+
+	type covered struct {
+		ID                uint
+		SettlementRoundID uint
+	}
+
+	var expenses []covered
+	if err := database.DB.Model(&models.Expense{}).Select("id, settlement_round_id").Where("settlement_round_id IN ?", ids).Order("id").Scan(&expenses).Error; err != nil {
+		return err
+	}
+	for _, expense := range expenses {
+		round := byID[expense.SettlementRoundID]
+		round.ExpenseIDs = append(round.ExpenseIDs, expense.ID)
+	}
+
+	var payments []covered
+	if err := database.DB.Model(&models.Payment{}).Select("id, settlement_round_id").Where("settlement_round_id IN ?", ids).Order("id").Scan(&payments).Error; err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		round := byID[payment.SettlementRoundID]
+		round.PaymentIDs = append(round.PaymentIDs, payment.ID)
+	}
+
+	return nil
+}
+
+// cancelRound puts what a round covered back into the balances and deletes its settlements, as
+// long as none of them is confirmed. What was already paid towards a settlement is kept as a direct
+// payment, which the receiver can still reject. It returns the attachments of the deleted
+// settlements for removing their files after commit, and the payments that were kept.
+func cancelRound(tx *gorm.DB, roundID uint, cancelledBy uint) ([]models.Attachment, []models.Payment, error) {
+	var round models.SettlementRound
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&round, roundID).Error; err != nil {
+		return nil, nil, err
+	}
+	if round.Status != models.RoundOpen && round.Status != models.RoundPartiallyPaid {
+		return nil, nil, errRoundNotOpen
+	}
+	var confirmed int64
+	if err := tx.Model(&models.Settlement{}).Where("round_id = ? AND is_confirmed = ?", round.ID, true).Count(&confirmed).Error; err != nil {
+		return nil, nil, err
+	}
+	if confirmed > 0 {
+		return nil, nil, errRoundNotOpen
+	}
+
+	var paid []models.Settlement
+	if err := tx.Where("round_id = ? AND amount_paid > 0", round.ID).Order("id").Find(&paid).Error; err != nil {
+		return nil, nil, err
+	}
+	kept := []models.Payment{}
+	for _, settlement := range paid {
+		payment := models.Payment{
+			GroupID:    settlement.GroupID,
+			PayerID:    settlement.PayerID,
+			ReceiverID: settlement.ReceiverID,
+			Amount:     settlement.AmountPaid,
+			Currency:   settlement.Currency,
+			Note:       "Paid towards a cancelled settlement",
+			Status:     models.PaymentPending,
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return nil, nil, fmt.Errorf("keep settlement payments: %w", err)
+		}
+		kept = append(kept, payment)
+	}
+
+	unsettled := map[string]any{"settled": false, "settlement_round_id": nil}
+	// Trashed expenses are included, so restoring one brings it back unsettled
+	if err := tx.Unscoped().Model(&models.Expense{}).Where("settlement_round_id = ?", round.ID).Updates(unsettled).Error; err != nil {
+		return nil, nil, fmt.Errorf("reopen expenses: %w", err)
+	}
+	if err := tx.Model(&models.Payment{}).Where("settlement_round_id = ?", round.ID).Updates(unsettled).Error; err != nil {
+		return nil, nil, fmt.Errorf("reopen payments: %w", err)
+	}
+
+	settlements := tx.Model(&models.Settlement{}).Select("id").Where("round_id = ?", round.ID)
+	attachments, err := deleteAttachmentRecords(tx, "settlement_id IN (?)", settlements)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Where("settlement_id IN (?)", settlements).Delete(&models.SettlementPayment{}).Error; err != nil {
+		return nil, nil, fmt.Errorf("delete settlement payments: %w", err)
+	}
+	if err := tx.Where("round_id = ?", round.ID).Delete(&models.Settlement{}).Error; err != nil {
+		return nil, nil, fmt.Errorf("delete settlements: %w", err)
+	}
+
+	now := time.Now()
+	if err := tx.Model(&round).Updates(models.SettlementRound{
+		Status:        models.RoundCancelled,
+		CancelledAt:   &now,
+		CancelledByID: &cancelledBy,
+	}).Error; err != nil {
+		return nil, nil, err
+	}
+	return attachments, kept, nil
+}
+
+// @Summary Get the settlement rounds of a group
+// @Description List every time the group was settled, newest first, with the settlements of each round and the expenses and payments it covers
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.SettlementRound "Settlement rounds"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/settlement-rounds [get]
+func GetSettlementRounds(ctx fiber.Ctx) error {
+	groupID := fiber.Params[uint](ctx, "id")
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var rounds []models.SettlementRound
+	if err := database.DB.Where("group_id = ?", groupID).
+		Preload("CreatedBy").
+		Preload("CancelledBy").
+		Preload("Settlements.Payer").
+		Preload("Settlements.Receiver").
+		Order("id DESC").
+		Find(&rounds).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement rounds: " + err.Error(),
+		})
+	}
+
+	if err := loadRoundCoverage(rounds); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settled expenses: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(rounds)
+}
+
+// @Summary Get a settlement round
+// @Description Get a settlement round with its settlements and the expenses and payments it covers
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement round ID"
+// @Success 200 {object} models.SettlementRound "Settlement round"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Settlement round not found"
+// @Security ApiKeyAuth
+// @Router /settlement-rounds/{id} [get]
+func GetSettlementRound(ctx fiber.Ctx) error {
+	var round models.SettlementRound
+	if err := database.DB.
+		Preload("CreatedBy").
+		Preload("CancelledBy").
+		Preload("Settlements.Payer").
+		Preload("Settlements.Receiver").
+		Preload("Settlements.Attachments").
+		First(&round, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Settlement round not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement round: " + err.Error(),
+		})
+	}
+
+	if _, status, err := memberGroupAccess(ctx, round.GroupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	rounds := []models.SettlementRound{round}
+	if err := loadRoundCoverage(rounds); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settled expenses: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(rounds[0])
+}
+
+// @Summary Cancel a settlement round
+// @Description Undo a settlement round before any of its settlements is confirmed. Its settlements are deleted and the expenses and payments it covered count towards balances again. What was already paid towards a settlement is kept as a direct payment. Only the group admin can cancel.
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement round ID"
+// @Success 200 {object} map[string]interface{} "Round cancelled"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Settlement round not found"
+// @Failure 409 {object} map[string]interface{} "A settlement is already confirmed or the round is cancelled"
+// @Security ApiKeyAuth
+// @Router /settlement-rounds/{id}/cancel [post]
+func CancelSettlementRound(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var round models.SettlementRound
+	if err := database.DB.Preload("Group").First(&round, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Settlement round not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement round: " + err.Error(),
+		})
+	}
+
+	if round.Group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the group admin can cancel a settlement round",
+		})
+	}
+
+	var removed []models.Attachment
+	var kept []models.Payment
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		removed, kept, err = cancelRound(tx, round.ID, userID)
+		return err
+	})
+	if errors.Is(err, errRoundNotOpen) {
+		return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Only settlement rounds without confirmed settlements can be cancelled; this one is " + round.Status + " or a settlement in it is confirmed",
+		})
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to cancel settlement round: " + err.Error(),
+		})
+	}
+	removeAttachmentFiles(removed)
+
+	var memberIDs []uint
+	database.DB.Model(&models.GroupMember{}).Where("group_id = ? AND is_active = ? AND user_id <> ?", round.GroupID, true, userID).Pluck("user_id", &memberIDs)
+	message := "The settlement of " + round.Group.Name + " was cancelled. Its expenses are open again."
+	if len(kept) > 0 {
+		message += " What was already paid towards its settlements counts as direct payments."
+	}
+	notifyUsers(database.DB, memberIDs, message)
+
+	return ctx.JSON(fiber.Map{
+		"message":       "Settlement round cancelled",
+		"round_id":      round.ID,
+		"kept_payments": kept,
+	})
+}
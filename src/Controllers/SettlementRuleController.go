@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/settle"
+	"gorm.io/gorm"
+)
+
+// BothDirections also adds the rule from the receiver to the payer
+type CreateSettlementRuleInput struct {
+	PayerID        uint   `json:"payer_id"`
+	ReceiverID     uint   `json:"receiver_id"`
+	Kind           string `json:"kind"`
+	BothDirections bool   `json:"both_directions"`
+}
+
+// groupRules loads the settlement rules of a group, preferred pairs in the order they were added
+func groupRules(db *gorm.DB, groupID uint) (settle.Rules, error) {
+	var rules settle.Rules
+
+	var rows []models.SettlementRule
+	if err := db.Where("group_id = ?", groupID).Order("id").Find(&rows).Error; err != nil {
+		return rules, err
+	}
+	for _, row := range rows {
+		pair := settle.Pair{PayerID: row.PayerID, ReceiverID: row.ReceiverID}
+		if row.Kind == models.RuleForbidden {
+			rules.Forbidden = append(rules.Forbidden, pair)
+		} else {
+			rules.Preferred = append(rules.Preferred, pair)
+		}
+	}
+	return rules, nil
+}
+
+// noPlanResponse explains that the group's rules leave no way to settle, naming who would be left
+// owing or owed
+func noPlanResponse(ctx fiber.Ctx, noPlan *settle.NoPlanError, currency string) error {
+	ids := make([]uint, len(noPlan.Unsettled))
+	for i, balance := range noPlan.Unsettled {
+		ids[i] = balance.UserID
+	}
+	var users []models.User
+	database.DB.Select("id, username").Find(&users, ids)
+	usernames := make(map[uint]string)
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+	}
+
+	var parts []string
+	unsettled := []fiber.Map{}
+	for _, balance := range noPlan.Unsettled {
+		if balance.Amount < 0 {
+			parts = append(parts, fmt.Sprintf("%s would still owe %s %s", usernames[balance.UserID], balance.Amount.Abs().Format(currency), currency))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s would still be owed %s %s", usernames[balance.UserID], balance.Amount.Format(currency), currency))
+		}
+		unsettled = append(unsettled, fiber.Map{
+			"user_id":  balance.UserID,
+			"username": usernames[balance.UserID],
+			"amount":   balance.Amount,
+		})
+	}
+
+	return ctx.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error":     "No settlement honours the group's settlement rules: " + strings.Join(parts, ", ") + ". Remove a forbidden pair so they can pay each other, directly or through another member.",
+		"unsettled": unsettled,
+	})
+}
+
+// @Summary Get the settlement rules of a group
+// @Description List the pairs that settle with each other first (preferred) or never pay each other directly (forbidden)
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.SettlementRule "Settlement rules"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/settlement-rules [get]
+func GetSettlementRules(ctx fiber.Ctx) error {
+	groupID := fiber.Params[uint](ctx, "id")
+	if _, status, err := memberGroupAccess(ctx, groupID); err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var rules []models.SettlementRule
+	if err := database.DB.Where("group_id = ?", groupID).
+		Preload("Payer").
+		Preload("Receiver").
+		Order("id").
+		Find(&rules).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement rules: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(rules)
+}
+
+// @Summary Add a settlement rule to a group
+// @Description Make a payer settle with a receiver first (preferred) or never pay them directly (forbidden). A rule replaces the one the pair already has in that direction. Only the group admin can add rules.
+// @Tags settlements
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param rule body CreateSettlementRuleInput true "Rule data"
+// @Success 201 {array} models.SettlementRule "Rules created"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Group not found"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/settlement-rules [post]
+func CreateSettlementRule(ctx fiber.Ctx) error {
+	input := new(CreateSettlementRuleInput)
+
+	if err := json.Unmarshal(ctx.Body(), input); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON: " + err.Error(),
+		})
+	}
+
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Group not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch group: " + err.Error(),
+		})
+	}
+
+	if group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only group admin can change settlement rules",
+		})
+	}
+
+	if input.Kind != models.RulePreferred && input.Kind != models.RuleForbidden {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Kind must be preferred or forbidden",
+		})
+	}
+	if input.PayerID == input.ReceiverID {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Payer and receiver must be different members",
+		})
+	}
+	if err := checkGroupMembers(group.ID, []uint{input.PayerID, input.ReceiverID}); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	pairs := []settle.Pair{{PayerID: input.PayerID, ReceiverID: input.ReceiverID}}
+	if input.BothDirections {
+		pairs = append(pairs, settle.Pair{PayerID: input.ReceiverID, ReceiverID: input.PayerID})
+	}
+
+	var rules []models.SettlementRule
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, pair := range pairs {
+			var rule models.SettlementRule
+			err := tx.Where("group_id = ? AND payer_id = ? AND receiver_id = ?", group.ID, pair.PayerID, pair.ReceiverID).First(&rule).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			rule.GroupID = group.ID
+			rule.PayerID = pair.PayerID
+			rule.ReceiverID = pair.ReceiverID
+			rule.Kind = input.Kind
+			rule.CreatedByID = userID
+			if err := tx.Save(&rule).Error; err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save settlement rule: " + err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(rules)
+}
+
+// @Summary Delete a settlement rule
+// @Description Remove a preferred or forbidden pair from a group. Only the group admin can delete rules.
+// @Tags settlements
+// @Produce json
+// @Param id path string true "Settlement rule ID"
+// @Success 200 {object} map[string]interface{} "Rule deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not the group admin"
+// @Failure 404 {object} map[string]interface{} "Settlement rule not found"
+// @Security ApiKeyAuth
+// @Router /settlement-rules/{id} [delete]
+func DeleteSettlementRule(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var rule models.SettlementRule
+	if err := database.DB.Preload("Group").First(&rule, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Settlement rule not found",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch settlement rule: " + err.Error(),
+		})
+	}
+
+	if rule.Group.AdminID != userID {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only group admin can change settlement rules",
+		})
+	}
+
+	if err := database.DB.Delete(&rule).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete settlement rule: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Settlement rule deleted successfully",
+	})
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+)
+
+// defaultTrashRetentionDays is how long deleted expenses can be restored unless TRASH_RETENTION_DAYS is set
+const defaultTrashRetentionDays = 30
+
+// purgeBatchSize limits how many expenses are purged in one transaction
+const purgeBatchSize = 100
+
+// TrashedExpense is an expense in the trash with the time it will be purged
+type TrashedExpense struct {
+	models.Expense
+	RestorableUntil time.Time `json:"restorable_until"`
+}
+
+// trashRetention returns how long deleted expenses stay in the trash
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// purgeExpenses permanently deletes expenses, trashed or not, with everything that belongs to them.
+// It returns the deleted attachments, whose files should be removed after the transaction commits.
+func purgeExpenses(tx *gorm.DB, expenseIDs []uint) ([]models.Attachment, error) {
+	if len(expenseIDs) == 0 {
+		return nil, nil
+	}
+
+	if err := deleteExpenseChildren(tx, expenseIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.Comment{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("expense_id IN ?", expenseIDs).Delete(&models.ExpenseRevision{}).Error; err != nil {
+		return nil, err
+	}
+	attachments, err := deleteAttachmentRecords(tx, "expense_id IN ?", expenseIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Unscoped().Where("id IN ?", expenseIDs).Delete(&models.Expense{}).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// notifyExpenseTrashed tells the other group members about a deleted expense and offers an undo
+func notifyExpenseTrashed(expense models.Expense, deletedBy uint) {
+	var deleter models.User
+	database.DB.Select("username").First(&deleter, deletedBy)
+
+	var memberIDs []uint
+	if err := database.DB.Model(&models.GroupMember{}).
+		Where("group_id = ? AND is_active = ? AND user_id <> ?", expense.GroupID, true, deletedBy).
+		Pluck("user_id", &memberIDs).Error; err != nil {
+		println("Failed to send notification: " + err.Error())
+		return
+	}
+
+	for _, memberID := range memberIDs {
+		if err := database.DB.Create(&models.Notification{
+			Message:    deleter.Username + " deleted \"" + expense.Description + "\"",
+			UserID:     memberID,
+			New:        true,
+			Action:     "undo",
+			ActionPath: fmt.Sprintf("/expenses/%d/restore", expense.ID),
+		}).Error; err != nil {
+			println("Failed to send notification: " + err.Error())
+		}
+	}
+}
+
+// @Summary Get the trash of a group
+// @Description Get the deleted expenses of a group that can still be restored, most recently deleted first
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {array} TrashedExpense "Deleted expenses"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Security ApiKeyAuth
+// @Router /groups/{id}/trash [get]
+func GetTrash(ctx fiber.Ctx) error {
+	userID, err := getUserIDFromJWT(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Failed to extract user ID from token",
+		})
+	}
+
+	var groupMember models.GroupMember
+	if err := database.DB.Where("group_id = ? AND user_id = ? AND is_active = ?", ctx.Params("id"), userID, true).First(&groupMember).Error; err != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	}
+
+	retention := trashRetention()
+	var expenses []models.Expense
+	if err := database.DB.Unscoped().
+		Where("group_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", groupMember.GroupID, time.Now().Add(-retention)).
+		Preload("PaidBy").
+		Preload("DeletedBy").
+		Preload("Category").
+		Preload("Payers.User").
+		Preload("ExpenseShares.User").
+		Order("deleted_at DESC").
+		Find(&expenses).Error; err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch trash: " + err.Error(),
+		})
+	}
+
+	trash := make([]TrashedExpense, len(expenses))
+	for i, expense := range expenses {
+		trash[i] = TrashedExpense{Expense: expense, RestorableUntil: expense.DeletedAt.Time.Add(retention)}
+	}
+
+	return ctx.JSON(trash)
+}
+
+// @Summary Restore a deleted expense
+// @Description Take an expense out of the trash. Any group member can restore it until it is purged.
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {object} map[string]interface{} "Expense restored"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Not a group member"
+// @Failure 404 {object} map[string]interface{} "Expense not in the trash"
+// @Failure 410 {object} map[string]interface{} "Expense can no longer be restored"
+// @Security ApiKeyAuth
+// @Router /expenses/{id}/restore [post]
+func RestoreExpense(ctx fiber.Ctx) error {
+	var expense models.Expense
+	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&expense, ctx.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Expense not found in trash",
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch expense: " + err.Error(),
+		})
+	}
+
+	userID, status, err := memberGroupAccess(ctx, expense.GroupID)
+	if err != nil {
+		return ctx.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if time.Since(expense.DeletedAt.Time) > trashRetention() {
+		return ctx.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "This expense has been in the trash too long to be restored",
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&expense).Updates(map[string]interface{}{"deleted_at": nil, "deleted_by_id": nil}).Error; err != nil {
+			return err
+		}
+		return addSystemComments(tx, expense.ID, userID, []string{"restored from trash"})
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore expense: " + err.Error(),
+		})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message":    "Expense restored successfully",
+		"expense_id": expense.ID,
+	})
+}
+
+// PurgeTrash permanently deletes expenses that have been in the trash longer than the retention period
+func PurgeTrash(now time.Time) error {
+	cutoff := now.Add(-trashRetention())
+
+	for {
+		var expenseIDs []uint
+		if err := database.DB.Unscoped().Model(&models.Expense{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Order("id").
+			Limit(purgeBatchSize).
+			Pluck("id", &expenseIDs).Error; err != nil {
+			return err
+		}
+		if len(expenseIDs) == 0 {
+			return nil
+		}
+
+		var attachments []models.Attachment
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			attachments, err = purgeExpenses(tx, expenseIDs)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		removeAttachmentFiles(attachments)
+	}
+}
@@ -57,19 +57,45 @@ func Connect() *gorm.DB {
 	}
 
 connected:
+	if migrateErr := migrateMoneyColumns(db); migrateErr != nil {
+		log.Fatalf("Money column migration failed: %v", migrateErr)
+	}
+
 	if migrateErr := db.AutoMigrate(
 		&models.User{},
 		&models.Group{},
 		&models.Expense{},
 		&models.GroupMember{},
 		&models.ExpenseShare{},
+		&models.ExpensePayer{},
+		&models.ExpenseItem{},
+		&models.ExpenseItemAssignee{},
 		&models.Settlement{},
 		&models.RefreshToken{},
 		&models.Notification{},
+		&models.ExchangeRate{},
+		&models.RecurringExpense{},
+		&models.RecurringExpenseParticipant{},
+		&models.Category{},
+		&models.Attachment{},
+		&models.Comment{},
+		&models.ExpenseRevision{},
+		&models.Payment{},
+		&models.SettlementRound{},
+		&models.SettlementRule{},
+		&models.SettlementPayment{},
 	); migrateErr != nil {
 		log.Fatalf("AutoMigrate failed: %v", migrateErr)
 	}
 
+	if migrateErr := createIndexes(db); migrateErr != nil {
+		log.Fatalf("Index creation failed: %v", migrateErr)
+	}
+
+	if migrateErr := migrateData(db); migrateErr != nil {
+		log.Fatalf("Data migration failed: %v", migrateErr)
+	}
+
 	DB = db
 	return DB
 }
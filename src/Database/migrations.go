@@ -0,0 +1,193 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/category"
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
+
+// moneyColumn describes a legacy float column holding major units, and where its currency comes from
+type moneyColumn struct {
+	Table    string
+	Column   string
+	Currency string // table.column holding the row currency
+	Join     string // optional FROM clause needed by Currency
+	Where    string // join condition for Join
+}
+
+var legacyMoneyColumns = []moneyColumn{
+	{Table: "expenses", Column: "amount", Currency: "expenses.currency"},
+	{
+		Table: "expenses", Column: "base_amount", Currency: "groups.currency",
+		Join: "groups", Where: "groups.id = expenses.group_id",
+	},
+	{
+		Table: "expense_shares", Column: "amount_owed", Currency: "expenses.currency",
+		Join: "expenses", Where: "expenses.id = expense_shares.expense_id",
+	},
+	{
+		Table: "expense_shares", Column: "base_amount_owed", Currency: "groups.currency",
+		Join: "expenses JOIN groups ON groups.id = expenses.group_id", Where: "expenses.id = expense_shares.expense_id",
+	},
+	{Table: "settlements", Column: "amount", Currency: "settlements.currency"},
+}
+
+// minorUnitFactorSQL builds a CASE expression giving 10^decimals for a currency expression
+func minorUnitFactorSQL(currencyExpr string) string {
+	var cases []string
+	byDecimals := money.KnownDecimalCurrencies()
+	for _, decimals := range []int{0, 3} {
+		codes := byDecimals[decimals]
+		sort.Strings(codes)
+		quoted := make([]string, len(codes))
+		for i, code := range codes {
+			quoted[i] = "'" + code + "'"
+		}
+		factor := 1
+		for i := 0; i < decimals; i++ {
+			factor *= 10
+		}
+		cases = append(cases, fmt.Sprintf("WHEN %s IN (%s) THEN %d", currencyExpr, strings.Join(quoted, ", "), factor))
+	}
+	return "CASE " + strings.Join(cases, " ") + " ELSE 100 END"
+}
+
+// defaultMinorUnitFactor is the factor of DKK, the currency of every amount stored before
+// currencies were added
+const defaultMinorUnitFactor = "100"
+
+// minorUnitFactor gives the SQL factor for a currency column given as table.column. Databases from
+// before currencies don't have the column yet, since AutoMigrate adds it later, so their amounts
+// use the default factor.
+func minorUnitFactor(tx *gorm.DB, currencyColumn string) (string, error) {
+	table, column, _ := strings.Cut(currencyColumn, ".")
+	var count int64
+	if err := tx.Raw(
+		"SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?",
+		table, column,
+	).Scan(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return defaultMinorUnitFactor, nil
+	}
+	return minorUnitFactorSQL(currencyColumn), nil
+}
+
+// migrateMoneyColumns converts float amounts in major units to integer minor units.
+// It must run before AutoMigrate, which would otherwise cast the floats without scaling them.
+func migrateMoneyColumns(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, col := range legacyMoneyColumns {
+			var dataType string
+			if err := tx.Raw(
+				"SELECT data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?",
+				col.Table, col.Column,
+			).Scan(&dataType).Error; err != nil {
+				return err
+			}
+			if dataType != "double precision" && dataType != "real" && dataType != "numeric" {
+				continue
+			}
+
+			factor, err := minorUnitFactor(tx, col.Currency)
+			if err != nil {
+				return err
+			}
+
+			minorColumn := col.Column + "_minor"
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s bigint NOT NULL DEFAULT 0", col.Table, minorColumn)).Error; err != nil {
+				return err
+			}
+
+			update := fmt.Sprintf("UPDATE %s SET %s = ROUND(%s.%s::numeric * %s)",
+				col.Table, minorColumn, col.Table, col.Column, factor)
+			if col.Join != "" && factor != defaultMinorUnitFactor {
+				update += fmt.Sprintf(" FROM %s WHERE %s", col.Join, col.Where)
+			}
+			if err := tx.Exec(update).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", col.Table, col.Column)).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", col.Table, minorColumn, col.Column)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seedCategories adds built-in categories that don't exist yet. Existing rows are left alone,
+// so keywords edited in the database are kept.
+func seedCategories(tx *gorm.DB) error {
+	for _, builtin := range category.Builtins {
+		var count int64
+		if err := tx.Model(&models.Category{}).Where("group_id IS NULL AND name = ?", builtin.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := tx.Create(&models.Category{Name: builtin.Name, Keywords: strings.Join(builtin.Keywords, ",")}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIndexes adds indexes GORM tags can't express
+func createIndexes(db *gorm.DB) error {
+	// Full-text search on expense descriptions; the 'simple' configuration doesn't stem, so it
+	// works the same for Danish and English descriptions
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_expenses_description_search
+		ON expenses USING GIN (to_tsvector('simple', description))`).Error
+}
+
+// migrateData backfills columns that AutoMigrate adds to existing tables.
+// Every step must be safe to run on each startup.
+func migrateData(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Expenses created before multi-currency support are in the group currency at rate 1
+		if err := tx.Exec("UPDATE expenses SET base_amount = amount, exchange_rate = 1 WHERE base_amount = 0 AND amount <> 0 AND exchange_rate = 1").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE expense_shares SET base_amount_owed = expense_shares.amount_owed
+			FROM expenses WHERE expenses.id = expense_shares.expense_id AND expenses.exchange_rate = 1
+			AND expense_shares.base_amount_owed = 0 AND expense_shares.amount_owed <> 0`).Error; err != nil {
+			return err
+		}
+		// Expenses created before multi-payer support were paid in full by paid_by_id
+		if err := tx.Exec(`INSERT INTO expense_payers (expense_id, user_id, amount, base_amount)
+			SELECT expenses.id, expenses.paid_by_id, expenses.amount, expenses.base_amount FROM expenses
+			WHERE NOT EXISTS (SELECT 1 FROM expense_payers WHERE expense_payers.expense_id = expenses.id)`).Error; err != nil {
+			return err
+		}
+		// Shares created before split modes were client-supplied amounts, i.e. an exact split
+		if err := tx.Exec(`UPDATE expense_shares SET split_value = expense_shares.amount_owed
+			FROM expenses WHERE expenses.id = expense_shares.expense_id AND expenses.split_mode = 'exact'
+			AND expense_shares.split_value = 0 AND expense_shares.amount_owed <> 0`).Error; err != nil {
+			return err
+		}
+		// Expenses from before occurred_at happened on the day they were entered
+		if err := tx.Exec("UPDATE expenses SET occurred_at = created_at::date WHERE occurred_at IS NULL").Error; err != nil {
+			return err
+		}
+		// Settlements confirmed before instalments were paid in full at once
+		if err := tx.Exec("UPDATE settlements SET amount_paid = amount WHERE is_confirmed AND amount_paid = 0").Error; err != nil {
+			return err
+		}
+		// Settlements confirmed before receivers confirmed them were confirmed when paid
+		if err := tx.Exec("UPDATE settlements SET status = 'confirmed', confirmed_at = paid_at WHERE is_confirmed AND status = 'pending'").Error; err != nil {
+			return err
+		}
+		return seedCategories(tx)
+	})
+}
@@ -0,0 +1,23 @@
+package database
+
+import "testing"
+
+func TestMinorUnitFactorSQL(t *testing.T) {
+	got := minorUnitFactorSQL("expenses.currency")
+	want := "CASE WHEN expenses.currency IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1" +
+		" WHEN expenses.currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000" +
+		" ELSE 100 END"
+	if got != want {
+		t.Errorf("minorUnitFactorSQL() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMinorUnitFactorSQLIsStable(t *testing.T) {
+	// The codes come from maps, so the order must not depend on map iteration
+	first := minorUnitFactorSQL("groups.currency")
+	for i := 0; i < 20; i++ {
+		if got := minorUnitFactorSQL("groups.currency"); got != first {
+			t.Fatalf("minorUnitFactorSQL() changed between calls:\n%s\n%s", first, got)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Attachment is an uploaded file on an expense (a receipt) or a settlement (proof of payment).
+// Exactly one of ExpenseID and SettlementID is set. The file itself lives in storage under StorageKey.
+type Attachment struct {
+	ID           uint      `gorm:"primaryKey"`
+	ExpenseID    *uint     `gorm:"index"`
+	SettlementID *uint     `gorm:"index"`
+	UploadedByID uint      `gorm:"not null"`
+	FileName     string    `gorm:"not null"`
+	ContentType  string    `gorm:"size:100;not null"`
+	Size         int64     `gorm:"not null"`
+	StorageKey   string    `gorm:"not null" json:"-"`
+	ThumbnailKey string    `gorm:"not null;default:''" json:"-"`
+	HasThumbnail bool      `gorm:"not null;default:false"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+
+	UploadedBy User `gorm:"foreignKey:UploadedByID"`
+}
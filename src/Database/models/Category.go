@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Category groups expenses for filtering and reports. Built-in categories have no GroupID;
+// custom categories belong to one group. Keywords is a comma separated list used for suggestions.
+type Category struct {
+	ID        uint      `gorm:"primaryKey"`
+	GroupID   *uint     `gorm:"index"`
+	Name      string    `gorm:"size:50;not null"`
+	Keywords  string    `gorm:"not null;default:''"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	Group *Group `gorm:"foreignKey:GroupID" json:"-"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Comment is a message in the discussion thread of an expense. System comments are written by the
+// server to record edits; AuthorID is then the user who made the edit.
+type Comment struct {
+	ID        uint       `gorm:"primaryKey"`
+	ExpenseID uint       `gorm:"not null;index"`
+	AuthorID  uint       `gorm:"not null"`
+	Body      string     `gorm:"type:text;not null"`
+	IsSystem  bool       `gorm:"not null;default:false"`
+	EditedAt  *time.Time `gorm:"default:null"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
+
+	Expense Expense `gorm:"foreignKey:ExpenseID" json:"-"`
+	Author  User    `gorm:"foreignKey:AuthorID"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ExchangeRate is a manually entered conversion rate for a group.
+// One unit of FromCurrency equals Rate units of ToCurrency.
+type ExchangeRate struct {
+	ID           uint      `gorm:"primaryKey"`
+	GroupID      uint      `gorm:"not null;index"`
+	FromCurrency string    `gorm:"size:3;not null"`
+	ToCurrency   string    `gorm:"size:3;not null"`
+	Rate         float64   `gorm:"not null"`
+	CreatedByID  uint      `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+
+	Group     Group `gorm:"foreignKey:GroupID" json:"-"`
+	CreatedBy User  `gorm:"foreignKey:CreatedByID"`
+}
@@ -1,49 +1,179 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+	"gorm.io/gorm"
+)
 
 type Expense struct {
-	ID          uint      `gorm:"primaryKey"`
-	Amount      float64   `gorm:"not null"`
-	Description string    `gorm:"not null"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	ID          uint         `gorm:"primaryKey"`
+	Amount      money.Amount `gorm:"not null"`
+	Currency    string       `gorm:"size:3;not null;default:'DKK'"`
+	Description string       `gorm:"not null"`
+	CreatedAt   time.Time    `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time    `gorm:"autoUpdateTime"`
+
+	// Type is "expense" when the payers paid for the group, or "income" for a refund, returned
+	// deposit or prize money the payers received on the group's behalf and owe to the participants
+	Type string `gorm:"size:20;not null;default:'expense'"`
+
+	// OccurredAt is the day the expense happened in the payer's timezone, which may be before it
+	// was entered. Listings and reports go by this date.
+	OccurredAt time.Time `gorm:"type:date;index"`
 
 	GroupID  uint `gorm:"not null"`
 	PaidByID uint `gorm:"not null"`
 
+	// ExchangeRate converts Amount into the group currency; BaseAmount is the converted total
+	ExchangeRate float64      `gorm:"not null;default:1"`
+	BaseAmount   money.Amount `gorm:"not null;default:0"`
+
+	// SplitMode is how the amount was divided (equal, exact, percentage, shares or adjustment),
+	// so edits can re-split the same way
+	SplitMode string `gorm:"size:20;not null;default:'exact'"`
+
+	// Charges on top of the items of an itemized expense, spread according to ChargeSplit
+	Tax           money.Amount `gorm:"not null;default:0"`
+	Tip           money.Amount `gorm:"not null;default:0"`
+	ServiceCharge money.Amount `gorm:"not null;default:0"`
+	ChargeSplit   string       `gorm:"size:20;not null;default:'proportional'"`
+
 	Settled bool `gorm:"default:false"`
 
+	// SettlementRoundID is the round that settled the expense
+	SettlementRoundID *uint `gorm:"index"`
+
+	// ApprovalStatus is pending while an expense in a group that requires approval waits for the admin.
+	// Only approved expenses count towards balances.
+	ApprovalStatus string     `gorm:"size:20;not null;default:'approved';index"`
+	ReviewedByID   *uint      `gorm:"default:null"`
+	ReviewedAt     *time.Time `gorm:"default:null"`
+	ReviewComment  string     `gorm:"not null;default:''"`
+
+	// Deleted expenses stay in the group trash until they are restored or purged
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID *uint
+
+	// CategoryID is chosen by the user or suggested from the description when left out
+	CategoryID *uint `gorm:"index"`
+
+	// RecurringExpenseID and OccurrenceDate are set on expenses created from a recurring template.
+	// The unique index makes creating the same occurrence twice impossible.
+	RecurringExpenseID *uint      `gorm:"uniqueIndex:idx_expense_occurrence"`
+	OccurrenceDate     *time.Time `gorm:"uniqueIndex:idx_expense_occurrence"`
+
 	Group         Group          `gorm:"foreignKey:GroupID" json:"-"`
 	PaidBy        User           `gorm:"foreignKey:PaidByID"`
+	DeletedBy     *User          `gorm:"foreignKey:DeletedByID"`
+	ReviewedBy    *User          `gorm:"foreignKey:ReviewedByID"`
+	Category      *Category      `gorm:"foreignKey:CategoryID"`
+	Payers        []ExpensePayer `gorm:"foreignKey:ExpenseID"`
 	ExpenseShares []ExpenseShare `gorm:"foreignKey:ExpenseID"`
+	Items         []ExpenseItem  `gorm:"foreignKey:ExpenseID"`
+	Attachments   []Attachment   `gorm:"foreignKey:ExpenseID"`
 
-	Status float64 `gorm:"-"`
+	Status       money.Amount `gorm:"-"`
+	CommentCount int64        `gorm:"-"`
 }
 
+// Entry types of an expense
+const (
+	EntryExpense = "expense"
+	EntryIncome  = "income"
+)
+
+// Approval states of an expense
+const (
+	ExpensePendingApproval = "pending"
+	ExpenseApproved        = "approved"
+	ExpenseRejected        = "rejected"
+)
+
+// ExpensePayer is one contribution towards paying an expense. The contributions of all payers
+// add up to the expense amount; PaidByID on the expense is the primary payer.
+type ExpensePayer struct {
+	ID         uint         `gorm:"primaryKey"`
+	ExpenseID  uint         `gorm:"not null;index"`
+	UserID     uint         `gorm:"not null"`
+	Amount     money.Amount `gorm:"not null"`
+	BaseAmount money.Amount `gorm:"not null;default:0"`
+
+	Expense Expense `gorm:"foreignKey:ExpenseID" json:"-"`
+	User    User    `gorm:"foreignKey:UserID"`
+}
+
+// Acknowledgement states of an expense share
+const (
+	SharePending  = "pending"
+	ShareAccepted = "accepted"
+	ShareDisputed = "disputed"
+)
+
 type ExpenseShare struct {
-	ID         uint    `gorm:"primaryKey"`
-	ExpenseID  uint    `gorm:"not null"`
-	UserID     uint    `gorm:"not null"`
-	AmountOwed float64 `gorm:"not null"`
-	IsPaid     bool    `gorm:"default:false"`
+	ID         uint         `gorm:"primaryKey"`
+	ExpenseID  uint         `gorm:"not null"`
+	UserID     uint         `gorm:"not null"`
+	AmountOwed money.Amount `gorm:"not null"`
+	IsPaid     bool         `gorm:"default:false"`
+
+	// BaseAmountOwed is AmountOwed converted into the group currency
+	BaseAmountOwed money.Amount `gorm:"not null;default:0"`
+
+	// SplitValue is the participant's input to the split mode (amount, basis points, shares or adjustment)
+	SplitValue int64 `gorm:"not null;default:0"`
+
+	// Acknowledgement is the participant's answer to their share: pending, accepted or disputed.
+	// Shares from before acknowledgements existed count as accepted.
+	Acknowledgement string     `gorm:"size:20;not null;default:'accepted'"`
+	DisputeReason   string     `gorm:"not null;default:''"`
+	AcknowledgedAt  *time.Time `gorm:"default:null"`
 
 	Expense Expense `gorm:"foreignKey:ExpenseID" json:"-"`
 	User    User    `gorm:"foreignKey:UserID"`
 }
 
+// Status of a settlement
+const (
+	SettlementPending    = "pending"
+	SettlementMarkedPaid = "marked_paid"
+	SettlementConfirmed  = "confirmed"
+	SettlementDisputed   = "disputed"
+)
+
 type Settlement struct {
-	ID          uint       `gorm:"primaryKey"`
-	Amount      float64    `gorm:"not null"`
-	CreatedAt   time.Time  `gorm:"autoCreateTime"`
-	IsConfirmed bool       `gorm:"default:false"`
-	PaidAt      *time.Time `gorm:"default:null"`
+	ID          uint         `gorm:"primaryKey"`
+	Amount      money.Amount `gorm:"not null"`
+	Currency    string       `gorm:"size:3;not null;default:'DKK'"`
+	CreatedAt   time.Time    `gorm:"autoCreateTime"`
+	IsConfirmed bool         `gorm:"default:false"`
+	PaidAt      *time.Time   `gorm:"default:null"`
 
 	GroupID    uint `gorm:"not null"`
 	PayerID    uint `gorm:"not null"`
 	ReceiverID uint `gorm:"not null"`
 
+	// RoundID is the settlement round the settlement pays off; older settlements have none
+	RoundID *uint `gorm:"index"`
+
+	// AmountPaid is what the payer paid so far in instalments; the settlement is marked paid once it
+	// reaches Amount
+	AmountPaid money.Amount `gorm:"not null;default:0"`
+
+	// Status is pending until the payer has paid it all, which marks it paid (PaidAt). The receiver
+	// then confirms receiving it, which sets IsConfirmed, or disputes it, after which the payer can
+	// mark it paid again. RemindedAt is when the receiver was last reminded to confirm.
+	Status        string     `gorm:"size:20;not null;default:'pending'"`
+	ConfirmedAt   *time.Time `gorm:"default:null"`
+	DisputedAt    *time.Time `gorm:"default:null"`
+	DisputeReason string     `gorm:"not null;default:''"`
+	RemindedAt    *time.Time `gorm:"default:null"`
+
 	Group    Group `gorm:"foreignKey:GroupID" json:"-"`
 	Payer    User  `gorm:"foreignKey:PayerID"`
 	Receiver User  `gorm:"foreignKey:ReceiverID"`
+
+	Attachments []Attachment        `gorm:"foreignKey:SettlementID"`
+	Payments    []SettlementPayment `gorm:"foreignKey:SettlementID"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "github.com/tjens23/tabsplit-backend/src/money"
+
+// ExpenseItem is a line on an itemized bill, shared evenly by its assignees
+type ExpenseItem struct {
+	ID          uint         `gorm:"primaryKey"`
+	ExpenseID   uint         `gorm:"not null;index"`
+	Description string       `gorm:"not null"`
+	Amount      money.Amount `gorm:"not null"`
+
+	Expense   Expense               `gorm:"foreignKey:ExpenseID" json:"-"`
+	Assignees []ExpenseItemAssignee `gorm:"foreignKey:ExpenseItemID"`
+}
+
+// ExpenseItemAssignee records who had an item and their part of its price, before charges
+type ExpenseItemAssignee struct {
+	ID            uint         `gorm:"primaryKey"`
+	ExpenseItemID uint         `gorm:"not null;index"`
+	UserID        uint         `gorm:"not null"`
+	Amount        money.Amount `gorm:"not null"`
+
+	ExpenseItem ExpenseItem `gorm:"foreignKey:ExpenseItemID" json:"-"`
+	User        User        `gorm:"foreignKey:UserID"`
+}
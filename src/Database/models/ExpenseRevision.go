@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// ExpenseRevision is a snapshot of an expense after it was created or edited.
+// Revisions are numbered from 1 per expense.
+type ExpenseRevision struct {
+	ID         uint            `gorm:"primaryKey"`
+	ExpenseID  uint            `gorm:"not null;uniqueIndex:idx_expense_revision"`
+	Number     int             `gorm:"not null;uniqueIndex:idx_expense_revision"`
+	EditedByID uint            `gorm:"not null"`
+	Snapshot   ExpenseSnapshot `gorm:"type:jsonb;not null"`
+	CreatedAt  time.Time       `gorm:"autoCreateTime"`
+
+	Expense  Expense `gorm:"foreignKey:ExpenseID" json:"-"`
+	EditedBy User    `gorm:"foreignKey:EditedByID"`
+}
+
+// ExpenseSnapshot holds everything needed to restore an expense to an earlier state
+type ExpenseSnapshot struct {
+	Amount        money.Amount    `json:"amount"`
+	Currency      string          `json:"currency"`
+	ExchangeRate  float64         `json:"exchange_rate"`
+	BaseAmount    money.Amount    `json:"base_amount"`
+	Description   string          `json:"description"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	PaidByID      uint            `json:"paid_by_id"`
+	CategoryID    *uint           `json:"category_id"`
+	SplitMode     string          `json:"split_mode"`
+	Tax           money.Amount    `json:"tax"`
+	Tip           money.Amount    `json:"tip"`
+	ServiceCharge money.Amount    `json:"service_charge"`
+	ChargeSplit   string          `json:"charge_split"`
+	Payers        []SnapshotPayer `json:"payers"`
+	Shares        []SnapshotShare `json:"shares"`
+	Items         []SnapshotItem  `json:"items,omitempty"`
+}
+
+type SnapshotPayer struct {
+	UserID     uint         `json:"user_id"`
+	Amount     money.Amount `json:"amount"`
+	BaseAmount money.Amount `json:"base_amount"`
+}
+
+type SnapshotShare struct {
+	UserID         uint         `json:"user_id"`
+	AmountOwed     money.Amount `json:"amount_owed"`
+	BaseAmountOwed money.Amount `json:"base_amount_owed"`
+	SplitValue     int64        `json:"split_value"`
+}
+
+type SnapshotItem struct {
+	Description string                 `json:"description"`
+	Amount      money.Amount           `json:"amount"`
+	Assignees   []SnapshotItemAssignee `json:"assignees"`
+}
+
+type SnapshotItemAssignee struct {
+	UserID uint         `json:"user_id"`
+	Amount money.Amount `json:"amount"`
+}
+
+// Value stores the snapshot as JSON
+func (s ExpenseSnapshot) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan reads a snapshot stored as JSON
+func (s *ExpenseSnapshot) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case []byte:
+		return json.Unmarshal(data, s)
+	case string:
+		return json.Unmarshal([]byte(data), s)
+	}
+	return errors.New("unsupported expense snapshot value")
+}
@@ -8,9 +8,19 @@ type Group struct {
 	ProfileImage string    `gorm:"not null"`
 	Description  string    `gorm:"not null"`
 	AdminID      uint      `gorm:"not null"`
+	Currency     string    `gorm:"size:3;not null;default:'DKK'"`
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
 	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
 
+	// ExcludeDisputedExpenses leaves expenses with a disputed share out of settlements until they are resolved
+	ExcludeDisputedExpenses bool `gorm:"not null;default:false"`
+
+	// RequireApproval makes new and edited expenses of members wait for the admin's approval
+	RequireApproval bool `gorm:"not null;default:false"`
+
+	// SettlementStrategy is how settlements simplify the group's debts: greedy, exact or none
+	SettlementStrategy string `gorm:"size:20;not null;default:'greedy'"`
+
 	GroupAdmin User `gorm:"foreignKey:AdminID"`
 
 	Members []GroupMember `gorm:"foreignKey:GroupID"`
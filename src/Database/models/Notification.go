@@ -8,4 +8,9 @@ type Notification struct {
 	UserID    uint      `gorm:"not null"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 	New       bool      `gorm:"not null"`
+
+	// Action names a button clients can show with the notification, such as "undo";
+	// pressing it sends a POST to ActionPath
+	Action     string `gorm:"size:50;not null;default:''"`
+	ActionPath string `gorm:"not null;default:''"`
 }
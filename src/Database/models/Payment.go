@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// Status of a direct payment
+const (
+	PaymentPending   = "pending"
+	PaymentConfirmed = "confirmed"
+	PaymentRejected  = "rejected"
+)
+
+// Payment is money one member paid another directly, outside a settlement. It counts towards
+// balances as soon as it is recorded, unless the receiver rejects it. Amount is in the group currency.
+type Payment struct {
+	ID          uint         `gorm:"primaryKey"`
+	GroupID     uint         `gorm:"not null;index"`
+	PayerID     uint         `gorm:"not null"`
+	ReceiverID  uint         `gorm:"not null"`
+	Amount      money.Amount `gorm:"not null"`
+	Currency    string       `gorm:"size:3;not null;default:'DKK'"`
+	Note        string       `gorm:"not null;default:''"`
+	Status      string       `gorm:"size:20;not null;default:'pending'"`
+	RespondedAt *time.Time   `gorm:"default:null"`
+	CreatedAt   time.Time    `gorm:"autoCreateTime"`
+
+	// Settled is set once the payment is covered by settlements, either when they are created or
+	// when the payment was folded into the group's open settlements
+	Settled bool `gorm:"not null;default:false"`
+
+	// SettlementRoundID is the round that covers the payment
+	SettlementRoundID *uint `gorm:"index"`
+
+	Group    Group `gorm:"foreignKey:GroupID" json:"-"`
+	Payer    User  `gorm:"foreignKey:PayerID"`
+	Receiver User  `gorm:"foreignKey:ReceiverID"`
+}
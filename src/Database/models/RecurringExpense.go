@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// RecurringExpense is a template that the scheduler turns into a real Expense every time it comes due.
+// Editing a template only affects occurrences that haven't been created yet.
+type RecurringExpense struct {
+	ID          uint         `gorm:"primaryKey"`
+	GroupID     uint         `gorm:"not null;index"`
+	CreatedByID uint         `gorm:"not null"`
+	PaidByID    uint         `gorm:"not null"`
+	Description string       `gorm:"not null"`
+	Amount      money.Amount `gorm:"not null"`
+	Currency    string       `gorm:"size:3;not null"`
+	SplitMode   string       `gorm:"size:20;not null"`
+
+	Frequency  string     `gorm:"size:20;not null"`
+	Interval   int        `gorm:"not null;default:1"`
+	AnchorDate time.Time  `gorm:"not null"`
+	EndDate    *time.Time `gorm:"default:null"`
+
+	// NextOccurrence is the date of occurrence number NextIndex, counted from AnchorDate
+	NextOccurrence time.Time `gorm:"not null;index"`
+	NextIndex      int       `gorm:"not null;default:0"`
+	IsPaused       bool      `gorm:"default:false"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+
+	Group        Group                         `gorm:"foreignKey:GroupID" json:"-"`
+	CreatedBy    User                          `gorm:"foreignKey:CreatedByID"`
+	PaidBy       User                          `gorm:"foreignKey:PaidByID"`
+	Participants []RecurringExpenseParticipant `gorm:"foreignKey:RecurringExpenseID"`
+}
+
+// RecurringExpenseParticipant is a participant of the template's split, with its split mode value
+type RecurringExpenseParticipant struct {
+	ID                 uint  `gorm:"primaryKey"`
+	RecurringExpenseID uint  `gorm:"not null;index"`
+	UserID             uint  `gorm:"not null"`
+	Value              int64 `gorm:"not null;default:0"`
+
+	RecurringExpense RecurringExpense `gorm:"foreignKey:RecurringExpenseID" json:"-"`
+	User             User             `gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// SettlementPayment is an instalment the payer of a settlement paid towards it. The settlement is
+// confirmed once its instalments add up to its amount.
+type SettlementPayment struct {
+	ID           uint         `gorm:"primaryKey"`
+	SettlementID uint         `gorm:"not null;index"`
+	Amount       money.Amount `gorm:"not null"`
+	Currency     string       `gorm:"size:3;not null;default:'DKK'"`
+	Note         string       `gorm:"not null;default:''"`
+	CreatedAt    time.Time    `gorm:"autoCreateTime"`
+
+	Settlement Settlement `gorm:"foreignKey:SettlementID" json:"-"`
+}
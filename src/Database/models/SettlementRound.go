@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Status of a settlement round
+const (
+	RoundOpen          = "open"
+	RoundPartiallyPaid = "partially_paid"
+	RoundCompleted     = "completed"
+	RoundCancelled     = "cancelled"
+)
+
+// SettlementRound is one settling of a group's balances: the expenses and direct payments it covers
+// and the settlements that pay them off. It is open until anything is paid towards a settlement,
+// partially paid from then on, and completed once the receivers confirmed all of them. Until a
+// settlement is confirmed, an admin can cancel the round, which deletes its settlements and puts
+// what it covered back into the balances, so a cancelled round covers nothing. What was already
+// paid towards its settlements is kept as pending direct payments.
+type SettlementRound struct {
+	ID            uint       `gorm:"primaryKey"`
+	GroupID       uint       `gorm:"not null;index"`
+	CreatedByID   uint       `gorm:"not null"`
+	Status        string     `gorm:"size:20;not null;default:'open'"`
+	Currency      string     `gorm:"size:3;not null;default:'DKK'"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`
+	CompletedAt   *time.Time `gorm:"default:null"`
+	CancelledAt   *time.Time `gorm:"default:null"`
+	CancelledByID *uint      `gorm:"default:null"`
+
+	// Strategy is how the round's settlements simplified the debts; rebalancing them keeps using it
+	Strategy string `gorm:"size:20;not null;default:'greedy'"`
+
+	// ExpenseIDs and PaymentIDs are what the round covers; they are filled in when a round is loaded
+	ExpenseIDs []uint `gorm:"-" json:"expense_ids"`
+	PaymentIDs []uint `gorm:"-" json:"payment_ids"`
+
+	Group       Group        `gorm:"foreignKey:GroupID" json:"-"`
+	CreatedBy   User         `gorm:"foreignKey:CreatedByID"`
+	CancelledBy *User        `gorm:"foreignKey:CancelledByID"`
+	Settlements []Settlement `gorm:"foreignKey:RoundID"`
+}
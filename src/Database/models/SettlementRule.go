@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Kind of a settlement rule
+const (
+	RulePreferred = "preferred"
+	RuleForbidden = "forbidden"
+)
+
+// SettlementRule tells settlements of a group how a payer and receiver may settle: preferred pairs
+// settle with each other first, for example a couple settling internally, and forbidden pairs never
+// pay each other directly, for example members with banks in different countries. Rules are
+// one-way; a pair has at most one rule per direction.
+type SettlementRule struct {
+	ID          uint      `gorm:"primaryKey"`
+	GroupID     uint      `gorm:"not null;uniqueIndex:idx_settlement_rule_pair"`
+	PayerID     uint      `gorm:"not null;uniqueIndex:idx_settlement_rule_pair"`
+	ReceiverID  uint      `gorm:"not null;uniqueIndex:idx_settlement_rule_pair"`
+	Kind        string    `gorm:"size:20;not null"`
+	CreatedByID uint      `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+
+	Group     Group `gorm:"foreignKey:GroupID" json:"-"`
+	Payer     User  `gorm:"foreignKey:PayerID"`
+	Receiver  User  `gorm:"foreignKey:ReceiverID"`
+	CreatedBy User  `gorm:"foreignKey:CreatedByID" json:"-"`
+}
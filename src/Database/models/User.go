@@ -11,6 +11,9 @@ type User struct {
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 
+	// Placeholders stand in for people from an imported ledger who don't have an account. They can't log in.
+	IsPlaceholder bool `gorm:"not null;default:false"`
+
 	AdminGroups []Group `gorm:"foreignKey:AdminID"`
 
 	GroupMemberships []GroupMember `gorm:"foreignKey:UserID" json:"-"`
@@ -30,18 +30,82 @@ func SetupRoutes(app *fiber.App) {
 	app.Post("/groups/:id/add-member", middleware.IsAuth, controllers.AddMemberToGroup)
 	app.Post("/groups/:id/remove-member", middleware.IsAuth, controllers.RemoveMemberFromGroup)
 
+	// Exchange rate routes
+	app.Get("/groups/:id/exchange-rates", middleware.IsAuth, controllers.GetExchangeRates)
+	app.Post("/groups/:id/exchange-rates", middleware.IsAuth, controllers.CreateExchangeRate)
+	app.Delete("/groups/:id/exchange-rates/:rateId", middleware.IsAuth, controllers.DeleteExchangeRate)
+
+	// Category routes
+	app.Get("/groups/:id/categories", middleware.IsAuth, controllers.GetCategories)
+	app.Post("/groups/:id/categories", middleware.IsAuth, controllers.CreateCategory)
+	app.Get("/groups/:id/categories/suggest", middleware.IsAuth, controllers.SuggestCategory)
+	app.Get("/groups/:id/categories/report", middleware.IsAuth, controllers.GetCategoryReport)
+	app.Delete("/groups/:id/categories/:categoryId", middleware.IsAuth, controllers.DeleteCategory)
+
 	// Expense routes
 	app.Post("/expenses", middleware.IsAuth, controllers.CreateExpense)
 	app.Get("/expenses", middleware.IsAuth, controllers.GetExpenses)
+	app.Get("/expenses/mine", middleware.IsAuth, controllers.GetMyExpenses)
 	app.Get("/expenses/:id", middleware.IsAuth, controllers.GetExpense)
 	app.Patch("/expenses/update/:id", middleware.IsAuth, controllers.UpdateExpense)
 	app.Delete("/expenses/delete/:id", middleware.IsAuth, controllers.DeleteExpense)
+	app.Post("/expenses/:id/restore", middleware.IsAuth, controllers.RestoreExpense)
+	app.Post("/expenses/:id/accept", middleware.IsAuth, controllers.AcceptExpenseShare)
+	app.Post("/expenses/:id/dispute", middleware.IsAuth, controllers.DisputeExpenseShare)
+	app.Post("/expenses/:id/approve", middleware.IsAuth, controllers.ApproveExpense)
+	app.Post("/expenses/:id/reject", middleware.IsAuth, controllers.RejectExpense)
+	app.Get("/groups/:id/approvals", middleware.IsAuth, controllers.GetApprovalQueue)
+	app.Post("/groups/:id/approvals/approve", middleware.IsAuth, controllers.BulkApproveExpenses)
+	app.Get("/groups/:id/trash", middleware.IsAuth, controllers.GetTrash)
+	app.Post("/groups/:id/import", middleware.IsAuth, controllers.ImportGroupLedger)
+	app.Get("/groups/:id/export", middleware.IsAuth, controllers.ExportGroupLedger)
+	app.Get("/expenses/:id/revisions", middleware.IsAuth, controllers.GetExpenseRevisions)
+	app.Post("/expenses/:id/revisions/:number/revert", middleware.IsAuth, controllers.RevertExpense)
+
+	// Comment routes
+	app.Get("/expenses/:id/comments", middleware.IsAuth, controllers.GetComments)
+	app.Post("/expenses/:id/comments", middleware.IsAuth, controllers.CreateComment)
+	app.Patch("/comments/:id", middleware.IsAuth, controllers.UpdateComment)
+	app.Delete("/comments/:id", middleware.IsAuth, controllers.DeleteComment)
+
+	// Attachment routes
+	app.Post("/expenses/:id/attachments", middleware.IsAuth, controllers.UploadExpenseAttachment)
+	app.Get("/expenses/:id/attachments", middleware.IsAuth, controllers.GetExpenseAttachments)
+	app.Post("/settlements/:id/attachments", middleware.IsAuth, controllers.UploadSettlementAttachment)
+	app.Get("/settlements/:id/attachments", middleware.IsAuth, controllers.GetSettlementAttachments)
+	app.Get("/attachments/:id", middleware.IsAuth, controllers.DownloadAttachment)
+	app.Get("/attachments/:id/thumbnail", middleware.IsAuth, controllers.DownloadAttachmentThumbnail)
+	app.Delete("/attachments/:id", middleware.IsAuth, controllers.DeleteAttachment)
+
+	// Recurring expense routes
+	app.Post("/recurring-expenses", middleware.IsAuth, controllers.CreateRecurringExpense)
+	app.Get("/groups/:id/recurring-expenses", middleware.IsAuth, controllers.GetRecurringExpenses)
+	app.Patch("/recurring-expenses/:id", middleware.IsAuth, controllers.UpdateRecurringExpense)
+	app.Post("/recurring-expenses/:id/pause", middleware.IsAuth, controllers.PauseRecurringExpense)
+	app.Post("/recurring-expenses/:id/resume", middleware.IsAuth, controllers.ResumeRecurringExpense)
+	app.Delete("/recurring-expenses/:id", middleware.IsAuth, controllers.DeleteRecurringExpense)
 
 	// Settlement routes
 	app.Post("/settlements/calculate", middleware.IsAuth, controllers.CalculateSettlements)
 	app.Post("/settlements/create", middleware.IsAuth, controllers.CreateSettlements)
 	app.Get("/groups/:id/settlements", middleware.IsAuth, controllers.GetGroupSettlements)
+	app.Post("/settlements/:id/mark-paid", middleware.IsAuth, controllers.MarkSettlementPaid)
 	app.Post("/settlements/:id/confirm", middleware.IsAuth, controllers.ConfirmSettlement)
+	app.Post("/settlements/:id/dispute", middleware.IsAuth, controllers.DisputeSettlement)
+	app.Post("/settlements/:id/payments", middleware.IsAuth, controllers.PaySettlement)
+	app.Get("/settlements/:id/payments", middleware.IsAuth, controllers.GetSettlementPayments)
+	app.Get("/groups/:id/settlement-rounds", middleware.IsAuth, controllers.GetSettlementRounds)
+	app.Get("/settlement-rounds/:id", middleware.IsAuth, controllers.GetSettlementRound)
+	app.Post("/settlement-rounds/:id/cancel", middleware.IsAuth, controllers.CancelSettlementRound)
+	app.Get("/groups/:id/settlement-rules", middleware.IsAuth, controllers.GetSettlementRules)
+	app.Post("/groups/:id/settlement-rules", middleware.IsAuth, controllers.CreateSettlementRule)
+	app.Delete("/settlement-rules/:id", middleware.IsAuth, controllers.DeleteSettlementRule)
+
+	// Payment routes
+	app.Post("/payments", middleware.IsAuth, controllers.CreatePayment)
+	app.Get("/groups/:id/payments", middleware.IsAuth, controllers.GetGroupPayments)
+	app.Post("/payments/:id/confirm", middleware.IsAuth, controllers.ConfirmPayment)
+	app.Post("/payments/:id/reject", middleware.IsAuth, controllers.RejectPayment)
 
 	app.Get("/notifications", middleware.IsAuth, controllers.GetNewNotifications)
 }
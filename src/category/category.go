@@ -0,0 +1,166 @@
+// Package category suggests expense categories from their descriptions.
+package category
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Builtin is a category available in every group
+type Builtin struct {
+	Name     string
+	Keywords []string
+}
+
+// Builtins are seeded into the database on startup; groups can add their own categories on top
+var Builtins = []Builtin{
+	{Name: "food", Keywords: []string{"restaurant", "dinner", "lunch", "breakfast", "pizza", "burger", "sushi", "cafe", "coffee", "takeaway", "brunch", "kebab"}},
+	{Name: "groceries", Keywords: []string{"groceries", "supermarket", "netto", "rema", "lidl", "aldi", "føtex", "bilka", "irma", "meny", "coop"}},
+	{Name: "drinks", Keywords: []string{"beer", "bar", "wine", "drinks", "cocktails", "pub"}},
+	{Name: "rent", Keywords: []string{"rent", "husleje", "deposit", "landlord"}},
+	{Name: "utilities", Keywords: []string{"electricity", "water", "heating", "gas bill", "internet", "wifi", "phone", "power", "el"}},
+	{Name: "transport", Keywords: []string{"taxi", "uber", "bus", "train", "metro", "fuel", "petrol", "gas station", "parking", "ticket", "dsb", "rejsekort", "bolt"}},
+	{Name: "travel", Keywords: []string{"flight", "hotel", "airbnb", "hostel", "ferry", "car rental", "trip"}},
+	{Name: "entertainment", Keywords: []string{"cinema", "movie", "concert", "netflix", "spotify", "tickets", "games", "bowling", "festival", "museum"}},
+	{Name: "shopping", Keywords: []string{"clothes", "ikea", "amazon", "gift", "shoes"}},
+	{Name: "household", Keywords: []string{"cleaning", "detergent", "toilet paper", "furniture", "repair"}},
+	{Name: "health", Keywords: []string{"pharmacy", "apotek", "doctor", "dentist", "gym", "fitness"}},
+	{Name: "other"},
+}
+
+// Candidate is a category that can be suggested, with its keywords
+type Candidate struct {
+	ID       uint
+	Keywords []string
+}
+
+// Past is an earlier expense in the group and the category it was given
+type Past struct {
+	Description string
+	CategoryID  uint
+}
+
+// Source tells where a suggestion came from
+type Source string
+
+const (
+	FromHistory  Source = "history"
+	FromKeywords Source = "keywords"
+)
+
+// historyThreshold is the similarity past expenses must reach before they win over keywords
+const historyThreshold = 0.5
+
+// Tokens splits a description into lower-case words
+func Tokens(description string) []string {
+	return strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ParseKeywords turns a comma separated keyword list into normalized keywords
+func ParseKeywords(keywords string) []string {
+	var parsed []string
+	for _, keyword := range strings.Split(keywords, ",") {
+		keyword = strings.Join(Tokens(keyword), " ")
+		if keyword != "" {
+			parsed = append(parsed, keyword)
+		}
+	}
+	return parsed
+}
+
+// Suggest picks a category for a description. The group's own history comes first: the category
+// most often given to similar descriptions wins. Otherwise the candidate with the most keyword
+// matches is used, earlier candidates winning ties. ok is false when nothing matches.
+func Suggest(description string, history []Past, candidates []Candidate) (id uint, source Source, ok bool) {
+	tokens := Tokens(description)
+	if len(tokens) == 0 {
+		return 0, "", false
+	}
+
+	if id, ok := matchHistory(tokens, history); ok {
+		return id, FromHistory, true
+	}
+	if id, ok := matchKeywords(tokens, candidates); ok {
+		return id, FromKeywords, true
+	}
+	return 0, "", false
+}
+
+// matchHistory scores categories by the similarity of their past descriptions to the tokens
+func matchHistory(tokens []string, history []Past) (uint, bool) {
+	scores := make(map[uint]float64)
+	var order []uint
+	for _, past := range history {
+		if past.CategoryID == 0 {
+			continue
+		}
+		similarity := jaccard(tokens, Tokens(past.Description))
+		if similarity == 0 {
+			continue
+		}
+		if _, seen := scores[past.CategoryID]; !seen {
+			order = append(order, past.CategoryID)
+		}
+		scores[past.CategoryID] += similarity
+	}
+
+	var best uint
+	var bestScore float64
+	for _, id := range order {
+		if scores[id] > bestScore {
+			best, bestScore = id, scores[id]
+		}
+	}
+	return best, bestScore >= historyThreshold
+}
+
+// matchKeywords counts the keywords of each candidate found in the tokens. Keywords of several
+// words must appear as consecutive tokens.
+func matchKeywords(tokens []string, candidates []Candidate) (uint, bool) {
+	text := " " + strings.Join(tokens, " ") + " "
+
+	var best uint
+	bestHits := 0
+	for _, candidate := range candidates {
+		hits := 0
+		for _, keyword := range candidate.Keywords {
+			if strings.Contains(text, " "+keyword+" ") {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = candidate.ID, hits
+		}
+	}
+	return best, bestHits > 0
+}
+
+// jaccard is the share of distinct words two descriptions have in common
+func jaccard(a, b []string) float64 {
+	set := make(map[string]bool, len(a))
+	for _, token := range a {
+		set[token] = true
+	}
+
+	union := len(set)
+	common := 0
+	seen := make(map[string]bool, len(b))
+	for _, token := range b {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		if set[token] {
+			common++
+		} else {
+			union++
+		}
+	}
+
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
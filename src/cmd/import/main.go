@@ -0,0 +1,82 @@
+// Command import loads a Splitwise export or a mapped CSV file into a group from the command line,
+// with the same checks as the import endpoint. The import is recorded as done by the group admin.
+//
+//	go run ./src/cmd/import -group 3 -file splitwise.csv -dry-run
+//	go run ./src/cmd/import -group 3 -file bank.csv -format csv -options options.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	controllers "github.com/tjens23/tabsplit-backend/src/Controllers"
+	database "github.com/tjens23/tabsplit-backend/src/Database"
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"github.com/tjens23/tabsplit-backend/src/currency"
+)
+
+func main() {
+	groupID := flag.Uint("group", 0, "ID of the group to import into")
+	path := flag.String("file", "", "CSV file to import")
+	format := flag.String("format", "", "splitwise (default) or csv")
+	optionsPath := flag.String("options", "", "JSON file with import options: mapping, people and create_placeholders")
+	placeholders := flag.Bool("placeholders", false, "create placeholder members for names that match nobody")
+	dryRun := flag.Bool("dry-run", false, "only check the file and print what would be imported")
+	flag.Parse()
+
+	if *groupID == 0 || *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var options controllers.ImportOptions
+	if *optionsPath != "" {
+		data, err := os.ReadFile(*optionsPath)
+		if err != nil {
+			log.Fatalf("Failed to read options: %v", err)
+		}
+		if err := json.Unmarshal(data, &options); err != nil {
+			log.Fatalf("Cannot parse options: %v", err)
+		}
+	}
+	if *format != "" {
+		options.Format = *format
+	}
+	options.CreatePlaceholders = options.CreatePlaceholders || *placeholders
+	options.DryRun = options.DryRun || *dryRun
+
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Fatalf("Failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	database.Connect()
+	if err := currency.Init(); err != nil {
+		log.Printf("Exchange rate table not loaded, only manual rates are available: %v", err)
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, *groupID).Error; err != nil {
+		log.Fatalf("Failed to fetch group %d: %v", *groupID, err)
+	}
+
+	report, err := controllers.ImportLedger(group, group.AdminID, options, file)
+	if err != nil {
+		log.Fatalf("Failed to import: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to print report: %v", err)
+	}
+
+	if len(report.Errors) > 0 {
+		fmt.Fprintln(os.Stderr, "Some lines can't be imported, nothing was saved")
+		os.Exit(1)
+	}
+}
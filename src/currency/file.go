@@ -0,0 +1,64 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FileProvider serves rates from a static JSON table, so conversions work offline.
+//
+// The file has the form {"base": "EUR", "rates": {"DKK": 7.46, "USD": 1.08}},
+// where each rate is the value of one unit of the base currency.
+type FileProvider struct {
+	Base  string
+	Rates map[string]float64
+}
+
+func NewFileProvider(path string) (*FileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var table struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	base, err := Normalize(table.Base)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: base: %w", path, err)
+	}
+
+	provider := &FileProvider{Base: base, Rates: map[string]float64{base: 1}}
+	for code, rate := range table.Rates {
+		normalized, err := Normalize(code)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("parse %s: bad rate for %q", path, code)
+		}
+		provider.Rates[normalized] = rate
+	}
+
+	return provider, nil
+}
+
+func (p *FileProvider) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	fromRate, ok := p.Rates[from]
+	if !ok {
+		return 0, ErrRateNotFound
+	}
+	toRate, ok := p.Rates[to]
+	if !ok {
+		return 0, ErrRateNotFound
+	}
+
+	return toRate / fromRate, nil
+}
@@ -0,0 +1,45 @@
+package currency
+
+import (
+	"github.com/tjens23/tabsplit-backend/src/Database/models"
+	"gorm.io/gorm"
+)
+
+// ManualProvider serves the rates a group admin has entered, newest first.
+// A rate entered for EUR->DKK is also used, inverted, for DKK->EUR.
+type ManualProvider struct {
+	DB      *gorm.DB
+	GroupID uint
+}
+
+func NewManualProvider(db *gorm.DB, groupID uint) ManualProvider {
+	return ManualProvider{DB: db, GroupID: groupID}
+}
+
+func (p ManualProvider) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	var rate models.ExchangeRate
+	err := p.DB.Where(
+		"group_id = ? AND ((from_currency = ? AND to_currency = ?) OR (from_currency = ? AND to_currency = ?))",
+		p.GroupID, from, to, to, from,
+	).Order("created_at DESC").First(&rate).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrRateNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if rate.FromCurrency == from {
+		return rate.Rate, nil
+	}
+	return 1 / rate.Rate, nil
+}
+
+// ForGroup returns the provider chain used for a group: manual rates first, then the default provider
+func ForGroup(db *gorm.DB, groupID uint) RateProvider {
+	return Chain{NewManualProvider(db, groupID), Provider}
+}
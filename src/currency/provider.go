@@ -0,0 +1,76 @@
+package currency
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// DefaultCurrency is used for groups and expenses that don't specify one
+const DefaultCurrency = "DKK"
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+var ErrInvalidCurrency = errors.New("invalid currency code")
+
+// RateProvider returns how many units of `to` one unit of `from` is worth
+type RateProvider interface {
+	Rate(from, to string) (float64, error)
+}
+
+// Provider is the application-wide fallback provider, set up by Init
+var Provider RateProvider = Chain{}
+
+// Init configures the default provider from the environment.
+// EXCHANGE_RATES_FILE points to a JSON rate table (defaults to src/static/exchange_rates.json).
+func Init() error {
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		path = "src/static/exchange_rates.json"
+	}
+
+	fileProvider, err := NewFileProvider(path)
+	if err != nil {
+		return err
+	}
+
+	Provider = fileProvider
+	return nil
+}
+
+// Normalize upper-cases a currency code and checks that it looks like an ISO 4217 code
+func Normalize(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", ErrInvalidCurrency
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrInvalidCurrency
+		}
+	}
+	return code, nil
+}
+
+// Chain asks each provider in order and returns the first rate found
+type Chain []RateProvider
+
+func (c Chain) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	for _, provider := range c {
+		if provider == nil {
+			continue
+		}
+		rate, err := provider.Rate(from, to)
+		if err == nil {
+			return rate, nil
+		}
+		if !errors.Is(err, ErrRateNotFound) {
+			return 0, err
+		}
+	}
+
+	return 0, ErrRateNotFound
+}
@@ -0,0 +1,37 @@
+// Package cursor encodes positions in sorted lists for keyset pagination.
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalid = errors.New("invalid cursor")
+
+// Cursor points just after the last row of a page. Sort names the ordering it was made for, Value
+// is that row's sort key and ID breaks ties between rows with the same key.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// Encode turns a cursor into an opaque URL-safe string
+func Encode(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode reads a cursor made by Encode
+func Decode(value string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, ErrInvalid
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == 0 {
+		return c, ErrInvalid
+	}
+	return c, nil
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// csvWriter puts every sheet in the same file: the sheets are separated by an empty line and start
+// with a line holding their title, followed by the column titles
+type csvWriter struct {
+	csv    *csv.Writer
+	sheets int
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{csv: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Sheet(sheet Sheet) error {
+	if w.sheets > 0 {
+		if err := w.csv.Write([]string{""}); err != nil {
+			return err
+		}
+	}
+	w.sheets++
+
+	if err := w.csv.Write([]string{sheet.Title}); err != nil {
+		return err
+	}
+	titles := make([]string, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		titles[i] = column.Title
+	}
+	return w.csv.Write(titles)
+}
+
+func (w *csvWriter) Row(cells []Cell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = cell.Value
+		if !cell.Number {
+			record[i] = escapeFormula(cell.Value)
+		}
+	}
+	return w.csv.Write(record)
+}
+
+func (w *csvWriter) Close() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// escapeFormula keeps spreadsheet apps from running text that looks like a formula, such as an
+// expense described as "=HYPERLINK(...)"
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
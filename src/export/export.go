@@ -0,0 +1,84 @@
+// Package export writes tables as CSV, XLSX or JSON files. Rows go straight to the output as they
+// are written, so a file of any size can be produced without holding it in memory.
+package export
+
+import (
+	"errors"
+	"io"
+)
+
+// Formats understood by New
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+	JSON = "json"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, expected csv, xlsx or json")
+
+// Sheet is one table of a file. Key names it in JSON and Title in CSV and XLSX.
+type Sheet struct {
+	Key     string
+	Title   string
+	Columns []Column
+}
+
+type Column struct {
+	Key   string
+	Title string
+}
+
+// Cell is a value in a row. Number cells hold a decimal such as "-412.50" and are written as
+// numbers; an empty number is a blank cell, or null in JSON.
+type Cell struct {
+	Value  string
+	Number bool
+}
+
+func Text(value string) Cell {
+	return Cell{Value: value}
+}
+
+func Number(value string) Cell {
+	return Cell{Value: value, Number: true}
+}
+
+// Writer writes sheets one after another. Rows belong to the sheet started last and must have
+// one cell per column.
+type Writer interface {
+	Sheet(sheet Sheet) error
+	Row(cells []Cell) error
+	// Close finishes the file without closing the underlying writer
+	Close() error
+}
+
+// New returns a writer for the format
+func New(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case XLSX:
+		return newXLSXWriter(w), nil
+	case JSON:
+		return newJSONWriter(w), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSON:
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// Valid reports whether New understands a format
+func Valid(format string) bool {
+	return format == CSV || format == XLSX || format == JSON
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonWriter writes an object with an array of row objects per sheet, keyed by the sheet and column keys
+type jsonWriter struct {
+	out     *bufio.Writer
+	columns []Column
+	sheets  int
+	rows    int
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{out: bufio.NewWriter(w)}
+}
+
+func (w *jsonWriter) Sheet(sheet Sheet) error {
+	separator := "{"
+	if w.sheets > 0 {
+		separator = "],"
+	}
+	w.sheets++
+	w.rows = 0
+	w.columns = sheet.Columns
+
+	w.out.WriteString(separator)
+	if err := writeJSONString(w.out, sheet.Key); err != nil {
+		return err
+	}
+	_, err := w.out.WriteString(":[")
+	return err
+}
+
+func (w *jsonWriter) Row(cells []Cell) error {
+	if w.rows > 0 {
+		w.out.WriteByte(',')
+	}
+	w.rows++
+
+	w.out.WriteByte('{')
+	for i, cell := range cells {
+		if i > 0 {
+			w.out.WriteByte(',')
+		}
+		if err := writeJSONString(w.out, w.columns[i].Key); err != nil {
+			return err
+		}
+		w.out.WriteByte(':')
+
+		var err error
+		switch {
+		case cell.Number && cell.Value == "":
+			_, err = w.out.WriteString("null")
+		case cell.Number:
+			_, err = w.out.WriteString(cell.Value)
+		default:
+			err = writeJSONString(w.out, cell.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.out.WriteString("}")
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	if w.sheets == 0 {
+		w.out.WriteString("{}")
+	} else {
+		w.out.WriteString("]}")
+	}
+	return w.out.Flush()
+}
+
+func writeJSONString(w *bufio.Writer, value string) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
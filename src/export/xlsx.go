@@ -0,0 +1,138 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSheetTitle is the longest sheet name spreadsheet apps accept
+const maxSheetTitle = 31
+
+// xlsxWriter writes a minimal Office Open XML workbook. Each sheet is a zip entry that is streamed
+// row by row; text is stored inline so no shared string table has to be kept in memory.
+type xlsxWriter struct {
+	zip    *zip.Writer
+	sheet  *bufio.Writer
+	titles []string
+	row    int
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w)}
+}
+
+func (w *xlsxWriter) Sheet(sheet Sheet) error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	title := sheet.Title
+	if utf8.RuneCountInString(title) > maxSheetTitle {
+		title = string([]rune(title)[:maxSheetTitle])
+	}
+	w.titles = append(w.titles, title)
+
+	entry, err := w.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.titles)))
+	if err != nil {
+		return err
+	}
+	w.sheet = bufio.NewWriter(entry)
+	w.row = 0
+	w.sheet.WriteString(xml.Header)
+	w.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]Cell, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		header[i] = Text(column.Title)
+	}
+	return w.Row(header)
+}
+
+func (w *xlsxWriter) Row(cells []Cell) error {
+	w.row++
+	fmt.Fprintf(w.sheet, `<row r="%d">`, w.row)
+	for i, cell := range cells {
+		if cell.Value == "" {
+			continue
+		}
+		reference := columnName(i) + strconv.Itoa(w.row)
+		if cell.Number {
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%s</v></c>`, reference, cell.Value)
+			continue
+		}
+		fmt.Fprintf(w.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, reference)
+		if err := xml.EscapeText(w.sheet, []byte(cell.Value)); err != nil {
+			return err
+		}
+		w.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (w *xlsxWriter) endSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	w.sheet.WriteString(`</sheetData></worksheet>`)
+	err := w.sheet.Flush()
+	w.sheet = nil
+	return err
+}
+
+func (w *xlsxWriter) Close() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	var sheets, relationships, overrides strings.Builder
+	for i, title := range w.titles {
+		var escaped strings.Builder
+		xml.EscapeText(&escaped, []byte(title))
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escaped.String(), i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			overrides.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			relationships.String() + `</Relationships>`},
+	}
+	for _, part := range parts {
+		entry, err := w.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(entry, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+
+	return w.zip.Close()
+}
+
+// columnName turns a zero-based column index into a spreadsheet column name: A, B, ..., Z, AA, AB, ...
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
@@ -0,0 +1,251 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// Mapping tells ParseGeneric which columns of a CSV file hold what. Column names are matched
+// ignoring case. Each line is split either equally between the names in Participants or exactly by
+// the Shares columns, which map a person to the column holding what they owe.
+type Mapping struct {
+	Date          string            `json:"date"`
+	DateFormat    string            `json:"date_format,omitempty"`
+	Description   string            `json:"description"`
+	Amount        string            `json:"amount"`
+	Currency      string            `json:"currency,omitempty"`
+	Category      string            `json:"category,omitempty"`
+	PaidBy        string            `json:"paid_by"`
+	Participants  string            `json:"participants,omitempty"`
+	ListSeparator string            `json:"list_separator,omitempty"`
+	Shares        map[string]string `json:"shares,omitempty"`
+	Delimiter     string            `json:"delimiter,omitempty"`
+	DecimalComma  bool              `json:"decimal_comma,omitempty"`
+}
+
+func errMissingColumn(name string) error {
+	return fmt.Errorf("the file has no %q column", name)
+}
+
+// columns looks up the mapped columns in the header
+type columns struct {
+	date, description, amount, currency, category, paidBy, participants int
+	shares                                                              map[string]int
+}
+
+func (m Mapping) validate() error {
+	if m.Date == "" || m.Description == "" || m.Amount == "" || m.PaidBy == "" {
+		return errors.New("the mapping needs date, description, amount and paid_by columns")
+	}
+	if (m.Participants == "") == (len(m.Shares) == 0) {
+		return errors.New("the mapping needs either a participants column or share columns, not both")
+	}
+	if utf8.RuneCountInString(m.Delimiter) > 1 {
+		return errors.New("the delimiter must be a single character")
+	}
+	return nil
+}
+
+func (m Mapping) resolve(header []string) (columns, error) {
+	cols := columns{shares: make(map[string]int)}
+	required := []struct {
+		name  string
+		index *int
+	}{
+		{m.Date, &cols.date},
+		{m.Description, &cols.description},
+		{m.Amount, &cols.amount},
+		{m.PaidBy, &cols.paidBy},
+	}
+	for _, column := range required {
+		*column.index = columnIndex(header, column.name)
+		if *column.index < 0 {
+			return cols, errMissingColumn(column.name)
+		}
+	}
+
+	optional := []struct {
+		name  string
+		index *int
+	}{
+		{m.Currency, &cols.currency},
+		{m.Category, &cols.category},
+		{m.Participants, &cols.participants},
+	}
+	for _, column := range optional {
+		*column.index = -1
+		if column.name == "" {
+			continue
+		}
+		*column.index = columnIndex(header, column.name)
+		if *column.index < 0 {
+			return cols, errMissingColumn(column.name)
+		}
+	}
+
+	for person, name := range m.Shares {
+		index := columnIndex(header, name)
+		if index < 0 {
+			return cols, errMissingColumn(name)
+		}
+		cols.shares[strings.TrimSpace(person)] = index
+	}
+	return cols, nil
+}
+
+// parseAmount reads an amount, accepting a decimal comma when the mapping asks for it
+func (m Mapping) parseAmount(value string, currency string) (money.Amount, error) {
+	if m.DecimalComma {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.ReplaceAll(value, ",", ".")
+	}
+	return money.Parse(value, currency)
+}
+
+// ParseGeneric reads a CSV file with one expense per line, laid out as described by mapping.
+// A negative amount is read as income received by the paid_by person on behalf of the others.
+func ParseGeneric(r io.Reader, mapping Mapping, defaultCurrency string) (Result, error) {
+	var result Result
+
+	if err := mapping.validate(); err != nil {
+		return result, err
+	}
+	if mapping.DateFormat == "" {
+		mapping.DateFormat = "2006-01-02"
+	}
+	if mapping.ListSeparator == "" {
+		mapping.ListSeparator = ";"
+	}
+	delimiter := ','
+	if mapping.Delimiter != "" {
+		delimiter, _ = utf8.DecodeRuneInString(mapping.Delimiter)
+	}
+
+	header, records, err := readRecords(r, delimiter)
+	if err != nil {
+		return result, err
+	}
+	cols, err := mapping.resolve(header)
+	if err != nil {
+		return result, err
+	}
+
+	seen := make(map[string]bool)
+	for i, record := range records {
+		line := i + 2
+		if blank(record) {
+			result.Skipped++
+			continue
+		}
+
+		date, err := time.Parse(mapping.DateFormat, field(record, cols.date))
+		if err != nil {
+			result.fail(line, "invalid date %q, expected the format %s", field(record, cols.date), mapping.DateFormat)
+			continue
+		}
+		currency := strings.ToUpper(field(record, cols.currency))
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		amount, err := mapping.parseAmount(field(record, cols.amount), currency)
+		if err != nil {
+			result.fail(line, "invalid amount %q", field(record, cols.amount))
+			continue
+		}
+		if amount == 0 {
+			result.fail(line, "the amount is 0")
+			continue
+		}
+		paidBy := field(record, cols.paidBy)
+		if paidBy == "" {
+			result.fail(line, "nobody paid")
+			continue
+		}
+
+		entry := Entry{
+			Line:        line,
+			Date:        date,
+			Description: field(record, cols.description),
+			Category:    field(record, cols.category),
+			Currency:    currency,
+			Amount:      amount.Abs(),
+			Income:      amount < 0,
+			Payers:      map[string]money.Amount{paidBy: amount.Abs()},
+			Shares:      make(map[string]money.Amount),
+		}
+		if entry.Description == "" {
+			result.fail(line, "the description is empty")
+			continue
+		}
+
+		var message string
+		if cols.participants >= 0 {
+			message = splitEqually(&entry, field(record, cols.participants), mapping.ListSeparator)
+		} else {
+			message = mapping.splitExactly(&entry, record, cols.shares)
+		}
+		if message != "" {
+			result.fail(line, "%s", message)
+			continue
+		}
+
+		result.Entries = append(result.Entries, entry)
+		result.addPeople(entry, seen)
+	}
+
+	return result, nil
+}
+
+// splitEqually divides the amount of an entry between a list of names
+func splitEqually(entry *Entry, list string, separator string) string {
+	var names []string
+	for _, name := range strings.Split(list, separator) {
+		name = strings.TrimSpace(name)
+		if name != "" && entry.Shares[name] == 0 {
+			entry.Shares[name] = 1
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "nobody takes part"
+	}
+
+	amounts, err := money.AllocateEvenly(entry.Amount, len(names))
+	if err != nil {
+		return err.Error()
+	}
+	for i, name := range names {
+		entry.Shares[name] = amounts[i]
+	}
+	return ""
+}
+
+// splitExactly reads what each person owes from their share column; the shares must add up to the amount
+func (m Mapping) splitExactly(entry *Entry, record []string, shares map[string]int) string {
+	var total money.Amount
+	for person, index := range shares {
+		value := field(record, index)
+		if value == "" {
+			continue
+		}
+		owed, err := m.parseAmount(value, entry.Currency)
+		if err != nil {
+			return fmt.Sprintf("invalid share %q for %s", value, person)
+		}
+		owed = owed.Abs()
+		if owed != 0 {
+			entry.Shares[person] = owed
+			total += owed
+		}
+	}
+	if total != entry.Amount {
+		return fmt.Sprintf("the shares add up to %s instead of %s", total.Format(entry.Currency), entry.Amount.Format(entry.Currency))
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+// Package importer reads expense history from other apps' CSV exports into entries that still name
+// people as they appear in the file. Matching those names to group members is up to the caller.
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// Formats understood by Parse
+const (
+	Splitwise = "splitwise"
+	Generic   = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown import format, expected splitwise or csv")
+
+// Entry is one expense, income or payment from an import file. Payers maps each person to what they
+// paid (or received, for income) and Shares to what they owe (or are credited). A payment has one
+// payer and one share, the receiver, both for Amount.
+type Entry struct {
+	Line        int
+	Date        time.Time
+	Description string
+	Category    string
+	Currency    string
+	Amount      money.Amount
+	Income      bool
+	Payment     bool
+	Payers      map[string]money.Amount
+	Shares      map[string]money.Amount
+}
+
+// LineError explains why a line of the file can't be imported
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Result is everything read from a file. People lists every name in the entries, in order of first appearance.
+type Result struct {
+	Entries []Entry
+	Errors  []LineError
+	Skipped int
+	People  []string
+}
+
+// Parse reads a file in the given format. Amounts without a currency column are in defaultCurrency.
+// Problems with single lines are collected in Errors; an error is returned only when the file can't be read at all.
+func Parse(format string, r io.Reader, mapping Mapping, defaultCurrency string) (Result, error) {
+	switch format {
+	case Splitwise:
+		return ParseSplitwise(r, defaultCurrency)
+	case Generic:
+		return ParseGeneric(r, mapping, defaultCurrency)
+	}
+	return Result{}, ErrUnknownFormat
+}
+
+// readRecords reads the header and the remaining records of a CSV file
+func readRecords(r io.Reader, delimiter rune) ([]string, [][]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("the file is empty")
+	}
+
+	header := records[0]
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	return header, records[1:], nil
+}
+
+// columnIndex finds a column by name, ignoring case; -1 when it isn't there
+func columnIndex(header []string, name string) int {
+	for i, column := range header {
+		if strings.EqualFold(column, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// field returns a trimmed value from a record, or "" for a missing column
+func field(record []string, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[index])
+}
+
+// blank reports whether every field of a record is empty
+func blank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// addPeople appends the names of an entry that weren't seen before
+func (r *Result) addPeople(entry Entry, seen map[string]bool) {
+	for _, people := range []map[string]money.Amount{entry.Payers, entry.Shares} {
+		for _, name := range sortedNames(people) {
+			if !seen[name] {
+				seen[name] = true
+				r.People = append(r.People, name)
+			}
+		}
+	}
+}
+
+func (r *Result) fail(line int, format string, args ...any) {
+	r.Errors = append(r.Errors, LineError{Line: line, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,192 @@
+package importer
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// splitwiseColumns are the fixed columns of a Splitwise export; every column after them is a person
+var splitwiseColumns = []string{"Date", "Description", "Category", "Cost", "Currency"}
+
+// ParseSplitwise reads a Splitwise CSV export. Each person column holds that person's net effect:
+// what they paid minus what they owe. Payers and shares are rebuilt from it so balances come out the
+// same; when several people paid, the part of the cost they used themselves is divided between them
+// in proportion to what they are owed.
+func ParseSplitwise(r io.Reader, defaultCurrency string) (Result, error) {
+	var result Result
+
+	header, records, err := readRecords(r, ',')
+	if err != nil {
+		return result, err
+	}
+	for i, name := range splitwiseColumns {
+		if i >= len(header) || !strings.EqualFold(header[i], name) {
+			return result, errMissingColumn(name)
+		}
+	}
+	people := header[len(splitwiseColumns):]
+
+	seen := make(map[string]bool)
+	for i, record := range records {
+		line := i + 2
+		description := field(record, 1)
+		if blank(record) || strings.EqualFold(description, "Total balance") {
+			result.Skipped++
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", field(record, 0))
+		if err != nil {
+			result.fail(line, "invalid date %q, expected YYYY-MM-DD", field(record, 0))
+			continue
+		}
+		currency := strings.ToUpper(field(record, 4))
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		cost, err := money.Parse(field(record, 3), currency)
+		if err != nil {
+			result.fail(line, "invalid cost %q", field(record, 3))
+			continue
+		}
+
+		nets := make(map[string]money.Amount)
+		var sum money.Amount
+		valid := true
+		for j, name := range people {
+			value := field(record, len(splitwiseColumns)+j)
+			if value == "" {
+				continue
+			}
+			net, err := money.Parse(value, currency)
+			if err != nil {
+				result.fail(line, "invalid amount %q for %s", value, name)
+				valid = false
+				break
+			}
+			if net != 0 {
+				nets[name] = net
+				sum += net
+			}
+		}
+		if !valid {
+			continue
+		}
+		if sum != 0 {
+			result.fail(line, "the amounts per person add up to %s instead of 0", sum.Format(currency))
+			continue
+		}
+
+		entry := Entry{
+			Line:        line,
+			Date:        date,
+			Description: description,
+			Category:    field(record, 2),
+			Currency:    currency,
+		}
+
+		// Refunds come as negative costs; flipped around they are income to the people who got the money
+		if cost < 0 {
+			entry.Income = true
+			cost = -cost
+			for name := range nets {
+				nets[name] = -nets[name]
+			}
+		}
+		entry.Amount = cost
+
+		if strings.EqualFold(entry.Category, "Payment") {
+			if !splitwisePayment(&entry, nets) {
+				result.fail(line, "a payment needs exactly one person who paid and one who received %s", cost.Format(currency))
+				continue
+			}
+		} else if message := splitwiseExpense(&entry, nets); message != "" {
+			result.fail(line, "%s", message)
+			continue
+		}
+
+		result.Entries = append(result.Entries, entry)
+		result.addPeople(entry, seen)
+	}
+
+	return result, nil
+}
+
+// splitwisePayment fills in a payment from the nets of its two people
+func splitwisePayment(entry *Entry, nets map[string]money.Amount) bool {
+	if len(nets) != 2 || entry.Income {
+		return false
+	}
+	entry.Payment = true
+	entry.Payers = make(map[string]money.Amount)
+	entry.Shares = make(map[string]money.Amount)
+	for name, net := range nets {
+		if net.Abs() != entry.Amount {
+			return false
+		}
+		if net > 0 {
+			entry.Payers[name] = entry.Amount
+		} else {
+			entry.Shares[name] = entry.Amount
+		}
+	}
+	return len(entry.Payers) == 1 && len(entry.Shares) == 1
+}
+
+// splitwiseExpense rebuilds payers and shares from the nets of an expense; it returns a message
+// when that isn't possible
+func splitwiseExpense(entry *Entry, nets map[string]money.Amount) string {
+	var creditors []string
+	var lent money.Amount
+	for _, name := range sortedNames(nets) {
+		if nets[name] > 0 {
+			creditors = append(creditors, name)
+			lent += nets[name]
+		}
+	}
+	if len(creditors) == 0 {
+		return "can't tell who paid, nobody is owed anything for this expense"
+	}
+	if lent > entry.Amount {
+		return "the amounts per person are larger than the cost"
+	}
+
+	// What the payers used themselves
+	weights := make([]int64, len(creditors))
+	for i, name := range creditors {
+		weights[i] = int64(nets[name])
+	}
+	own, err := money.Allocate(entry.Amount-lent, weights)
+	if err != nil {
+		return err.Error()
+	}
+
+	entry.Payers = make(map[string]money.Amount)
+	entry.Shares = make(map[string]money.Amount)
+	for i, name := range creditors {
+		entry.Payers[name] = nets[name] + own[i]
+		if own[i] > 0 {
+			entry.Shares[name] = own[i]
+		}
+	}
+	for name, net := range nets {
+		if net < 0 {
+			entry.Shares[name] = -net
+		}
+	}
+	return ""
+}
+
+// sortedNames returns the keys of a map in alphabetical order, so results don't depend on map order
+func sortedNames(amounts map[string]money.Amount) []string {
+	names := make([]string, 0, len(amounts))
+	for name := range amounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	database "github.com/tjens23/tabsplit-backend/src/Database"
+	controllers "github.com/tjens23/tabsplit-backend/src/Controllers"
 	routes "github.com/tjens23/tabsplit-backend/src/Routes"
+	"github.com/tjens23/tabsplit-backend/src/currency"
 	_ "github.com/tjens23/tabsplit-backend/src/docs"
+	"github.com/tjens23/tabsplit-backend/src/scheduler"
+	"github.com/tjens23/tabsplit-backend/src/storage"
 )
 
 // @title OweSome Backend API
@@ -20,8 +26,18 @@ import (
 // @name jwt
 
 func main() {
-	app := fiber.New()
+	// Leave room for the multipart overhead around the largest attachment
+	app := fiber.New(fiber.Config{BodyLimit: controllers.MaxAttachmentSize + 1<<20})
 	database.Connect()
+	if err := currency.Init(); err != nil {
+		log.Printf("Exchange rate table not loaded, only manual rates are available: %v", err)
+	}
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Upload storage could not be set up: %v", err)
+	}
+	scheduler.Every(context.Background(), time.Minute, "recurring expenses", controllers.MaterializeRecurringExpenses)
+	scheduler.Every(context.Background(), time.Hour, "trash purge", controllers.PurgeTrash)
+	scheduler.Every(context.Background(), time.Hour, "settlement reminders", controllers.RemindUnconfirmedSettlements)
 	
 	// Add Swagger JSON endpoint
 	app.Get("/swagger/doc.json", func(c fiber.Ctx) error {
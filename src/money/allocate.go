@@ -0,0 +1,73 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+)
+
+var ErrNoWeights = errors.New("cannot allocate without positive weights")
+
+// Allocate splits total into parts proportional to weights so that the parts always add up to total.
+// Each part is first rounded towards zero; the leftover minor units go one at a time to the parts
+// with the largest remainders, ties broken by position, so the result is deterministic.
+func Allocate(total Amount, weights []int64) ([]Amount, error) {
+	var weightSum int64
+	for _, weight := range weights {
+		if weight < 0 {
+			return nil, ErrNoWeights
+		}
+		weightSum += weight
+	}
+	if weightSum == 0 {
+		return nil, ErrNoWeights
+	}
+
+	negative := total < 0
+	remaining := int64(total.Abs())
+
+	parts := make([]Amount, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	bigTotal := big.NewInt(remaining)
+	bigSum := big.NewInt(weightSum)
+
+	for i, weight := range weights {
+		product := new(big.Int).Mul(bigTotal, big.NewInt(weight))
+		quotient, remainder := new(big.Int).QuoRem(product, bigSum, new(big.Int))
+		parts[i] = Amount(quotient.Int64())
+		remainders[i] = remainder
+		remaining -= quotient.Int64()
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].Cmp(remainders[order[b]]) > 0
+	})
+
+	for i := 0; remaining > 0; i = (i + 1) % len(order) {
+		if weights[order[i]] == 0 {
+			continue
+		}
+		parts[order[i]]++
+		remaining--
+	}
+
+	if negative {
+		for i := range parts {
+			parts[i] = -parts[i]
+		}
+	}
+	return parts, nil
+}
+
+// AllocateEvenly splits total into n parts that differ by at most one minor unit
+func AllocateEvenly(total Amount, n int) ([]Amount, error) {
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return Allocate(total, weights)
+}
@@ -0,0 +1,57 @@
+package money
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   Amount
+		weights []int64
+		want    []Amount
+	}{
+		{"even", 900, []int64{1, 1, 1}, []Amount{300, 300, 300}},
+		{"leftover cent goes to the first", 100, []int64{1, 1, 1}, []Amount{34, 33, 33}},
+		{"two leftover cents", 200, []int64{1, 1, 1}, []Amount{67, 67, 66}},
+		{"largest remainder first", 1000, []int64{1, 2, 4}, []Amount{143, 286, 571}},
+		{"zero weight gets nothing", 101, []int64{1, 0, 1}, []Amount{51, 0, 50}},
+		{"negative total", -100, []int64{1, 1, 1}, []Amount{-34, -33, -33}},
+		{"single part", 12345, []int64{7}, []Amount{12345}},
+		{"zero total", 0, []int64{1, 2}, []Amount{0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Allocate(test.total, test.weights)
+			if err != nil {
+				t.Fatalf("Allocate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Allocate(%d, %v) = %v, want %v", test.total, test.weights, got, test.want)
+			}
+			if Sum(got) != test.total {
+				t.Errorf("parts add up to %d, want %d", Sum(got), test.total)
+			}
+		})
+	}
+}
+
+func TestAllocateWithoutWeights(t *testing.T) {
+	for _, weights := range [][]int64{nil, {0, 0}, {1, -1}} {
+		if _, err := Allocate(100, weights); !errors.Is(err, ErrNoWeights) {
+			t.Errorf("Allocate(100, %v) error = %v, want %v", weights, err, ErrNoWeights)
+		}
+	}
+}
+
+func TestAllocateEvenly(t *testing.T) {
+	got, err := AllocateEvenly(1000, 3)
+	if err != nil {
+		t.Fatalf("AllocateEvenly() error = %v", err)
+	}
+	if want := []Amount{334, 333, 333}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AllocateEvenly(1000, 3) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+// Package money implements exact monetary arithmetic on integer minor units.
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a monetary value in the minor unit of its currency (cents, øre, fils, ...).
+// The currency is stored next to the amount, never inside it.
+type Amount int64
+
+var ErrInvalidAmount = errors.New("invalid amount")
+var ErrTooPrecise = errors.New("amount has more decimals than the currency allows")
+
+// zeroDecimalCurrencies and threeDecimalCurrencies follow ISO 4217; everything else has 2 decimals
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true,
+	"KMF": true, "KRW": true, "PYG": true, "RWF": true, "UGX": true, "UYI": true,
+	"VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+var threeDecimalCurrencies = map[string]bool{
+	"BHD": true, "IQD": true, "JOD": true, "KWD": true, "LYD": true, "OMR": true, "TND": true,
+}
+
+// Decimals returns the number of minor-unit digits for a currency
+func Decimals(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	if threeDecimalCurrencies[currency] {
+		return 3
+	}
+	return 2
+}
+
+// KnownDecimalCurrencies returns currency codes whose precision differs from 2 decimals, by decimals
+func KnownDecimalCurrencies() map[int][]string {
+	result := map[int][]string{}
+	for code := range zeroDecimalCurrencies {
+		result[0] = append(result[0], code)
+	}
+	for code := range threeDecimalCurrencies {
+		result[3] = append(result[3], code)
+	}
+	return result
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
+
+// Parse reads a decimal string such as "412.50" in major units of the currency.
+// It never goes through float64, and rejects values more precise than the currency.
+func Parse(value string, currency string) (Amount, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return 0, ErrInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	decimals := Decimals(currency)
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > decimals {
+		return 0, ErrTooPrecise
+	}
+	fraction += strings.Repeat("0", decimals-len(fraction))
+
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidAmount
+		}
+	}
+
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	if negative {
+		minor = -minor
+	}
+	return Amount(minor), nil
+}
+
+// Format renders an amount in major units, e.g. Amount(41250).Format("DKK") == "412.50"
+func (a Amount) Format(currency string) string {
+	decimals := Decimals(currency)
+	sign := ""
+	value := int64(a)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	if decimals == 0 {
+		return sign + strconv.FormatInt(value, 10)
+	}
+
+	unit := pow10(decimals)
+	fraction := strconv.FormatInt(value%unit, 10)
+	return sign + strconv.FormatInt(value/unit, 10) + "." + strings.Repeat("0", decimals-len(fraction)) + fraction
+}
+
+// Abs returns the absolute value of an amount
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Min returns the smaller of two amounts
+func Min(a, b Amount) Amount {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Sum adds up amounts
+func Sum(amounts []Amount) Amount {
+	var total Amount
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
+
+// Convert converts an amount from one currency to another at the given rate
+// (units of `to` per unit of `from`), rounding half away from zero to the target precision.
+func Convert(a Amount, rate float64, from, to string) Amount {
+	if from == to && rate == 1 {
+		return a
+	}
+
+	value := new(big.Rat).SetInt64(int64(a))
+	value.Mul(value, decimalRat(rate))
+
+	// Rescale from the source precision to the target precision
+	shift := Decimals(to) - Decimals(from)
+	if shift > 0 {
+		value.Mul(value, new(big.Rat).SetInt64(pow10(shift)))
+	} else if shift < 0 {
+		value.Quo(value, new(big.Rat).SetInt64(pow10(-shift)))
+	}
+
+	return roundRat(value)
+}
+
+// FromFloat converts a legacy float value in major units into minor units.
+// The float is read through its shortest decimal representation, so 0.285 becomes 29 cents.
+func FromFloat(value float64, currency string) Amount {
+	return roundRat(new(big.Rat).Mul(
+		decimalRat(value),
+		new(big.Rat).SetInt64(pow10(Decimals(currency))),
+	))
+}
+
+// decimalRat returns the exact value of the shortest decimal representation of a float
+func decimalRat(value float64) *big.Rat {
+	rat, ok := new(big.Rat).SetString(strconv.FormatFloat(value, 'g', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return rat
+}
+
+// roundRat rounds half away from zero
+func roundRat(value *big.Rat) Amount {
+	numerator := new(big.Int).Set(value.Num())
+	denominator := value.Denom()
+	negative := numerator.Sign() < 0
+	numerator.Abs(numerator)
+
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if new(big.Int).Mul(remainder, big.NewInt(2)).Cmp(denominator) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if negative {
+		quotient.Neg(quotient)
+	}
+	return Amount(quotient.Int64())
+}
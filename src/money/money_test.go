@@ -0,0 +1,101 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     Amount
+		err      error
+	}{
+		{"412.50", "DKK", 41250, nil},
+		{"412.5", "DKK", 41250, nil},
+		{"412", "DKK", 41200, nil},
+		{".5", "EUR", 50, nil},
+		{"-3.07", "EUR", -307, nil},
+		{"+1.00", "EUR", 100, nil},
+		{"1500", "JPY", 1500, nil},
+		{"1.250", "KWD", 1250, nil},
+		{"1.005", "DKK", 0, ErrTooPrecise},
+		{"1.5", "JPY", 0, ErrTooPrecise},
+		{"", "DKK", 0, ErrInvalidAmount},
+		{"1,50", "DKK", 0, ErrInvalidAmount},
+		{"abc", "DKK", 0, ErrInvalidAmount},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.value, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Parse(%q, %s) error = %v, want %v", test.value, test.currency, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q, %s) = %d, want %d", test.value, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		currency string
+		want     string
+	}{
+		{41250, "DKK", "412.50"},
+		{5, "DKK", "0.05"},
+		{-307, "EUR", "-3.07"},
+		{1500, "JPY", "1500"},
+		{1250, "KWD", "1.250"},
+		{0, "DKK", "0.00"},
+	}
+	for _, test := range tests {
+		if got := test.amount.Format(test.currency); got != test.want {
+			t.Errorf("Amount(%d).Format(%s) = %q, want %q", test.amount, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		rate     float64
+		from, to string
+		want     Amount
+	}{
+		{10000, 1, "DKK", "DKK", 10000},
+		{10000, 7.46, "EUR", "DKK", 74600},
+		{333, 0.134, "DKK", "EUR", 45},   // 44.622 rounds down
+		{335, 0.134, "DKK", "EUR", 45},   // 44.89 rounds up
+		{-335, 0.134, "DKK", "EUR", -45}, // half away from zero for negatives too
+		{10000, 21.5, "DKK", "JPY", 2150},
+		{1000, 0.4, "JPY", "KWD", 400000},
+		{250, 0.5, "EUR", "EUR", 125},
+	}
+	for _, test := range tests {
+		if got := Convert(test.amount, test.rate, test.from, test.to); got != test.want {
+			t.Errorf("Convert(%d, %v, %s, %s) = %d, want %d", test.amount, test.rate, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		currency string
+		want     Amount
+	}{
+		{0.285, "DKK", 29},
+		{412.5, "DKK", 41250},
+		{-0.005, "EUR", -1},
+		{1500, "JPY", 1500},
+		{1.2345, "KWD", 1235},
+	}
+	for _, test := range tests {
+		if got := FromFloat(test.value, test.currency); got != test.want {
+			t.Errorf("FromFloat(%v, %s) = %d, want %d", test.value, test.currency, got, test.want)
+		}
+	}
+}
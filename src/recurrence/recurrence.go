@@ -0,0 +1,62 @@
+// Package recurrence computes the dates of repeating events.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "daily"
+	Weekly  Frequency = "weekly"
+	Monthly Frequency = "monthly"
+	Yearly  Frequency = "yearly"
+)
+
+var ErrUnknownFrequency = errors.New("unknown frequency")
+var ErrInvalidInterval = errors.New("interval must be at least 1")
+
+// ParseFrequency validates a frequency name
+func ParseFrequency(frequency string) (Frequency, error) {
+	switch Frequency(frequency) {
+	case Daily, Weekly, Monthly, Yearly:
+		return Frequency(frequency), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFrequency, frequency)
+}
+
+// Occurrence returns the n-th occurrence (starting at 0 for the anchor itself) of an event
+// repeating every interval periods. Monthly and yearly events anchored on a day that doesn't exist
+// in every month fall on the last day of shorter months, so rent due on the 31st is due on Feb 28.
+func Occurrence(anchor time.Time, frequency Frequency, interval int, n int) time.Time {
+	switch frequency {
+	case Daily:
+		return anchor.AddDate(0, 0, n*interval)
+	case Weekly:
+		return anchor.AddDate(0, 0, 7*n*interval)
+	case Monthly:
+		return addMonthsClamped(anchor, n*interval)
+	case Yearly:
+		return addMonthsClamped(anchor, 12*n*interval)
+	}
+	return anchor
+}
+
+// Date truncates a time to midnight in its location
+func Date(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func addMonthsClamped(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
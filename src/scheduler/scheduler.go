@@ -0,0 +1,42 @@
+// Package scheduler runs background jobs inside the API process.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is called with the current time on every tick
+type Job func(now time.Time) error
+
+// Every runs job once right away and then on every interval until ctx is cancelled.
+// Jobs must be idempotent: several API instances may run the same job at the same time.
+func Every(ctx context.Context, interval time.Duration, name string, job Job) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run(name, job)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func run(name string, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Scheduled job %q panicked: %v", name, r)
+		}
+	}()
+
+	if err := job(time.Now()); err != nil {
+		log.Printf("Scheduled job %q failed: %v", name, err)
+	}
+}
@@ -0,0 +1,80 @@
+package settle
+
+import (
+	"math/bits"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+type exact struct{}
+
+func (exact) Name() string { return Exact }
+
+// Settle splits the balances into as many groups adding up to zero as possible. A group of k
+// balances settles in k-1 transfers and can't do with fewer, so n balances in g groups take n-g
+// transfers, which is the minimum.
+func (exact) Settle(debts []Transfer) []Transfer {
+	balances := Balances(debts)
+	n := len(balances)
+	if n > MaxExactParticipants {
+		return settleGreedily(balances)
+	}
+
+	// For each subset of the balances (a bit per balance), sums holds its total and most holds the
+	// most zero-sum groups it can be split into. Removing one balance from a subset loses at most
+	// one group, and loses none unless the subset itself adds up to zero.
+	size := 1 << n
+	sums := make([]money.Amount, size)
+	most := make([]int8, size)
+	for subset := 1; subset < size; subset++ {
+		lowest := bits.TrailingZeros(uint(subset))
+		sums[subset] = sums[subset&(subset-1)] + balances[lowest].Amount
+
+		var best int8
+		for rest := subset; rest != 0; rest &= rest - 1 {
+			without := subset &^ (1 << bits.TrailingZeros(uint(rest)))
+			best = max(best, most[without])
+		}
+		if sums[subset] == 0 {
+			best++
+		}
+		most[subset] = best
+	}
+
+	// Walk back from all balances, removing one balance at a time while keeping the best count.
+	// Every zero-sum subset on the way closes one group.
+	var transfers []Transfer
+	subset, groupEnd := size-1, size-1
+	for subset != 0 {
+		for rest := subset; rest != 0; rest &= rest - 1 {
+			without := subset &^ (1 << bits.TrailingZeros(uint(rest)))
+			gained := int8(0)
+			if sums[subset] == 0 {
+				gained = 1
+			}
+			if most[without]+gained == most[subset] {
+				subset = without
+				break
+			}
+		}
+		if sums[subset] == 0 {
+			transfers = append(transfers, settleGreedily(pick(balances, groupEnd&^subset))...)
+			groupEnd = subset
+		}
+	}
+	if transfers == nil {
+		return []Transfer{}
+	}
+	return transfers
+}
+
+// pick returns the balances whose bits are set in subset
+func pick(balances []Balance, subset int) []Balance {
+	var picked []Balance
+	for i := range balances {
+		if subset&(1<<i) != 0 {
+			picked = append(picked, balances[i])
+		}
+	}
+	return picked
+}
@@ -0,0 +1,46 @@
+package settle
+
+import "github.com/tjens23/tabsplit-backend/src/money"
+
+type greedy struct{}
+
+func (greedy) Name() string { return Greedy }
+
+func (greedy) Settle(debts []Transfer) []Transfer {
+	return settleGreedily(Balances(debts))
+}
+
+// settleGreedily lets the largest debtor pay the largest creditor until everyone is even. Each
+// transfer evens out at least one of them, so n balances that add up to zero take at most n-1
+// transfers. Ties go to the lowest user ID.
+func settleGreedily(balances []Balance) []Transfer {
+	working := make([]Balance, len(balances))
+	copy(working, balances)
+
+	transfers := []Transfer{}
+	for {
+		debtor, creditor := -1, -1
+		for i, balance := range working {
+			if balance.Amount < 0 && (debtor == -1 || balance.Amount < working[debtor].Amount ||
+				balance.Amount == working[debtor].Amount && balance.UserID < working[debtor].UserID) {
+				debtor = i
+			}
+			if balance.Amount > 0 && (creditor == -1 || balance.Amount > working[creditor].Amount ||
+				balance.Amount == working[creditor].Amount && balance.UserID < working[creditor].UserID) {
+				creditor = i
+			}
+		}
+		if debtor == -1 || creditor == -1 {
+			return transfers
+		}
+
+		amount := money.Min(working[debtor].Amount.Abs(), working[creditor].Amount)
+		transfers = append(transfers, Transfer{
+			PayerID:    working[debtor].UserID,
+			ReceiverID: working[creditor].UserID,
+			Amount:     amount,
+		})
+		working[debtor].Amount += amount
+		working[creditor].Amount -= amount
+	}
+}
@@ -0,0 +1,282 @@
+package settle
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+var ErrNoValidPlan = errors.New("no settlement plan honours the payment rules")
+
+// Pair is a payer and the receiver they would pay
+type Pair struct {
+	PayerID    uint
+	ReceiverID uint
+}
+
+// Rules restrict who pays whom. Preferred pairs settle with each other before anyone else, in the
+// order given; forbidden pairs never get a transfer.
+type Rules struct {
+	Preferred []Pair
+	Forbidden []Pair
+}
+
+// NoPlanError means the rules leave no way to settle everything. Unsettled is what would be left
+// over: those who would still owe and those who would still be owed.
+type NoPlanError struct {
+	Unsettled []Balance
+}
+
+func (e *NoPlanError) Error() string { return ErrNoValidPlan.Error() }
+
+func (e *NoPlanError) Unwrap() error { return ErrNoValidPlan }
+
+// Plan settles the debts with the strategy while honouring the rules. Preferred pairs settle first
+// and the strategy settles what is left. If that needs a forbidden transfer, the rest is routed
+// around it instead, possibly through members who are even, still aiming for few transfers. It
+// returns a *NoPlanError when no routing exists.
+func Plan(strategy Strategy, rules Rules, debts []Transfer) ([]Transfer, error) {
+	if len(rules.Preferred) == 0 && len(rules.Forbidden) == 0 {
+		return strategy.Settle(debts), nil
+	}
+
+	forbidden := make(map[Pair]bool)
+	for _, pair := range rules.Forbidden {
+		forbidden[pair] = true
+	}
+
+	positions := make(map[uint]money.Amount)
+	for _, balance := range Balances(debts) {
+		positions[balance.UserID] = balance.Amount
+	}
+	var preferred []Transfer
+	remaining := append([]Transfer{}, debts...)
+	for _, pair := range rules.Preferred {
+		if forbidden[pair] || positions[pair.PayerID] >= 0 || positions[pair.ReceiverID] <= 0 {
+			continue
+		}
+		amount := money.Min(positions[pair.PayerID].Abs(), positions[pair.ReceiverID])
+		preferred = append(preferred, Transfer{PayerID: pair.PayerID, ReceiverID: pair.ReceiverID, Amount: amount})
+		// Paying it off is the same as the receiver owing it back
+		remaining = append(remaining, Transfer{PayerID: pair.ReceiverID, ReceiverID: pair.PayerID, Amount: amount})
+		positions[pair.PayerID] += amount
+		positions[pair.ReceiverID] -= amount
+	}
+
+	plan := strategy.Settle(remaining)
+	for _, transfer := range plan {
+		if forbidden[Pair{transfer.PayerID, transfer.ReceiverID}] {
+			routed, err := route(remaining, forbidden)
+			if err != nil {
+				return nil, err
+			}
+			plan = routed
+			break
+		}
+	}
+
+	if len(preferred) == 0 {
+		return plan, nil
+	}
+	return Net(append(preferred, plan...)), nil
+}
+
+// route settles the debts with transfers that avoid the forbidden pairs. Everyone in the debts can
+// pass money on, so this is a maximum flow from those who owe to those who are owed. Each step
+// takes the largest remaining debtor and pays the nearest creditor, so direct transfers come first.
+func route(debts []Transfer, forbidden map[Pair]bool) ([]Transfer, error) {
+	seen := make(map[uint]bool)
+	var users []uint
+	for _, debt := range debts {
+		for _, userID := range []uint{debt.PayerID, debt.ReceiverID} {
+			if !seen[userID] {
+				seen[userID] = true
+				users = append(users, userID)
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+
+	n := len(users)
+	index := make(map[uint]int, n)
+	for i, userID := range users {
+		index[userID] = i
+	}
+	position := make([]money.Amount, n)
+	for _, balance := range Balances(debts) {
+		position[index[balance.UserID]] = balance.Amount
+	}
+
+	// flow[u][v] is the net amount routed from u to v, so flow[v][u] is its negative
+	flow := make([][]money.Amount, n)
+	for i := range flow {
+		flow[i] = make([]money.Amount, n)
+	}
+	allowed := func(u, v int) bool {
+		return u != v && !forbidden[Pair{users[u], users[v]}]
+	}
+	// capacity is how much more can go from u to v, or -1 when there is no limit. Money can always
+	// go back along a route that is already used, which undoes part of it.
+	capacity := func(u, v int) money.Amount {
+		if allowed(u, v) {
+			return -1
+		}
+		return max(0, flow[v][u])
+	}
+
+	for {
+		debtors := make([]int, 0, n)
+		for u := range users {
+			if position[u] < 0 {
+				debtors = append(debtors, u)
+			}
+		}
+		sort.SliceStable(debtors, func(i, j int) bool { return position[debtors[i]] < position[debtors[j]] })
+
+		routed := false
+		for _, u := range debtors {
+			path := nearestCreditor(u, n, position, capacity)
+			if path == nil {
+				continue
+			}
+			creditor := path[len(path)-1]
+			amount := money.Min(position[u].Abs(), position[creditor])
+			for i := 1; i < len(path); i++ {
+				if limit := capacity(path[i-1], path[i]); limit >= 0 {
+					amount = money.Min(amount, limit)
+				}
+			}
+			for i := 1; i < len(path); i++ {
+				flow[path[i-1]][path[i]] += amount
+				flow[path[i]][path[i-1]] -= amount
+			}
+			position[u] += amount
+			position[creditor] -= amount
+			routed = true
+			break
+		}
+		if !routed {
+			break
+		}
+	}
+
+	var unsettled []Balance
+	for u, amount := range position {
+		if amount != 0 {
+			unsettled = append(unsettled, Balance{UserID: users[u], Amount: amount})
+		}
+	}
+	if len(unsettled) > 0 {
+		return nil, &NoPlanError{Unsettled: unsettled}
+	}
+
+	cancelCycles(flow)
+	transfers := []Transfer{}
+	for u := range users {
+		for v := range users {
+			if flow[u][v] > 0 {
+				transfers = append(transfers, Transfer{PayerID: users[u], ReceiverID: users[v], Amount: flow[u][v]})
+			}
+		}
+	}
+	return transfers, nil
+}
+
+// nearestCreditor finds the shortest route from u to someone who is still owed, preferring the
+// one owed the most among equally near creditors. The path starts with u; it is nil when no
+// creditor can be reached.
+func nearestCreditor(u, n int, position []money.Amount, capacity func(u, v int) money.Amount) []int {
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = -1
+	}
+	parent[u] = u
+
+	level := []int{u}
+	for len(level) > 0 {
+		best := -1
+		var next []int
+		for _, from := range level {
+			for to := 0; to < n; to++ {
+				if parent[to] != -1 || capacity(from, to) == 0 {
+					continue
+				}
+				parent[to] = from
+				next = append(next, to)
+				if position[to] > 0 && (best == -1 || position[to] > position[best]) {
+					best = to
+				}
+			}
+		}
+		if best != -1 {
+			var path []int
+			for at := best; at != u; at = parent[at] {
+				path = append([]int{at}, path...)
+			}
+			return append([]int{u}, path...)
+		}
+		level = next
+	}
+	return nil
+}
+
+// cancelCycles removes money going round in a circle, which leaves everyone's position the same
+// with fewer transfers
+func cancelCycles(flow [][]money.Amount) {
+	for {
+		cycle := findCycle(flow)
+		if cycle == nil {
+			return
+		}
+		amount := flow[cycle[0]][cycle[1]]
+		for i := range cycle {
+			amount = money.Min(amount, flow[cycle[i]][cycle[(i+1)%len(cycle)]])
+		}
+		for i := range cycle {
+			from, to := cycle[i], cycle[(i+1)%len(cycle)]
+			flow[from][to] -= amount
+			flow[to][from] += amount
+		}
+	}
+}
+
+// findCycle returns the users on a circle of positive flows, or nil when there is none
+func findCycle(flow [][]money.Amount) []int {
+	n := len(flow)
+	state := make([]int, n) // 0 unvisited, 1 on the current path, 2 done
+	var stack []int
+	var visit func(u int) []int
+	visit = func(u int) []int {
+		state[u] = 1
+		stack = append(stack, u)
+		for v := 0; v < n; v++ {
+			if flow[u][v] <= 0 {
+				continue
+			}
+			if state[v] == 1 {
+				for i, w := range stack {
+					if w == v {
+						return append([]int{}, stack[i:]...)
+					}
+				}
+			}
+			if state[v] == 0 {
+				if cycle := visit(v); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[u] = 2
+		return nil
+	}
+	for u := 0; u < n; u++ {
+		if state[u] == 0 {
+			if cycle := visit(u); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package settle
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+func TestPlanWithoutRules(t *testing.T) {
+	got, err := Plan(exact{}, Rules{}, fiveBalances)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if want := (exact{}).Settle(fiveBalances); !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %v, want %v", got, want)
+	}
+}
+
+func TestPlanHonoursPreferredPair(t *testing.T) {
+	// Greedy breaks the tie by user ID and pairs 1 with 3 and 2 with 4
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 3, Amount: 500},
+		{PayerID: 2, ReceiverID: 4, Amount: 500},
+	}
+	rules := Rules{Preferred: []Pair{{PayerID: 1, ReceiverID: 4}}}
+
+	got, err := Plan(greedy{}, rules, debts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []Transfer{
+		{PayerID: 1, ReceiverID: 4, Amount: 500},
+		{PayerID: 2, ReceiverID: 3, Amount: 500},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %v, want %v", got, want)
+	}
+}
+
+func TestPlanSettlesPreferredPairAsFarAsItCan(t *testing.T) {
+	// 1 owes 10.01 but 4 is only owed 3.33; the rest goes to 3
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 3, Amount: 1001},
+		{PayerID: 2, ReceiverID: 4, Amount: 333},
+	}
+	rules := Rules{Preferred: []Pair{{PayerID: 1, ReceiverID: 4}}}
+
+	got, err := Plan(greedy{}, rules, debts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	checkSettles(t, debts, got)
+	if !containsTransfer(got, Transfer{PayerID: 1, ReceiverID: 4, Amount: 333}) {
+		t.Errorf("Plan() = %v, want 1 to pay 4 3.33", got)
+	}
+}
+
+func TestPlanRoutesAroundForbiddenPair(t *testing.T) {
+	// 3 is even, but can pass the money on from 1 to 2
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 3, Amount: 750},
+		{PayerID: 3, ReceiverID: 2, Amount: 750},
+	}
+	rules := Rules{Forbidden: []Pair{{PayerID: 1, ReceiverID: 2}}}
+
+	got, err := Plan(greedy{}, rules, debts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []Transfer{
+		{PayerID: 1, ReceiverID: 3, Amount: 750},
+		{PayerID: 3, ReceiverID: 2, Amount: 750},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %v, want %v", got, want)
+	}
+}
+
+func TestPlanPrefersDirectTransfersWhenRouting(t *testing.T) {
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 2, Amount: 1001},
+		{PayerID: 1, ReceiverID: 3, Amount: 500},
+		{PayerID: 4, ReceiverID: 2, Amount: 250},
+	}
+	rules := Rules{Forbidden: []Pair{{PayerID: 1, ReceiverID: 2}}}
+
+	got, err := Plan(greedy{}, rules, debts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	checkSettles(t, debts, got)
+	if containsPair(got, Pair{PayerID: 1, ReceiverID: 2}) {
+		t.Errorf("Plan() = %v uses the forbidden pair", got)
+	}
+}
+
+func TestPlanWithoutValidRouting(t *testing.T) {
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 2, Amount: 1001},
+		{PayerID: 3, ReceiverID: 4, Amount: 200},
+	}
+	rules := Rules{Forbidden: []Pair{{PayerID: 1, ReceiverID: 2}, {PayerID: 1, ReceiverID: 3}, {PayerID: 1, ReceiverID: 4}}}
+
+	_, err := Plan(exact{}, rules, debts)
+	if !errors.Is(err, ErrNoValidPlan) {
+		t.Fatalf("Plan() error = %v, want %v", err, ErrNoValidPlan)
+	}
+	var noPlan *NoPlanError
+	if !errors.As(err, &noPlan) {
+		t.Fatalf("Plan() error is %T, want *NoPlanError", err)
+	}
+	// 1 can't pay anyone, so all of their debt is left over, and as much is left owed to the others
+	var owing, owed money.Amount
+	for _, balance := range noPlan.Unsettled {
+		if balance.Amount < 0 {
+			owing += balance.Amount
+		} else {
+			owed += balance.Amount
+		}
+	}
+	if noPlan.Unsettled[0] != (Balance{UserID: 1, Amount: -1001}) || owing != -1001 || owed != 1001 {
+		t.Errorf("Unsettled = %v, want 1 to still owe 10.01 and others to be owed as much", noPlan.Unsettled)
+	}
+}
+
+func TestPlanIgnoresPreferredPairThatIsForbidden(t *testing.T) {
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 3, Amount: 400},
+		{PayerID: 3, ReceiverID: 2, Amount: 400},
+	}
+	pair := Pair{PayerID: 1, ReceiverID: 2}
+	got, err := Plan(greedy{}, Rules{Preferred: []Pair{pair}, Forbidden: []Pair{pair}}, debts)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	checkSettles(t, debts, got)
+	if containsPair(got, pair) {
+		t.Errorf("Plan() = %v uses the forbidden pair", got)
+	}
+}
+
+func TestPlanRandomRules(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for i := 0; i < 300; i++ {
+		users := 3 + random.Intn(5)
+		debts := randomDebts(random, users, 2+random.Intn(15))
+		var rules Rules
+		for j := random.Intn(4); j > 0; j-- {
+			rules.Forbidden = append(rules.Forbidden, Pair{PayerID: uint(random.Intn(users) + 1), ReceiverID: uint(random.Intn(users) + 1)})
+		}
+		for j := random.Intn(3); j > 0; j-- {
+			rules.Preferred = append(rules.Preferred, Pair{PayerID: uint(random.Intn(users) + 1), ReceiverID: uint(random.Intn(users) + 1)})
+		}
+
+		for _, strategy := range []Strategy{greedy{}, exact{}, none{}} {
+			got, err := Plan(strategy, rules, debts)
+			if errors.Is(err, ErrNoValidPlan) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			checkSettles(t, debts, got)
+			for _, pair := range rules.Forbidden {
+				if containsPair(got, pair) {
+					t.Errorf("%s plan %v uses forbidden pair %v", strategy.Name(), got, pair)
+				}
+			}
+		}
+	}
+}
+
+func containsTransfer(transfers []Transfer, want Transfer) bool {
+	for _, transfer := range transfers {
+		if transfer == want {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPair(transfers []Transfer, pair Pair) bool {
+	for _, transfer := range transfers {
+		if transfer.PayerID == pair.PayerID && transfer.ReceiverID == pair.ReceiverID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+// Package settle turns what members of a group owe each other into the transfers that pay it off.
+package settle
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+const (
+	// Greedy repeatedly lets the largest debtor pay the largest creditor. It is fast for any group
+	// size and usually, but not always, needs the fewest transfers.
+	Greedy = "greedy"
+	// Exact finds the fewest transfers possible by splitting the balances into as many groups that
+	// add up to zero as it can. Groups with more than MaxExactParticipants open balances fall back
+	// to Greedy.
+	Exact = "exact"
+	// None doesn't simplify: everyone pays back the people they owe directly, with what two people
+	// owe each other netted into one transfer
+	None = "none"
+
+	// Default is the strategy of a group that didn't choose one
+	Default = Greedy
+)
+
+// MaxExactParticipants is the most open balances the exact strategy works with; its work doubles
+// with every participant
+const MaxExactParticipants = 16
+
+var ErrUnknownStrategy = errors.New("unknown settlement strategy")
+
+// Transfer is an amount one user pays another, or owes them before it is settled
+type Transfer struct {
+	PayerID    uint
+	ReceiverID uint
+	Amount     money.Amount
+}
+
+// Balance is the net position of a user: positive when they are owed money, negative when they owe
+type Balance struct {
+	UserID uint
+	Amount money.Amount
+}
+
+// Strategy decides which transfers settle a set of debts. Every strategy leaves each user with the
+// same net balance the debts give them.
+type Strategy interface {
+	Name() string
+	Settle(debts []Transfer) []Transfer
+}
+
+// Names lists the strategies Parse accepts
+func Names() []string {
+	return []string{Greedy, Exact, None}
+}
+
+// Parse returns the strategy with the given name; an empty name gives the default
+func Parse(name string) (Strategy, error) {
+	switch name {
+	case "", Greedy:
+		return greedy{}, nil
+	case Exact:
+		return exact{}, nil
+	case None:
+		return none{}, nil
+	}
+	return nil, fmt.Errorf("%w %q, expected one of %v", ErrUnknownStrategy, name, Names())
+}
+
+// Balances adds up the net balance of each user in the debts, leaving out users who are even.
+// They are sorted by user ID so the same debts always settle the same way.
+func Balances(debts []Transfer) []Balance {
+	totals := make(map[uint]money.Amount)
+	for _, debt := range debts {
+		totals[debt.PayerID] -= debt.Amount
+		totals[debt.ReceiverID] += debt.Amount
+	}
+
+	balances := []Balance{}
+	for userID, amount := range totals {
+		if amount != 0 {
+			balances = append(balances, Balance{UserID: userID, Amount: amount})
+		}
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].UserID < balances[j].UserID })
+	return balances
+}
+
+// Net combines the debts between each pair of users into a single transfer from whoever owes more,
+// sorted by payer and receiver
+func Net(debts []Transfer) []Transfer {
+	type pair struct{ low, high uint }
+	totals := make(map[pair]money.Amount)
+	for _, debt := range debts {
+		if debt.PayerID == debt.ReceiverID {
+			continue
+		}
+		// Positive means the lower user ID owes the higher one
+		if debt.PayerID < debt.ReceiverID {
+			totals[pair{debt.PayerID, debt.ReceiverID}] += debt.Amount
+		} else {
+			totals[pair{debt.ReceiverID, debt.PayerID}] -= debt.Amount
+		}
+	}
+
+	transfers := []Transfer{}
+	for users, amount := range totals {
+		switch {
+		case amount > 0:
+			transfers = append(transfers, Transfer{PayerID: users.low, ReceiverID: users.high, Amount: amount})
+		case amount < 0:
+			transfers = append(transfers, Transfer{PayerID: users.high, ReceiverID: users.low, Amount: -amount})
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].PayerID != transfers[j].PayerID {
+			return transfers[i].PayerID < transfers[j].PayerID
+		}
+		return transfers[i].ReceiverID < transfers[j].ReceiverID
+	})
+	return transfers
+}
+
+type none struct{}
+
+func (none) Name() string { return None }
+
+func (none) Settle(debts []Transfer) []Transfer {
+	return Net(debts)
+}
@@ -0,0 +1,150 @@
+package settle
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// fiveBalances gives 1:+5.00, 2:+4.00, 3:-4.00, 4:-3.00, 5:-2.00. Greedy pays the largest debt to
+// the largest credit first and needs four transfers; {1,4,5} and {2,3} settle in three.
+var fiveBalances = []Transfer{
+	{PayerID: 3, ReceiverID: 1, Amount: 400},
+	{PayerID: 4, ReceiverID: 1, Amount: 100},
+	{PayerID: 4, ReceiverID: 2, Amount: 200},
+	{PayerID: 5, ReceiverID: 2, Amount: 200},
+}
+
+// checkSettles fails unless the transfers leave everyone with the balance the debts give them
+func checkSettles(t *testing.T, debts, transfers []Transfer) {
+	t.Helper()
+	for _, transfer := range transfers {
+		if transfer.Amount <= 0 || transfer.PayerID == transfer.ReceiverID {
+			t.Errorf("invalid transfer %+v", transfer)
+		}
+	}
+	if got, want := Balances(transfers), Balances(debts); !reflect.DeepEqual(got, want) {
+		t.Errorf("transfers give balances %v, want %v", got, want)
+	}
+}
+
+// randomDebts returns up to n debts between up to users members, with amounts in odd cents
+func randomDebts(random *rand.Rand, users, n int) []Transfer {
+	debts := make([]Transfer, 0, n)
+	for len(debts) < n {
+		payer, receiver := uint(random.Intn(users)+1), uint(random.Intn(users)+1)
+		if payer == receiver {
+			continue
+		}
+		debts = append(debts, Transfer{PayerID: payer, ReceiverID: receiver, Amount: money.Amount(random.Intn(10000) + 1)})
+	}
+	return debts
+}
+
+func TestParse(t *testing.T) {
+	for name, want := range map[string]string{"": Greedy, Greedy: Greedy, Exact: Exact, None: None} {
+		strategy, err := Parse(name)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", name, err)
+		}
+		if strategy.Name() != want {
+			t.Errorf("Parse(%q) = %s, want %s", name, strategy.Name(), want)
+		}
+	}
+	if _, err := Parse("fewest"); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("Parse(\"fewest\") error = %v, want %v", err, ErrUnknownStrategy)
+	}
+}
+
+func TestBalances(t *testing.T) {
+	debts := []Transfer{
+		{PayerID: 2, ReceiverID: 1, Amount: 333},
+		{PayerID: 3, ReceiverID: 1, Amount: 334},
+		{PayerID: 1, ReceiverID: 3, Amount: 334},
+	}
+	want := []Balance{{UserID: 1, Amount: 333}, {UserID: 2, Amount: -333}}
+	if got := Balances(debts); !reflect.DeepEqual(got, want) {
+		t.Errorf("Balances() = %v, want %v", got, want)
+	}
+}
+
+func TestNet(t *testing.T) {
+	debts := []Transfer{
+		{PayerID: 1, ReceiverID: 2, Amount: 1001},
+		{PayerID: 2, ReceiverID: 1, Amount: 250},
+		{PayerID: 3, ReceiverID: 2, Amount: 100},
+		{PayerID: 2, ReceiverID: 3, Amount: 100},
+		{PayerID: 3, ReceiverID: 3, Amount: 50},
+	}
+	want := []Transfer{{PayerID: 1, ReceiverID: 2, Amount: 751}}
+	if got := Net(debts); !reflect.DeepEqual(got, want) {
+		t.Errorf("Net() = %v, want %v", got, want)
+	}
+}
+
+func TestGreedy(t *testing.T) {
+	want := []Transfer{
+		{PayerID: 3, ReceiverID: 1, Amount: 400},
+		{PayerID: 4, ReceiverID: 2, Amount: 300},
+		{PayerID: 5, ReceiverID: 1, Amount: 100},
+		{PayerID: 5, ReceiverID: 2, Amount: 100},
+	}
+	if got := (greedy{}).Settle(fiveBalances); !reflect.DeepEqual(got, want) {
+		t.Errorf("greedy Settle() = %v, want %v", got, want)
+	}
+}
+
+func TestExact(t *testing.T) {
+	got := (exact{}).Settle(fiveBalances)
+	checkSettles(t, fiveBalances, got)
+	if len(got) != 3 {
+		t.Errorf("exact Settle() = %v, want 3 transfers", got)
+	}
+}
+
+func TestExactWithoutDebts(t *testing.T) {
+	even := []Transfer{{PayerID: 1, ReceiverID: 2, Amount: 500}, {PayerID: 2, ReceiverID: 1, Amount: 500}}
+	if got := (exact{}).Settle(even); got == nil || len(got) != 0 {
+		t.Errorf("exact Settle() = %#v, want no transfers", got)
+	}
+}
+
+func TestExactFallsBackToGreedy(t *testing.T) {
+	var debts []Transfer
+	for i := uint(1); i <= MaxExactParticipants+1; i++ {
+		debts = append(debts, Transfer{PayerID: i, ReceiverID: 100, Amount: money.Amount(i * 101)})
+	}
+	got := (exact{}).Settle(debts)
+	if want := (greedy{}).Settle(debts); !reflect.DeepEqual(got, want) {
+		t.Errorf("exact Settle() above the limit = %v, want greedy %v", got, want)
+	}
+}
+
+func TestNone(t *testing.T) {
+	if got := (none{}).Settle(fiveBalances); !reflect.DeepEqual(got, Net(fiveBalances)) {
+		t.Errorf("none Settle() = %v, want %v", got, Net(fiveBalances))
+	}
+}
+
+func TestStrategiesSettleRandomDebts(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		debts := randomDebts(random, 2+random.Intn(8), 1+random.Intn(20))
+
+		greedyPlan := (greedy{}).Settle(debts)
+		exactPlan := (exact{}).Settle(debts)
+		checkSettles(t, debts, greedyPlan)
+		checkSettles(t, debts, exactPlan)
+		checkSettles(t, debts, (none{}).Settle(debts))
+
+		if len(exactPlan) > len(greedyPlan) {
+			t.Errorf("exact needs %d transfers and greedy %d for %v", len(exactPlan), len(greedyPlan), debts)
+		}
+		if n := len(Balances(debts)); n > 0 && len(greedyPlan) > n-1 {
+			t.Errorf("greedy needs %d transfers for %d balances", len(greedyPlan), n)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package split
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+// Itemized derives shares from line items instead of participant values
+const Itemized Mode = "itemized"
+
+// ChargeMode decides how tax, tip and service charge are spread over the people who had items
+type ChargeMode string
+
+const (
+	// ChargesProportional splits charges in proportion to each person's item subtotal
+	ChargesProportional ChargeMode = "proportional"
+	// ChargesEqual splits charges evenly between everyone who had an item
+	ChargesEqual ChargeMode = "equal"
+)
+
+var (
+	ErrNoItems             = errors.New("at least one item is required")
+	ErrItemWithoutUsers    = errors.New("every item must be assigned to at least one member")
+	ErrNonPositiveItem     = errors.New("item amounts must be positive")
+	ErrNegativeCharge      = errors.New("charges cannot be negative")
+	ErrUnknownChargeMode   = errors.New("unknown charge split")
+	ErrItemizedParticipant = errors.New("itemized splits are computed from items, not participants")
+)
+
+type Item struct {
+	Amount  money.Amount
+	UserIDs []uint
+}
+
+type ItemizedResult struct {
+	// Shares holds each person's subtotal plus their part of the charges, ordered by user ID
+	Shares []Share
+	// ItemShares holds, per item, what each assignee owes for it (ordered by user ID)
+	ItemShares [][]Share
+	Total      money.Amount
+}
+
+// ParseChargeMode validates a charge split, defaulting to proportional
+func ParseChargeMode(mode string) (ChargeMode, error) {
+	switch ChargeMode(mode) {
+	case "":
+		return ChargesProportional, nil
+	case ChargesProportional, ChargesEqual:
+		return ChargeMode(mode), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownChargeMode, mode)
+}
+
+// ComputeItemized splits each item evenly between its assignees and spreads charges on top.
+// The shares add up to the sum of the items plus the charges.
+func ComputeItemized(items []Item, charges money.Amount, chargeMode ChargeMode) (ItemizedResult, error) {
+	if len(items) == 0 {
+		return ItemizedResult{}, ErrNoItems
+	}
+	if charges < 0 {
+		return ItemizedResult{}, ErrNegativeCharge
+	}
+
+	subtotals := make(map[uint]money.Amount)
+	result := ItemizedResult{ItemShares: make([][]Share, len(items))}
+
+	for i, item := range items {
+		if item.Amount <= 0 {
+			return ItemizedResult{}, ErrNonPositiveItem
+		}
+		if len(item.UserIDs) == 0 {
+			return ItemizedResult{}, ErrItemWithoutUsers
+		}
+
+		userIDs := make([]uint, len(item.UserIDs))
+		copy(userIDs, item.UserIDs)
+		sort.Slice(userIDs, func(a, b int) bool { return userIDs[a] < userIDs[b] })
+		for j := 1; j < len(userIDs); j++ {
+			if userIDs[j] == userIDs[j-1] {
+				return ItemizedResult{}, fmt.Errorf("%w: user %d", ErrDuplicateUser, userIDs[j])
+			}
+		}
+
+		portions, err := money.AllocateEvenly(item.Amount, len(userIDs))
+		if err != nil {
+			return ItemizedResult{}, err
+		}
+		for j, userID := range userIDs {
+			subtotals[userID] += portions[j]
+			result.ItemShares[i] = append(result.ItemShares[i], Share{UserID: userID, Amount: portions[j]})
+		}
+		result.Total += item.Amount
+	}
+
+	userIDs := make([]uint, 0, len(subtotals))
+	for userID := range subtotals {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(a, b int) bool { return userIDs[a] < userIDs[b] })
+
+	weights := make([]int64, len(userIDs))
+	for i, userID := range userIDs {
+		weights[i] = int64(subtotals[userID])
+		if chargeMode == ChargesEqual {
+			weights[i] = 1
+		}
+	}
+
+	chargeParts := make([]money.Amount, len(userIDs))
+	if charges > 0 {
+		var err error
+		chargeParts, err = money.Allocate(charges, weights)
+		if err != nil {
+			return ItemizedResult{}, err
+		}
+	}
+
+	for i, userID := range userIDs {
+		result.Shares = append(result.Shares, Share{UserID: userID, Amount: subtotals[userID] + chargeParts[i]})
+	}
+	result.Total += charges
+
+	return result, nil
+}
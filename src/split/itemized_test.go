@@ -0,0 +1,105 @@
+package split
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+func TestComputeItemized(t *testing.T) {
+	items := []Item{
+		{Amount: 1000, UserIDs: []uint{2, 1}},
+		{Amount: 500, UserIDs: []uint{3}},
+		{Amount: 100, UserIDs: []uint{1, 2, 3}},
+	}
+
+	tests := []struct {
+		name       string
+		charges    money.Amount
+		chargeMode ChargeMode
+		want       []Share
+	}{
+		{
+			name:       "no charges",
+			chargeMode: ChargesProportional,
+			want:       []Share{{UserID: 1, Amount: 534}, {UserID: 2, Amount: 533}, {UserID: 3, Amount: 533}},
+		},
+		{
+			name:       "proportional charges",
+			charges:    160,
+			chargeMode: ChargesProportional,
+			want:       []Share{{UserID: 1, Amount: 588}, {UserID: 2, Amount: 586}, {UserID: 3, Amount: 586}},
+		},
+		{
+			name:       "equal charges that don't divide evenly",
+			charges:    100,
+			chargeMode: ChargesEqual,
+			want:       []Share{{UserID: 1, Amount: 568}, {UserID: 2, Amount: 566}, {UserID: 3, Amount: 566}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ComputeItemized(items, test.charges, test.chargeMode)
+			if err != nil {
+				t.Fatalf("ComputeItemized() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Shares, test.want) {
+				t.Errorf("ComputeItemized() shares = %v, want %v", result.Shares, test.want)
+			}
+			if want := 1600 + test.charges; result.Total != want {
+				t.Errorf("ComputeItemized() total = %d, want %d", result.Total, want)
+			}
+			var sum money.Amount
+			for _, share := range result.Shares {
+				sum += share.Amount
+			}
+			if sum != result.Total {
+				t.Errorf("shares add up to %d, want %d", sum, result.Total)
+			}
+		})
+	}
+}
+
+func TestComputeItemizedItemShares(t *testing.T) {
+	result, err := ComputeItemized([]Item{{Amount: 1001, UserIDs: []uint{3, 1}}}, 0, ChargesProportional)
+	if err != nil {
+		t.Fatalf("ComputeItemized() error = %v", err)
+	}
+	want := [][]Share{{{UserID: 1, Amount: 501}, {UserID: 3, Amount: 500}}}
+	if !reflect.DeepEqual(result.ItemShares, want) {
+		t.Errorf("ComputeItemized() item shares = %v, want %v", result.ItemShares, want)
+	}
+}
+
+func TestComputeItemizedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []Item
+		charges money.Amount
+		err     error
+	}{
+		{"no items", nil, 0, ErrNoItems},
+		{"negative charges", []Item{{Amount: 100, UserIDs: []uint{1}}}, -1, ErrNegativeCharge},
+		{"zero item", []Item{{Amount: 0, UserIDs: []uint{1}}}, 0, ErrNonPositiveItem},
+		{"item without users", []Item{{Amount: 100}}, 0, ErrItemWithoutUsers},
+		{"duplicate assignee", []Item{{Amount: 100, UserIDs: []uint{1, 1}}}, 0, ErrDuplicateUser},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ComputeItemized(test.items, test.charges, ChargesProportional); !errors.Is(err, test.err) {
+				t.Errorf("ComputeItemized() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseChargeMode(t *testing.T) {
+	if got, err := ParseChargeMode(""); err != nil || got != ChargesProportional {
+		t.Errorf("ParseChargeMode(\"\") = %q, %v, want %q", got, err, ChargesProportional)
+	}
+	if _, err := ParseChargeMode("random"); !errors.Is(err, ErrUnknownChargeMode) {
+		t.Errorf("ParseChargeMode(\"random\") error = %v, want %v", err, ErrUnknownChargeMode)
+	}
+}
@@ -0,0 +1,165 @@
+// Package split computes how an expense amount is divided between its participants.
+package split
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+type Mode string
+
+const (
+	// Equal divides the amount evenly; participant values are ignored
+	Equal Mode = "equal"
+	// Exact uses participant values as amounts, which must add up to the total
+	Exact Mode = "exact"
+	// Percentage uses participant values as basis points (3333 = 33.33%), which must add up to 10000
+	Percentage Mode = "percentage"
+	// Shares uses participant values as weights, e.g. 2 shares for a couple and 1 for a single
+	Shares Mode = "shares"
+	// Adjustment splits evenly after applying each participant's value as a +/- adjustment
+	Adjustment Mode = "adjustment"
+)
+
+const fullPercentage = 10000
+
+var (
+	ErrUnknownMode        = errors.New("unknown split mode")
+	ErrNoParticipants     = errors.New("at least one participant is required")
+	ErrDuplicateUser      = errors.New("participant listed more than once")
+	ErrNegativeValue      = errors.New("participant value cannot be negative")
+	ErrNonPositiveTotal   = errors.New("amount must be positive")
+	ErrExactMismatch      = errors.New("exact amounts must add up to the expense amount")
+	ErrPercentageMismatch = errors.New("percentages must add up to 100% (10000 basis points)")
+	ErrNoShares           = errors.New("at least one participant needs a positive number of shares")
+	ErrAdjustmentTooLarge = errors.New("adjustments exceed the expense amount")
+)
+
+type Participant struct {
+	UserID uint
+	Value  int64
+}
+
+type Share struct {
+	UserID uint
+	Amount money.Amount
+	Value  int64
+}
+
+// ParseMode validates a split mode name
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case Equal, Exact, Percentage, Shares, Adjustment, Itemized:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+}
+
+// Compute divides total between the participants according to mode.
+// Participants are ordered by user ID first, so leftover minor units always go to the same people
+// regardless of the order the client sent them in. The returned shares always add up to total.
+func Compute(mode Mode, total money.Amount, participants []Participant) ([]Share, error) {
+	if total <= 0 {
+		return nil, ErrNonPositiveTotal
+	}
+	if len(participants) == 0 {
+		return nil, ErrNoParticipants
+	}
+
+	sorted := make([]Participant, len(participants))
+	copy(sorted, participants)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+
+	for i, participant := range sorted {
+		if i > 0 && sorted[i-1].UserID == participant.UserID {
+			return nil, fmt.Errorf("%w: user %d", ErrDuplicateUser, participant.UserID)
+		}
+		if participant.Value < 0 && mode != Adjustment {
+			return nil, fmt.Errorf("%w: user %d", ErrNegativeValue, participant.UserID)
+		}
+	}
+
+	var amounts []money.Amount
+	var err error
+
+	switch mode {
+	case Equal:
+		amounts, err = money.AllocateEvenly(total, len(sorted))
+	case Exact:
+		amounts, err = computeExact(total, sorted)
+	case Percentage:
+		amounts, err = computeWeighted(total, sorted, fullPercentage, ErrPercentageMismatch)
+	case Shares:
+		amounts, err = computeWeighted(total, sorted, 0, ErrNoShares)
+	case Adjustment:
+		amounts, err = computeAdjusted(total, sorted)
+	case Itemized:
+		return nil, ErrItemizedParticipant
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]Share, len(sorted))
+	for i, participant := range sorted {
+		shares[i] = Share{UserID: participant.UserID, Amount: amounts[i], Value: participant.Value}
+		if mode == Equal {
+			shares[i].Value = 0
+		}
+	}
+	return shares, nil
+}
+
+func computeExact(total money.Amount, participants []Participant) ([]money.Amount, error) {
+	amounts := make([]money.Amount, len(participants))
+	for i, participant := range participants {
+		amounts[i] = money.Amount(participant.Value)
+	}
+	if money.Sum(amounts) != total {
+		return nil, ErrExactMismatch
+	}
+	return amounts, nil
+}
+
+// computeWeighted allocates by weight; requiredSum of 0 means any positive sum is accepted
+func computeWeighted(total money.Amount, participants []Participant, requiredSum int64, mismatch error) ([]money.Amount, error) {
+	weights := make([]int64, len(participants))
+	var sum int64
+	for i, participant := range participants {
+		weights[i] = participant.Value
+		sum += participant.Value
+	}
+	if sum == 0 || (requiredSum != 0 && sum != requiredSum) {
+		return nil, mismatch
+	}
+	return money.Allocate(total, weights)
+}
+
+func computeAdjusted(total money.Amount, participants []Participant) ([]money.Amount, error) {
+	var adjustments money.Amount
+	for _, participant := range participants {
+		adjustments += money.Amount(participant.Value)
+	}
+
+	rest := total - adjustments
+	if rest < 0 {
+		return nil, ErrAdjustmentTooLarge
+	}
+
+	amounts, err := money.AllocateEvenly(rest, len(participants))
+	if err != nil {
+		return nil, err
+	}
+	for i, participant := range participants {
+		amounts[i] += money.Amount(participant.Value)
+		if amounts[i] < 0 {
+			return nil, fmt.Errorf("%w: user %d would owe a negative amount", ErrAdjustmentTooLarge, participant.UserID)
+		}
+	}
+	return amounts, nil
+}
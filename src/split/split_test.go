@@ -0,0 +1,113 @@
+package split
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tjens23/tabsplit-backend/src/money"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         Mode
+		total        money.Amount
+		participants []Participant
+		want         []Share
+	}{
+		{
+			name:         "equal gives the leftover cent to the lowest user ID",
+			mode:         Equal,
+			total:        1000,
+			participants: []Participant{{UserID: 3, Value: 7}, {UserID: 1}, {UserID: 2}},
+			want:         []Share{{UserID: 1, Amount: 334}, {UserID: 2, Amount: 333}, {UserID: 3, Amount: 333}},
+		},
+		{
+			name:         "exact",
+			mode:         Exact,
+			total:        1000,
+			participants: []Participant{{UserID: 1, Value: 250}, {UserID: 2, Value: 750}},
+			want:         []Share{{UserID: 1, Amount: 250, Value: 250}, {UserID: 2, Amount: 750, Value: 750}},
+		},
+		{
+			name:         "percentage with cents that don't divide evenly",
+			mode:         Percentage,
+			total:        1001,
+			participants: []Participant{{UserID: 1, Value: 3333}, {UserID: 2, Value: 3333}, {UserID: 3, Value: 3334}},
+			want:         []Share{{UserID: 1, Amount: 334, Value: 3333}, {UserID: 2, Amount: 333, Value: 3333}, {UserID: 3, Amount: 334, Value: 3334}},
+		},
+		{
+			name:         "shares",
+			mode:         Shares,
+			total:        900,
+			participants: []Participant{{UserID: 1, Value: 2}, {UserID: 2, Value: 1}},
+			want:         []Share{{UserID: 1, Amount: 600, Value: 2}, {UserID: 2, Amount: 300, Value: 1}},
+		},
+		{
+			name:         "adjustment",
+			mode:         Adjustment,
+			total:        1000,
+			participants: []Participant{{UserID: 1, Value: 200}, {UserID: 2, Value: -100}, {UserID: 3}},
+			want:         []Share{{UserID: 1, Amount: 500, Value: 200}, {UserID: 2, Amount: 200, Value: -100}, {UserID: 3, Amount: 300}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Compute(test.mode, test.total, test.participants)
+			if err != nil {
+				t.Fatalf("Compute() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Compute() = %v, want %v", got, test.want)
+			}
+			var sum money.Amount
+			for _, share := range got {
+				sum += share.Amount
+			}
+			if sum != test.total {
+				t.Errorf("shares add up to %d, want %d", sum, test.total)
+			}
+		})
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         Mode
+		total        money.Amount
+		participants []Participant
+		err          error
+	}{
+		{"non-positive total", Equal, 0, []Participant{{UserID: 1}}, ErrNonPositiveTotal},
+		{"no participants", Equal, 100, nil, ErrNoParticipants},
+		{"duplicate user", Equal, 100, []Participant{{UserID: 1}, {UserID: 1}}, ErrDuplicateUser},
+		{"negative value", Shares, 100, []Participant{{UserID: 1, Value: -1}}, ErrNegativeValue},
+		{"exact mismatch", Exact, 100, []Participant{{UserID: 1, Value: 60}, {UserID: 2, Value: 30}}, ErrExactMismatch},
+		{"percentage mismatch", Percentage, 100, []Participant{{UserID: 1, Value: 5000}, {UserID: 2, Value: 4000}}, ErrPercentageMismatch},
+		{"no shares", Shares, 100, []Participant{{UserID: 1}, {UserID: 2}}, ErrNoShares},
+		{"adjustments above total", Adjustment, 100, []Participant{{UserID: 1, Value: 150}, {UserID: 2}}, ErrAdjustmentTooLarge},
+		{"adjustment below zero", Adjustment, 100, []Participant{{UserID: 1, Value: -200}, {UserID: 2}}, ErrAdjustmentTooLarge},
+		{"itemized needs items", Itemized, 100, []Participant{{UserID: 1}}, ErrItemizedParticipant},
+		{"unknown mode", Mode("thirds"), 100, []Participant{{UserID: 1}}, ErrUnknownMode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Compute(test.mode, test.total, test.participants); !errors.Is(err, test.err) {
+				t.Errorf("Compute() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []Mode{Equal, Exact, Percentage, Shares, Adjustment, Itemized} {
+		if got, err := ParseMode(string(mode)); err != nil || got != mode {
+			t.Errorf("ParseMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if _, err := ParseMode("thirds"); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("ParseMode(\"thirds\") error = %v, want %v", err, ErrUnknownMode)
+	}
+}
@@ -0,0 +1,49 @@
+// Package storage keeps uploaded files outside the database.
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+var ErrNotFound = errors.New("file not found")
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage saves, opens and deletes files by key. Keys are generated by NewKey and may contain
+// slashes to group files.
+type Storage interface {
+	Save(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Default is the application-wide storage, set up by Init
+var Default Storage = Local{Dir: "uploads"}
+
+// Init configures the default storage from the environment.
+// UPLOAD_DIR is the directory for the local disk storage (defaults to uploads).
+func Init() error {
+	dir := os.Getenv("UPLOAD_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	Default = Local{Dir: dir}
+	return nil
+}
+
+// NewKey returns a random key with the given prefix and extension, e.g. attachments/3f9c….jpg
+func NewKey(prefix, extension string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + "/" + hex.EncodeToString(buf) + extension, nil
+}
@@ -0,0 +1,78 @@
+// Package thumbnail makes small JPEG previews of uploaded images.
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// MaxSize is the largest width or height of a thumbnail
+const MaxSize = 256
+
+// maxPixels guards against images that are small files but huge when decoded
+const maxPixels = 50_000_000
+
+// Supported reports whether thumbnails can be made for a content type
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Make decodes an image and writes a JPEG no larger than MaxSize x MaxSize, keeping the aspect ratio
+func Make(w io.Writer, r io.Reader) error {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(w, resize(src), &jpeg.Options{Quality: 80})
+}
+
+// DecodeConfigOK checks the image dimensions before decoding the whole image
+func DecodeConfigOK(r io.Reader) bool {
+	config, _, err := image.DecodeConfig(r)
+	return err == nil && config.Width > 0 && config.Height > 0 && config.Width*config.Height <= maxPixels
+}
+
+// resize scales src down by averaging the source pixels that fall into each target pixel
+func resize(src image.Image) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	targetWidth, targetHeight := width, height
+	if width > MaxSize || height > MaxSize {
+		if width >= height {
+			targetWidth, targetHeight = MaxSize, max(1, height*MaxSize/width)
+		} else {
+			targetWidth, targetHeight = max(1, width*MaxSize/height), MaxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := bounds.Min.Y + y*height/targetHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			x0 := bounds.Min.X + x*width/targetWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/targetWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			// Colors are premultiplied, so adding the missing alpha puts transparent areas on white
+			white := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{uint16(r/n + white), uint16(g/n + white), uint16(b/n + white), 0xffff})
+		}
+	}
+	return dst
+}